@@ -1,28 +1,100 @@
 package main
 
 import (
+	"flag"
+	"github.com/frankh/arachnacoin/crypto"
 	"github.com/frankh/arachnacoin/node"
+	"github.com/frankh/arachnacoin/rpc"
+	"github.com/frankh/arachnacoin/signer"
 	"github.com/frankh/arachnacoin/store"
 	"github.com/frankh/arachnacoin/transaction"
 	"github.com/frankh/arachnacoin/work"
 	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 )
 
-var memPool []*transaction.Transaction
+var dbPath = flag.String("db", "db.sqlite", "path to the node's database")
+var rpcAddress = flag.String("rpc", rpc.DefaultAddress, "address of the node's rpc server")
+var networkName = flag.String("network", crypto.MainNet.Name, "network to use, main or regtest")
+var signerFlag = flag.String("signer", "", "signer process for watch-only keys, unix:<socket> or a command to run, see the signer command")
+var portFlag = flag.String("port", node.DefaultPort, "port to listen for peers on")
+var multicastFlag = flag.Bool("multicast", true, "find peers on the local network by udp multicast")
+var addNodes listFlag
+var seeds listFlag
+
+func init() {
+	flag.Var(&addNodes, "addnode", "peer to keep connected to, host[:port], can be repeated")
+	flag.Var(&seeds, "seed", "host whose addresses to connect to when there are no peers, can be repeated")
+}
+
+var walletFlag = flag.String("wallet", "", "wallet for wallet commands to act on, defaults to the node's default wallet")
 
 func main() {
+	flag.Usage = usage
+	flag.Parse()
+	network, ok := crypto.Networks[*networkName]
+	if !ok {
+		fail("Unknown network %q", *networkName)
+	}
+	crypto.ActiveNetwork = network
+	work.Difficulty = network.Difficulty
+
+	if flag.NArg() > 0 {
+		runCommand(flag.Args())
+		return
+	}
+
 	log.Printf("Arachnacoin starting up...")
+	// Peers are served from the database, so it's opened before listening
+	store.Init(*dbPath)
+	go shutdownOnSignal()
+	node.ListenPort = *portFlag
 	go node.PeerServer()
-	go node.ListenForPeers()
-	go node.BroadcastForPeers()
-	store.Init("db.sqlite")
+	if *multicastFlag {
+		go node.ListenForPeers()
+		go node.BroadcastForPeers()
+	}
+	if *signerFlag != "" {
+		store.ExternalSigner = signer.NewRemote(*signerFlag)
+	}
+	for _, name := range store.WalletNames() {
+		if store.IsWatchOnly(name) {
+			continue
+		}
+		found, err := store.ScanWallet(name)
+		if err != nil {
+			log.Printf("Skipping address scan of wallet %s: %s", name, err)
+		} else if found > 0 {
+			log.Printf("Found %d used addresses in wallet %s", found, name)
+		}
+	}
+	go node.DownloadBlocks()
+	for _, address := range addNodes {
+		err := node.AddNode(address)
+		if err != nil {
+			log.Printf("Couldn't add peer %s: %s", address, err)
+		}
+	}
+	go node.ConnectOutbound()
+	go node.KeepPeersAlive()
+	if len(seeds) == 0 {
+		seeds = node.DefaultSeeds
+	}
+	if len(seeds) > 0 {
+		go node.ConnectSeeds(seeds)
+	}
+	go rpc.Serve(*rpcAddress)
 	head := store.FetchHighestBlock()
 	log.Printf("Initialised... Longest chain is height %d", head.Height)
-	log.Printf("Balance: %d", store.GetBalance(store.MyWallet.Address()))
+	log.Printf("Balance: %s", transaction.FormatAmount(store.GetBalance(store.RewardAddress())))
 
 	for {
-		log.Printf("%d transactions in mempool", len(memPool))
-		newBlock := work.Mine(head, make([]transaction.Transaction, 0), store.MyWallet.Address())
+		pending := node.PendingTransactions()
+		log.Printf("%d transactions in mempool", len(pending))
+		newBlock := work.Mine(head, pending, store.RewardAddress())
 		log.Printf("Mined new block, new height %d", newBlock.Height)
 		store.StoreBlock(newBlock)
 		node.BroadcastLatestBlock()
@@ -30,6 +102,28 @@ func main() {
 		if head.HashString() != newBlock.HashString() {
 			log.Printf("Block was orphaned :(")
 		}
-		log.Printf("Balance: %d", store.GetBalance(store.MyWallet.Address()))
+		log.Printf("Balance: %s", transaction.FormatAmount(store.GetBalance(store.RewardAddress())))
 	}
 }
+
+// A flag that can be given more than once
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// Disconnects from peers cleanly when the node is interrupted
+func shutdownOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+	log.Printf("Shutting down...")
+	node.Peers.Shutdown()
+	os.Exit(0)
+}
@@ -6,7 +6,12 @@ import (
 	"github.com/frankh/arachnacoin/transaction"
 )
 
-const BlockReward = uint32(5000)
+const BlockReward = 5000 * transaction.Coin
+
+// Most bytes a block can take encoded for the network, see wire.BlockSize.
+// It's well under the limit on block messages, so any valid block can be
+// relayed.
+const MaxBlockSize = 1 << 20
 
 var GenesisBlock = Block{
 	"00000000000000000000000000000000",
@@ -23,13 +28,25 @@ type Block struct {
 }
 
 func (b *Block) Hash() []byte {
+	transactionHashes := make([][]byte, len(b.Transactions))
+	for i, t := range b.Transactions {
+		transactionHashes[i] = t.Hash()
+	}
+
+	return HashFromParts(b.Previous, transactionHashes)
+}
+
+// Computes a block's hash from the previous block's hash and the hashes of
+// its transactions, so that a transaction can be shown to be in a block
+// without the other transactions themselves.
+func HashFromParts(previous string, transactionHashes [][]byte) []byte {
 	h := sha512.New()
-	prevBytes, _ := hex.DecodeString(b.Previous)
+	prevBytes, _ := hex.DecodeString(previous)
 
 	h.Write(prevBytes)
 
-	for _, t := range b.Transactions {
-		h.Write(t.Hash())
+	for _, hash := range transactionHashes {
+		h.Write(hash)
 	}
 
 	return h.Sum(nil)
@@ -0,0 +1,15 @@
+package block
+
+// A block without its transactions. The work is over the hash, and the
+// hash covers the transactions, so a header's work can be checked before
+// fetching the rest of its block.
+type Header struct {
+	Hash     string `json:"hash"`
+	Previous string `json:"previous"`
+	Work     uint32 `json:"work"`
+	Height   uint32 `json:"height"`
+}
+
+func (b *Block) Header() Header {
+	return Header{b.HashString(), b.Previous, b.Work, b.Height}
+}
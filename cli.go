@@ -0,0 +1,1036 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/frankh/arachnacoin/crypto"
+	"github.com/frankh/arachnacoin/node"
+	"github.com/frankh/arachnacoin/rpc"
+	"github.com/frankh/arachnacoin/script"
+	"github.com/frankh/arachnacoin/signer"
+	"github.com/frankh/arachnacoin/store"
+	"github.com/frankh/arachnacoin/transaction"
+	"github.com/frankh/arachnacoin/vanity"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const usageText = `Usage: arachnacoin [flags] [command]
+
+Runs a node when no command is given. Commands talk to a running node
+over rpc.
+
+Commands:
+  address decode <address>       Show the version and payload of an address
+
+  wallet list                    List the node's wallets and their balances
+  wallet new <name>              Add a wallet with a new seed
+  wallet default <name>          Use a wallet when -wallet isn't given
+  wallet address                 Show the wallet's first address
+  wallet newaddress [account] [label]
+                                 Derive a new address from the wallet seed
+  wallet addresses               List the wallet's addresses and balances
+  wallet label <address> <label> Label one of the wallets' addresses
+  wallet watch <address> [label] Follow an address whose key is kept elsewhere,
+                                 creating the wallet if needed
+  wallet importsigner            Watch the keys held by the node's -signer, so
+                                 it can spend from them, creating the wallet
+                                 if needed
+  wallet balance [address]       Show the balance of the wallet or an address
+  wallet history                 List transactions affecting the wallet
+  wallet send <address> <amount> [memo]
+                                 Pay an address, optionally attaching a memo
+  wallet sendfrom <from> <address> <amount> [memo]
+                                 Pay from one of the wallets' addresses
+  wallet signmessage <address> <message>
+                                 Sign a message to prove control of an address
+  wallet verifymessage <address> <signature> <message>
+                                 Check a signed message, run without a node
+  wallet rescan                  Find used addresses derived from the seed
+  wallet policy                  Show the wallet's spending policy
+  wallet policy daily|max|confirm <amount>
+                                 Limit spending per 24 hours or per payment, or
+                                 ask before payments over amount, 0 for no limit
+  wallet policy allow|disallow <address>
+                                 Only allow payments to listed addresses, any
+                                 if none are listed
+  wallet reward <address>        Pay mining rewards to one of the wallets' addresses
+  wallet encrypt                 Encrypt every wallet's keys with a passphrase
+  wallet unlock [seconds]        Unlock the wallets for signing, default 300 seconds
+  wallet lock                    Lock the wallets
+  wallet passphrase              Change the wallets' passphrase
+  wallet backup                  Show the wallet's mnemonic phrase, keep it secret
+  wallet seed                    Show the wallet seed, keep it secret
+  wallet create                  Create a wallet with a new phrase and optional
+                                 passphrase in the database, run without a node
+  wallet restore <phrase|seed>   Restore a wallet from its phrase or hex seed
+                                 into the database, run without a node
+
+  tx create <from> <address> <amount> [memo]
+                                 Build an unsigned transaction from any address
+  tx create <script> <address> <amount> <unlock>
+                                 Build an unsigned spend from a script, with
+                                 <sig:address> in the unlocking script for
+                                 each signature it needs
+  tx inspect <file>              Show what a transaction pays and who has signed
+  tx sign <file>                 Sign a transaction with keys in the database,
+                                 run without a node
+  tx combine <file>...           Merge the signatures of copies of a transaction
+  tx broadcast <file>            Send a fully signed transaction to the network
+
+  signer serve <socket>          Sign for a node with keys in the database,
+                                 asking before each signature, run without a node
+  signer stdio                   Like serve but on stdin and stdout, for a node
+                                 that runs this as its -signer command
+
+  vanity prefix <prefix> [label] Generate keys on every core until one's address
+                                 continues its fixed start, e.g. "arc1q", with
+                                 prefix, then add it to the wallet
+  vanity regex <regex> [label]   Like prefix, matching the whole address
+
+  node sync                      Show how far the node is through syncing the
+                                 chain, and how long is left
+  node peers                     List the node's connected peers
+  node add <address>             Keep connected to a peer at host[:port],
+                                 reconnecting when it drops
+  node remove <address>          Stop reconnecting to an added peer
+  node added                     List added peers and whether they're connected
+  node connect <address>         Connect to a peer once
+  node disconnect <address>      Disconnect the peer at ip:port, or all at an ip
+  node addresses                 List the peer addresses the node knows of
+  node listbanned                List banned peers
+  node setban <ip>[:port] add|remove [seconds]
+                                 Ban a peer, by default for a day, or lift its ban
+
+  memo find <memo>               Find payments carrying a memo
+  timestamp <file>               Anchor a file's hash on chain, or if it's
+                                 already anchored show the proof
+
+  htlc secret                    Generate a secret and its hash lock
+  htlc create <recipient> <amount> <hash-lock> <blocks>
+                                 Lock funds for recipient, refundable after blocks
+  htlc claim <contract> <preimage>
+                                 Claim a contract by revealing its secret
+  htlc refund <contract>         Refund a timed out contract
+  htlc inspect <contract>        Show a contract's terms, balance and secret
+
+  script asm <script>            Convert a script from text to hex
+  script disasm <hex>            Convert a script from hex to text
+  script pay <lock> <amount>     Pay funds to a locking script
+  script spend <address> <output> <amount> <unlock>
+                                 Spend from a script, <sig> in the unlocking
+                                 script is replaced with the wallet's signature
+  script inspect <address>       Show a script address's locking script and balance
+
+Wallet commands act on the wallet named by -wallet, or the default wallet.
+Transaction commands other than broadcast print the transaction file to
+stdout.
+Amounts are written in coins, e.g. 12.5, down to 8 decimal places.
+Scripts are written as opcode names, 0x prefixed hex data and decimal
+numbers, e.g. "OP_SHA256 0x2bb8...a25b OP_EQUAL".
+
+Flags:
+`
+
+func usage() {
+	fmt.Fprint(os.Stderr, usageText)
+	flag.PrintDefaults()
+}
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
+
+func runCommand(args []string) {
+	switch args[0] {
+	case "address":
+		addressCommand(args[1:])
+	case "wallet":
+		walletCommand(args[1:])
+	case "htlc":
+		htlcCommand(args[1:])
+	case "tx":
+		txCommand(args[1:])
+	case "signer":
+		signerCommand(args[1:])
+	case "vanity":
+		vanityCommand(args[1:])
+	case "node":
+		nodeCommand(args[1:])
+	case "memo":
+		memoCommand(args[1:])
+	case "timestamp":
+		need(args, 1)
+		timestampCommand(args[1])
+	case "script":
+		scriptCommand(args[1:])
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+// Calls a method on the node's rpc server, exiting on failure
+func call(method string, args interface{}, reply interface{}) {
+	err := tryCall(method, args, reply)
+	if err != nil {
+		fail("%s", err)
+	}
+}
+
+// Calls a method on the node's rpc server, returning its error. Exits if
+// the node can't be reached.
+func tryCall(method string, args interface{}, reply interface{}) error {
+	client, err := rpc.Dial(*rpcAddress)
+	if err != nil {
+		fail("Couldn't connect to node: %s", err)
+	}
+	defer client.Close()
+	return client.Call(method, args, reply)
+}
+
+func printJson(v interface{}) {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		fail("%s", err)
+	}
+	fmt.Println(string(out))
+}
+
+func parseUint32(s string) uint32 {
+	n, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		fail("Invalid number %q", s)
+	}
+	return uint32(n)
+}
+
+// Checks a command has the right number of arguments
+func need(args []string, n int) {
+	if len(args) != n+1 {
+		usage()
+		os.Exit(2)
+	}
+}
+
+var addressVersionNames = map[crypto.AddressVersion]string{
+	crypto.PubKeyAddress: "pubkey",
+	crypto.HTLCAddress:   "htlc",
+	crypto.ScriptAddress: "script",
+}
+
+func addressCommand(args []string) {
+	if len(args) == 0 || args[0] != "decode" {
+		usage()
+		os.Exit(2)
+	}
+
+	need(args, 1)
+	version, payload, err := crypto.DecodeAddress(args[1])
+	if err != nil {
+		fail("%s", err)
+	}
+	fmt.Printf("network: %s\n", crypto.ActiveNetwork.Name)
+	fmt.Printf("version: %s\n", addressVersionNames[version])
+	fmt.Printf("payload: %s\n", hex.EncodeToString(payload))
+}
+
+func walletCommand(args []string) {
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "address":
+		need(args, 0)
+		var address string
+		call("Wallet.Address", &rpc.AddressArgs{Wallet: *walletFlag}, &address)
+		fmt.Println(address)
+	case "balance":
+		balanceArgs := rpc.BalanceArgs{Wallet: *walletFlag}
+		if len(args) > 1 {
+			need(args, 1)
+			balanceArgs.Address = args[1]
+		}
+		var balance string
+		call("Wallet.Balance", &balanceArgs, &balance)
+		fmt.Println(balance)
+	case "newaddress":
+		if len(args) > 3 {
+			usage()
+			os.Exit(2)
+		}
+		newAddressArgs := rpc.NewAddressArgs{Wallet: *walletFlag}
+		if len(args) > 1 {
+			newAddressArgs.Account = parseUint32(args[1])
+		}
+		if len(args) > 2 {
+			newAddressArgs.Label = args[2]
+		}
+		var address string
+		call("Wallet.NewAddress", &newAddressArgs, &address)
+		fmt.Println(address)
+	case "addresses":
+		need(args, 0)
+		var addresses []rpc.AddressInfo
+		call("Wallet.Addresses", &rpc.AddressesArgs{Wallet: *walletFlag}, &addresses)
+		for _, a := range addresses {
+			path := a.Path
+			if a.WatchOnly {
+				path = "(watch-only)"
+			} else if path == "" {
+				path = "(not from seed)"
+			}
+			fmt.Printf("%s  %-24s %-12s %s\n", a.Address, path, a.Balance, a.Label)
+		}
+	case "list":
+		need(args, 0)
+		var wallets []rpc.WalletInfo
+		call("Wallet.List", &rpc.ListWalletsArgs{}, &wallets)
+		for _, w := range wallets {
+			marker := " "
+			if w.Default {
+				marker = "*"
+			}
+			watchOnly := ""
+			if w.WatchOnly {
+				watchOnly = "watch-only"
+			}
+			fmt.Printf("%s %-20s %-16s %s\n", marker, w.Name, w.Balance, watchOnly)
+		}
+	case "new":
+		need(args, 1)
+		var address string
+		call("Wallet.Create", &rpc.CreateWalletArgs{Name: args[1]}, &address)
+		fmt.Println(address)
+	case "default":
+		need(args, 1)
+		var ok bool
+		call("Wallet.SetDefault", &rpc.SetDefaultArgs{Name: args[1]}, &ok)
+	case "label":
+		need(args, 2)
+		var ok bool
+		call("Wallet.SetLabel", &rpc.SetLabelArgs{Address: args[1], Label: args[2]}, &ok)
+	case "watch":
+		if len(args) != 2 && len(args) != 3 {
+			usage()
+			os.Exit(2)
+		}
+		watchArgs := rpc.WatchArgs{Wallet: *walletFlag, Address: args[1]}
+		if len(args) == 3 {
+			watchArgs.Label = args[2]
+		}
+		var ok bool
+		call("Wallet.Watch", &watchArgs, &ok)
+	case "importsigner":
+		need(args, 0)
+		var found int
+		call("Wallet.ImportSigner", &rpc.ImportSignerArgs{Wallet: *walletFlag}, &found)
+		fmt.Printf("Watching %d new addresses\n", found)
+	case "policy":
+		policyCommand(args[1:])
+	case "history":
+		need(args, 0)
+		var history []rpc.HistoryEntry
+		call("Wallet.History", &rpc.HistoryArgs{Wallet: *walletFlag}, &history)
+		for _, entry := range history {
+			fmt.Printf("%6d  %s  +%s -%s  %s -> %s\n", entry.Height, entry.Transaction.HashString(),
+				entry.Received, entry.Sent, entry.Transaction.Input, entry.Transaction.Output)
+		}
+	case "reward":
+		need(args, 1)
+		var ok bool
+		call("Wallet.SetReward", &rpc.SetRewardArgs{Address: args[1]}, &ok)
+	case "send", "sendfrom":
+		from := ""
+		if args[0] == "sendfrom" && len(args) > 1 {
+			from = args[1]
+			args = args[1:]
+		}
+		if len(args) != 3 && len(args) != 4 {
+			usage()
+			os.Exit(2)
+		}
+		sendArgs := rpc.SendArgs{
+			Wallet: *walletFlag,
+			Output: args[1],
+			Amount: args[2],
+			From:   from,
+		}
+		if len(args) == 4 {
+			sendArgs.Data = hex.EncodeToString([]byte(args[3]))
+		}
+		var t transaction.Transaction
+		err := tryCall("Wallet.Send", &sendArgs, &t)
+		if err != nil && strings.HasSuffix(err.Error(), "needs confirming") {
+			fmt.Fprintln(os.Stderr, err)
+			if readLine("Send it? [y/N] ") != "y" {
+				fail("Not sent")
+			}
+			sendArgs.Confirm = true
+			err = tryCall("Wallet.Send", &sendArgs, &t)
+		}
+		if err != nil {
+			fail("%s", err)
+		}
+		printJson(t)
+	case "signmessage":
+		need(args, 2)
+		var signature string
+		call("Wallet.SignMessage", &rpc.SignMessageArgs{Wallet: *walletFlag, Address: args[1], Message: args[2]}, &signature)
+		fmt.Println(signature)
+	case "verifymessage":
+		need(args, 3)
+		signature, err := hex.DecodeString(args[2])
+		if err != nil {
+			fail("Signature is not hex")
+		}
+		if !crypto.VerifyMessage(args[1], []byte(args[3]), signature) {
+			fail("Signature is not valid")
+		}
+		fmt.Println("Signature is valid")
+	case "rescan":
+		need(args, 0)
+		var found int
+		call("Wallet.Rescan", &rpc.RescanArgs{Wallet: *walletFlag}, &found)
+		fmt.Printf("Found %d new addresses\n", found)
+	case "seed":
+		need(args, 0)
+		var seed string
+		call("Wallet.Seed", &rpc.SeedArgs{Wallet: *walletFlag}, &seed)
+		fmt.Println(seed)
+	case "encrypt":
+		need(args, 0)
+		passphrase := readLine("New passphrase: ")
+		if readLine("Repeat passphrase: ") != passphrase {
+			fail("Passphrases don't match")
+		}
+		var ok bool
+		call("Wallet.Encrypt", &rpc.EncryptArgs{Passphrase: passphrase}, &ok)
+		fmt.Println("Wallet encrypted and locked")
+	case "unlock":
+		unlockArgs := rpc.UnlockArgs{Timeout: 300}
+		if len(args) > 1 {
+			need(args, 1)
+			unlockArgs.Timeout = parseUint32(args[1])
+		}
+		unlockArgs.Passphrase = readLine("Passphrase: ")
+		var ok bool
+		call("Wallet.Unlock", &unlockArgs, &ok)
+		fmt.Printf("Wallet unlocked for %d seconds\n", unlockArgs.Timeout)
+	case "lock":
+		need(args, 0)
+		var ok bool
+		call("Wallet.Lock", &rpc.LockArgs{}, &ok)
+		fmt.Println("Wallet locked")
+	case "passphrase":
+		need(args, 0)
+		changeArgs := rpc.ChangePassphraseArgs{Old: readLine("Current passphrase: ")}
+		changeArgs.New = readLine("New passphrase: ")
+		if readLine("Repeat new passphrase: ") != changeArgs.New {
+			fail("Passphrases don't match")
+		}
+		var ok bool
+		call("Wallet.ChangePassphrase", &changeArgs, &ok)
+		fmt.Println("Passphrase changed")
+	case "backup":
+		need(args, 0)
+		var mnemonic string
+		call("Wallet.Mnemonic", &rpc.MnemonicArgs{Wallet: *walletFlag}, &mnemonic)
+		fmt.Println(mnemonic)
+	case "create":
+		need(args, 0)
+		mnemonic := crypto.GenerateMnemonic()
+		passphrase := readLine("Passphrase (optional, needed along with the phrase to restore): ")
+		seed, _ := crypto.MnemonicToSeed(mnemonic, passphrase)
+		restoreWallet(seed, mnemonic)
+		fmt.Printf("Created wallet %s in %s. Write down this phrase, it's the only backup:\n\n%s\n", restoreName(), *dbPath, mnemonic)
+	case "restore":
+		if len(args) < 2 {
+			usage()
+			os.Exit(2)
+		}
+		if len(args) == 2 && !strings.Contains(args[1], " ") {
+			seed, err := hex.DecodeString(args[1])
+			if err != nil || len(seed) < 16 || len(seed) > 64 {
+				fail("Seed must be 16 to 64 bytes of hex, or a mnemonic phrase")
+			}
+			restoreWallet(seed, "")
+		} else {
+			mnemonic := strings.Join(strings.Fields(strings.ToLower(strings.Join(args[1:], " "))), " ")
+			_, err := crypto.MnemonicToEntropy(mnemonic)
+			if err != nil {
+				fail("%s", err)
+			}
+			seed, _ := crypto.MnemonicToSeed(mnemonic, readLine("Passphrase (leave empty if there wasn't one): "))
+			restoreWallet(seed, mnemonic)
+		}
+		fmt.Printf("Restored wallet %s into %s, run wallet rescan once the node has synced\n", restoreName(), *dbPath)
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func policyCommand(args []string) {
+	var policy rpc.PolicyInfo
+	call("Wallet.Policy", &rpc.PolicyArgs{Wallet: *walletFlag}, &policy)
+	if len(args) == 0 {
+		fmt.Printf("wallet:        %s\n", policy.Wallet)
+		fmt.Printf("daily limit:   %s (%s spent in the last 24 hours)\n", policy.DailyLimit, policy.SpentToday)
+		fmt.Printf("max payment:   %s\n", policy.MaxPayment)
+		fmt.Printf("confirm above: %s\n", policy.ConfirmAbove)
+		for _, address := range policy.Allowed {
+			fmt.Printf("allowed:       %s\n", address)
+		}
+		return
+	}
+
+	need(args, 1)
+	switch args[0] {
+	case "daily":
+		policy.DailyLimit = args[1]
+	case "max":
+		policy.MaxPayment = args[1]
+	case "confirm":
+		policy.ConfirmAbove = args[1]
+	case "allow":
+		for _, address := range policy.Allowed {
+			if address == args[1] {
+				return
+			}
+		}
+		policy.Allowed = append(policy.Allowed, args[1])
+	case "disallow":
+		var allowed []string
+		for _, address := range policy.Allowed {
+			if address != args[1] {
+				allowed = append(allowed, address)
+			}
+		}
+		policy.Allowed = allowed
+	default:
+		usage()
+		os.Exit(2)
+	}
+	var ok bool
+	err := tryCall("Wallet.SetPolicy", &policy, &ok)
+	if err != nil && err.Error() == store.ErrWalletLocked.Error() {
+		policy.Passphrase = readLine("Passphrase: ")
+		err = tryCall("Wallet.SetPolicy", &policy, &ok)
+	}
+	if err != nil {
+		fail("%s", err)
+	}
+}
+
+// The wallet created or restored by the local wallet commands
+func restoreName() string {
+	if *walletFlag == "" {
+		return store.DefaultWalletName
+	}
+	return *walletFlag
+}
+
+// Adds a wallet to the database from a seed, and the phrase it came from
+// if any
+func restoreWallet(seed []byte, mnemonic string) {
+	store.Open(*dbPath)
+	err := store.RestoreWallet(restoreName(), seed, mnemonic)
+	if err != nil {
+		fail("Couldn't restore into %s: %s", *dbPath, err)
+	}
+}
+
+var stdin = bufio.NewReader(os.Stdin)
+
+// Prompts for a line from stdin
+func readLine(prompt string) string {
+	fmt.Fprint(os.Stderr, prompt)
+	line, err := stdin.ReadString('\n')
+	if err != nil && err != io.EOF {
+		fail("%s", err)
+	}
+	return strings.TrimRight(line, "\r\n")
+}
+
+func htlcCommand(args []string) {
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	var t transaction.Transaction
+	switch args[0] {
+	case "secret":
+		need(args, 0)
+		secret := make([]byte, 32)
+		_, err := rand.Read(secret)
+		if err != nil {
+			fail("Couldn't generate secret: %s", err)
+		}
+		fmt.Printf("secret:    %s\n", hex.EncodeToString(secret))
+		fmt.Printf("hash lock: %s\n", transaction.HashSecret(secret))
+		return
+	case "create":
+		need(args, 4)
+		call("Wallet.CreateHTLC", &rpc.CreateHTLCArgs{
+			Wallet:    *walletFlag,
+			Recipient: args[1],
+			Amount:    args[2],
+			HashLock:  args[3],
+			Blocks:    parseUint32(args[4]),
+		}, &t)
+	case "claim":
+		need(args, 2)
+		call("Wallet.ClaimHTLC", &rpc.ClaimHTLCArgs{Address: args[1], Preimage: args[2]}, &t)
+	case "refund":
+		need(args, 1)
+		call("Wallet.RefundHTLC", &rpc.RefundHTLCArgs{Address: args[1]}, &t)
+	case "inspect":
+		need(args, 1)
+		var contract rpc.ContractReply
+		call("Chain.Contract", &rpc.ContractArgs{Address: args[1]}, &contract)
+		printJson(contract)
+		return
+	default:
+		usage()
+		os.Exit(2)
+	}
+	printJson(t)
+}
+
+func scriptCommand(args []string) {
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	var t transaction.Transaction
+	switch args[0] {
+	case "asm":
+		need(args, 1)
+		s, err := script.Assemble(args[1])
+		if err != nil {
+			fail("%s", err)
+		}
+		fmt.Println(hex.EncodeToString(s))
+		return
+	case "disasm":
+		need(args, 1)
+		s, err := hex.DecodeString(args[1])
+		if err != nil {
+			fail("Invalid hex")
+		}
+		text, err := script.Disassemble(s)
+		if err != nil {
+			fail("%s", err)
+		}
+		fmt.Println(text)
+		return
+	case "pay":
+		need(args, 2)
+		call("Wallet.PayToScript", &rpc.PayToScriptArgs{Wallet: *walletFlag, Lock: args[1], Amount: args[2]}, &t)
+	case "spend":
+		need(args, 4)
+		call("Wallet.SpendScript", &rpc.SpendScriptArgs{
+			Wallet:  *walletFlag,
+			Address: args[1],
+			Output:  args[2],
+			Amount:  args[3],
+			Unlock:  args[4],
+		}, &t)
+	case "inspect":
+		need(args, 1)
+		var reply rpc.ScriptReply
+		call("Chain.Script", &rpc.ScriptArgs{Address: args[1]}, &reply)
+		printJson(reply)
+		return
+	default:
+		usage()
+		os.Exit(2)
+	}
+	printJson(t)
+}
+
+func nodeCommand(args []string) {
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "sync":
+		var progress node.SyncProgress
+		call("Node.SyncStatus", &rpc.SyncStatusArgs{}, &progress)
+		printJson(progress)
+	case "peers":
+		var peers []node.PeerInfo
+		call("Node.Peers", &rpc.PeersArgs{}, &peers)
+		printJson(peers)
+	case "add", "remove":
+		need(args, 1)
+		var ok bool
+		call("Node.AddNode", &rpc.AddNodeArgs{Address: args[1], Command: args[0]}, &ok)
+	case "connect":
+		need(args, 1)
+		var ok bool
+		call("Node.AddNode", &rpc.AddNodeArgs{Address: args[1], Command: "onetry"}, &ok)
+	case "disconnect":
+		need(args, 1)
+		var ok bool
+		call("Node.DisconnectNode", &rpc.DisconnectNodeArgs{Address: args[1]}, &ok)
+	case "added":
+		var added []node.AddedNodeInfo
+		call("Node.AddedNodes", &rpc.AddedNodesArgs{}, &added)
+		printJson(added)
+	case "addresses":
+		var addresses []store.PeerAddress
+		call("Node.AddressBook", &rpc.AddressBookArgs{}, &addresses)
+		printJson(addresses)
+	case "listbanned":
+		var bans []store.BannedPeer
+		call("Node.ListBanned", &rpc.ListBannedArgs{}, &bans)
+		printJson(bans)
+	case "setban":
+		if len(args) != 3 && len(args) != 4 {
+			usage()
+			os.Exit(2)
+		}
+		var seconds int64
+		if len(args) == 4 {
+			seconds = int64(parseUint32(args[3]))
+		}
+		var ok bool
+		call("Node.SetBan", &rpc.SetBanArgs{Address: args[1], Command: args[2], Seconds: seconds}, &ok)
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func memoCommand(args []string) {
+	if len(args) == 0 || args[0] != "find" {
+		usage()
+		os.Exit(2)
+	}
+
+	need(args, 1)
+	var proofs []rpc.DataProof
+	call("Chain.FindData", &rpc.FindDataArgs{Data: hex.EncodeToString([]byte(args[1]))}, &proofs)
+	for _, p := range proofs {
+		printJson(p.Transaction)
+	}
+}
+
+func timestampCommand(path string) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		fail("%s", err)
+	}
+	hash := sha256.Sum256(contents)
+	data := hex.EncodeToString(hash[:])
+
+	var proofs []rpc.DataProof
+	call("Chain.FindData", &rpc.FindDataArgs{Data: data}, &proofs)
+	if len(proofs) > 0 {
+		// The earliest anchor is the one that matters
+		proof := proofs[0]
+		if !proof.Verify() {
+			fail("Node returned an invalid proof")
+		}
+		printJson(proof)
+		fmt.Printf("%s (sha256 %s) existed by block %d, %d confirmations\n",
+			path, data, proof.Height, proof.Confirmations)
+		return
+	}
+
+	var address string
+	call("Wallet.Address", &rpc.AddressArgs{Wallet: *walletFlag}, &address)
+	var t transaction.Transaction
+	call("Wallet.Send", &rpc.SendArgs{Wallet: *walletFlag, Output: address, Amount: "0", Data: data}, &t)
+	fmt.Printf("Anchored %s (sha256 %s) in transaction %s\n", path, data, t.HashString())
+	fmt.Printf("Run this again once it's mined to get a proof\n")
+}
+
+func txCommand(args []string) {
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "create":
+		if len(args) != 4 && len(args) != 5 {
+			usage()
+			os.Exit(2)
+		}
+		createArgs := rpc.CreateTxArgs{From: args[1], Output: args[2], Amount: args[3]}
+		if len(args) == 5 && transaction.IsScriptAddress(args[1]) {
+			createArgs.Unlock = args[4]
+		} else if len(args) == 5 {
+			createArgs.Data = hex.EncodeToString([]byte(args[4]))
+		}
+		var p store.Partial
+		call("Tx.Create", &createArgs, &p)
+		printJson(p)
+	case "inspect":
+		need(args, 1)
+		p := readPartial(args[1])
+		describePartial(os.Stdout, p)
+	case "sign":
+		need(args, 1)
+		p := readPartial(args[1])
+		describePartial(os.Stderr, p)
+		if len(p.Missing()) == 0 {
+			fail("Transaction is already fully signed")
+		}
+		if readLine("Sign this transaction? [y/N] ") != "y" {
+			fail("Not signed")
+		}
+		store.Open(*dbPath)
+		if store.IsLocked() {
+			err := store.Unlock(readLine("Passphrase: "), time.Minute)
+			if err != nil {
+				fail("%s", err)
+			}
+			defer store.Lock()
+		}
+		// The prompt above confirms it for wallets whose policies ask
+		signed, err := store.SignPartial(&p, true)
+		if err != nil {
+			fail("%s", err)
+		}
+		if len(signed) == 0 {
+			fail("None of the keys needed are in %s", *dbPath)
+		}
+		for _, signer := range signed {
+			fmt.Fprintf(os.Stderr, "Signed for %s\n", signer)
+		}
+		printJson(p)
+	case "combine":
+		if len(args) < 2 {
+			usage()
+			os.Exit(2)
+		}
+		var partials []store.Partial
+		for _, path := range args[1:] {
+			partials = append(partials, readPartial(path))
+		}
+		p, err := store.CombinePartials(partials)
+		if err != nil {
+			fail("%s", err)
+		}
+		printJson(p)
+	case "broadcast":
+		need(args, 1)
+		var t transaction.Transaction
+		call("Tx.Broadcast", &rpc.BroadcastArgs{Partial: readPartial(args[1])}, &t)
+		printJson(t)
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+// Reads and checks a partial transaction file
+func readPartial(path string) store.Partial {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		fail("%s", err)
+	}
+	var p store.Partial
+	err = json.Unmarshal(contents, &p)
+	if err != nil {
+		fail("Invalid transaction file %s: %s", path, err)
+	}
+	err = p.Check()
+	if err != nil {
+		fail("Invalid transaction file %s: %s", path, err)
+	}
+	return p
+}
+
+func describePartial(out io.Writer, p store.Partial) {
+	t := p.Transaction
+	fmt.Fprintf(out, "network: %s\n", p.Network)
+	fmt.Fprintf(out, "hash:    %s\n", t.HashString())
+	fmt.Fprintf(out, "from:    %s (balance %s when created)\n", t.Input, transaction.FormatAmount(p.Balance))
+	fmt.Fprintf(out, "to:      %s\n", t.Output)
+	fmt.Fprintf(out, "amount:  %s\n", transaction.FormatAmount(t.Amount))
+	if t.Data != "" {
+		data, _ := hex.DecodeString(t.Data)
+		fmt.Fprintf(out, "memo:    %q\n", data)
+	}
+	if p.Lock != "" {
+		lock, _ := hex.DecodeString(p.Lock)
+		text, _ := script.Disassemble(lock)
+		fmt.Fprintf(out, "lock:    %s\n", text)
+		fmt.Fprintf(out, "unlock:  %s\n", p.Unlock)
+	}
+	for _, signer := range p.Signers() {
+		status := "missing"
+		if _, ok := p.Signatures[signer]; ok {
+			status = "signed"
+		}
+		fmt.Fprintf(out, "signer:  %s %s\n", signer, status)
+	}
+}
+
+func signerCommand(args []string) {
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	store.Open(*dbPath)
+	service := signer.Service{Confirm: confirmSignature}
+	switch args[0] {
+	case "serve":
+		need(args, 1)
+		ln, err := signer.ListenUnix(args[1])
+		if err != nil {
+			fail("%s", err)
+		}
+		err = service.Serve(ln)
+		if err != nil {
+			fail("%s", err)
+		}
+	case "stdio":
+		need(args, 0)
+		service.ServeStdio()
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+// Asks on the terminal before each signature, as stdin and stdout may be
+// carrying the requests. Unlocks the keys for the signature if needed.
+func confirmSignature(request store.SignRequest) bool {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		log.Printf("Refusing to sign, no terminal to ask on: %s", err)
+		return false
+	}
+	defer tty.Close()
+	in := bufio.NewReader(tty)
+	ask := func(prompt string) string {
+		fmt.Fprint(tty, prompt)
+		line, _ := in.ReadString('\n')
+		return strings.TrimRight(line, "\r\n")
+	}
+
+	fmt.Fprintf(tty, "\nSigning request for %s\n", request.Address)
+	if t := request.Transaction; t != nil {
+		fmt.Fprintf(tty, "from:   %s\n", t.Input)
+		fmt.Fprintf(tty, "to:     %s\n", t.Output)
+		fmt.Fprintf(tty, "amount: %s\n", transaction.FormatAmount(t.Amount))
+		if t.Data != "" {
+			data, _ := hex.DecodeString(t.Data)
+			fmt.Fprintf(tty, "memo:   %q\n", data)
+		}
+	} else if request.Message != "" {
+		fmt.Fprintf(tty, "message: %q\n", request.Message)
+	} else {
+		fmt.Fprintf(tty, "hash:   %s (no transaction given)\n", request.Hash)
+	}
+	if ask("Sign? [y/N] ") != "y" {
+		return false
+	}
+	if store.IsLocked() {
+		err = store.Unlock(ask("Passphrase: "), time.Minute)
+		if err != nil {
+			fmt.Fprintln(tty, err)
+			return false
+		}
+	}
+	return true
+}
+
+func vanityCommand(args []string) {
+	if len(args) != 2 && len(args) != 3 {
+		usage()
+		os.Exit(2)
+	}
+	var pattern *vanity.Pattern
+	var err error
+	switch args[0] {
+	case "prefix":
+		pattern, err = vanity.Prefix(args[1])
+	case "regex":
+		pattern, err = vanity.Regex(args[1])
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fail("%s", err)
+	}
+	label := ""
+	if len(args) == 3 {
+		label = args[2]
+	}
+
+	// Check the node is there before spending time searching
+	var wallets []rpc.WalletInfo
+	call("Wallet.List", &rpc.ListWalletsArgs{}, &wallets)
+
+	difficulty := pattern.Difficulty()
+	if math.IsInf(difficulty, 1) {
+		fmt.Fprintf(os.Stderr, "Pattern is too unlikely to estimate, it may never match\n")
+	} else {
+		fmt.Fprintf(os.Stderr, "Expecting to try about %.0f keys\n", difficulty)
+	}
+
+	var tried uint64
+	result := make(chan store.Wallet)
+	go func() {
+		w, _ := vanity.Search(pattern, runtime.NumCPU(), &tried, nil)
+		result <- w
+	}()
+
+	start := time.Now()
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	var w store.Wallet
+	for w.PublicKey == nil {
+		select {
+		case w = <-result:
+		case <-ticker.C:
+			n := atomic.LoadUint64(&tried)
+			rate := float64(n) / time.Since(start).Seconds()
+			fmt.Fprintf(os.Stderr, "Tried %d keys at %.0f keys/s on %d cores", n, rate, runtime.NumCPU())
+			if !math.IsInf(difficulty, 1) {
+				expected := time.Duration(difficulty / rate * float64(time.Second))
+				fmt.Fprintf(os.Stderr, ", expect a match every %s", expected.Round(time.Second))
+			}
+			fmt.Fprintln(os.Stderr)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Found after %d keys in %s\n", atomic.LoadUint64(&tried), time.Since(start).Round(time.Second))
+
+	var address string
+	call("Wallet.ImportKey", &rpc.ImportKeyArgs{
+		Wallet:     *walletFlag,
+		PrivateKey: hex.EncodeToString(w.PrivateKey),
+		Label:      label,
+	}, &address)
+	fmt.Println(address)
+	fmt.Fprintf(os.Stderr, "The key isn't derived from the wallet seed, so back up the database to keep it\n")
+}
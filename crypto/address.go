@@ -0,0 +1,62 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Addresses are bech32 strings, with the network's prefix and data of a
+// version byte saying what the address is followed by a 32 byte payload,
+// e.g. "arc1q..." for a wallet's public key.
+type AddressVersion byte
+
+const (
+	PubKeyAddress AddressVersion = 0 // Payload is an ed25519 public key
+	HTLCAddress   AddressVersion = 1 // Payload is the hash of an HTLC's terms
+	ScriptAddress AddressVersion = 2 // Payload is the hash of a locking script
+)
+
+const AddressPayloadSize = 32
+
+var ErrWrongNetwork = errors.New("address is for a different network")
+
+func EncodeAddress(version AddressVersion, payload []byte) string {
+	if len(payload) != AddressPayloadSize {
+		panic("Address payload is the wrong size")
+	}
+	data, _ := convertBits(append([]byte{byte(version)}, payload...), 8, 5, true)
+	return Bech32Encode(ActiveNetwork.AddressPrefix, data)
+}
+
+// Decodes an address on the active network, checking its checksum,
+// version and length
+func DecodeAddress(address string) (AddressVersion, []byte, error) {
+	prefix, data, err := Bech32Decode(address)
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid address: %s", err)
+	}
+	if prefix != ActiveNetwork.AddressPrefix {
+		return 0, nil, ErrWrongNetwork
+	}
+
+	decoded, err := convertBits(data, 5, 8, false)
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid address: %s", err)
+	}
+	if len(decoded) != AddressPayloadSize+1 {
+		return 0, nil, errors.New("invalid address: wrong length")
+	}
+
+	version := AddressVersion(decoded[0])
+	if version > ScriptAddress {
+		return 0, nil, errors.New("invalid address: unknown version")
+	}
+	return version, decoded[1:], nil
+}
+
+// Whether the address is valid on the active network and of the given
+// version
+func IsAddress(address string, version AddressVersion) bool {
+	v, _, err := DecodeAddress(address)
+	return err == nil && v == version
+}
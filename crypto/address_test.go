@@ -0,0 +1,86 @@
+package crypto
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// Test vectors from BIP 173
+func TestBech32Vectors(t *testing.T) {
+	valid := []string{
+		"A12UEL5L",
+		"a12uel5l",
+		"an83characterlonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1tt5tgs",
+		"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw",
+		"11qqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqc8247j",
+		"split1checkupstagehandshakeupstreamerranterredcaperred2y9e3w",
+		"?1ezyfcl",
+	}
+	for _, s := range valid {
+		prefix, data, err := Bech32Decode(s)
+		if err != nil {
+			t.Errorf("%s: %s", s, err)
+			continue
+		}
+		if Bech32Encode(prefix, data) != strings.ToLower(s) {
+			t.Errorf("%s: didn't round trip", s)
+		}
+	}
+
+	invalid := []string{
+		"\x201nwldj5", // prefix character out of range
+		"an84characterslonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1569pvx", // too long
+		"pzry9x0s0muk",  // no separator
+		"1pzry9x0s0muk", // empty prefix
+		"x1b4n0q5v",     // invalid data character
+		"li1dgmt3",      // too short checksum
+		"A1G7SGD8",      // checksum calculated with uppercase prefix
+		"10a06t8",       // empty prefix
+		"1qzzfhee",      // empty prefix
+		"a12UEL5L",      // mixed case
+	}
+	for _, s := range invalid {
+		if _, _, err := Bech32Decode(s); err == nil {
+			t.Errorf("%q: decoded invalid string", s)
+		}
+	}
+}
+
+func TestAddress(t *testing.T) {
+	payload := bytes.Repeat([]byte{0xab}, AddressPayloadSize)
+	address := EncodeAddress(HTLCAddress, payload)
+	if !strings.HasPrefix(address, "arc1") {
+		t.Errorf("Address has wrong prefix: %s", address)
+	}
+
+	version, decoded, err := DecodeAddress(address)
+	if err != nil || version != HTLCAddress || !bytes.Equal(decoded, payload) {
+		t.Fatalf("Address didn't round trip: %s", err)
+	}
+	if !IsAddress(address, HTLCAddress) || IsAddress(address, PubKeyAddress) {
+		t.Errorf("Address version not checked")
+	}
+
+	// Any single character typo is caught by the checksum
+	typo := []byte(address)
+	typo[10] = 'q'
+	if typo[10] == address[10] {
+		typo[10] = 'p'
+	}
+	if _, _, err = DecodeAddress(string(typo)); err == nil {
+		t.Errorf("Typo not detected")
+	}
+
+	for _, s := range []string{"blockReward", "unspendable", "", Bech32Encode("arc", []byte{0, 1, 2})} {
+		if _, _, err = DecodeAddress(s); err == nil {
+			t.Errorf("Decoded invalid address %q", s)
+		}
+	}
+
+	ActiveNetwork = RegTest
+	defer func() { ActiveNetwork = MainNet }()
+	if _, _, err = DecodeAddress(address); err != ErrWrongNetwork {
+		t.Errorf("Address from another network accepted")
+	}
+}
@@ -0,0 +1,130 @@
+package crypto
+
+import (
+	"errors"
+	"strings"
+)
+
+// Bech32 as described in BIP 173. Strings are a human readable prefix, the
+// separator "1", then the data 5 bits per character followed by a 6
+// character checksum, which detects any error affecting up to 4
+// characters.
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+var bech32Generator = []uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+func bech32Polymod(values []byte) uint32 {
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := uint(0); i < 5; i++ {
+			if (top>>i)&1 == 1 {
+				chk ^= bech32Generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+func bech32ExpandPrefix(prefix string) []byte {
+	expanded := make([]byte, 0, len(prefix)*2+1)
+	for _, c := range prefix {
+		expanded = append(expanded, byte(c>>5))
+	}
+	expanded = append(expanded, 0)
+	for _, c := range prefix {
+		expanded = append(expanded, byte(c&31))
+	}
+	return expanded
+}
+
+func bech32Checksum(prefix string, data []byte) []byte {
+	values := append(bech32ExpandPrefix(prefix), data...)
+	values = append(values, 0, 0, 0, 0, 0, 0)
+	polymod := bech32Polymod(values) ^ 1
+	checksum := make([]byte, 6)
+	for i := range checksum {
+		checksum[i] = byte(polymod>>uint(5*(5-i))) & 31
+	}
+	return checksum
+}
+
+// Encodes 5 bit groups of data with the prefix
+func Bech32Encode(prefix string, data []byte) string {
+	result := []byte(prefix + "1")
+	for _, d := range data {
+		result = append(result, bech32Charset[d])
+	}
+	for _, d := range bech32Checksum(prefix, data) {
+		result = append(result, bech32Charset[d])
+	}
+	return string(result)
+}
+
+// Decodes a bech32 string into its prefix and 5 bit groups of data,
+// checking the checksum.
+func Bech32Decode(s string) (string, []byte, error) {
+	if len(s) > 90 {
+		return "", nil, errors.New("too long")
+	}
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, errors.New("mixed case")
+	}
+	s = strings.ToLower(s)
+
+	separator := strings.LastIndexByte(s, '1')
+	if separator < 1 || separator+7 > len(s) {
+		return "", nil, errors.New("missing separator or checksum")
+	}
+	prefix := s[:separator]
+	for _, c := range prefix {
+		if c < 33 || c > 126 {
+			return "", nil, errors.New("invalid prefix character")
+		}
+	}
+
+	data := make([]byte, 0, len(s)-separator-1)
+	for _, c := range s[separator+1:] {
+		d := strings.IndexRune(bech32Charset, c)
+		if d == -1 {
+			return "", nil, errors.New("invalid character")
+		}
+		data = append(data, byte(d))
+	}
+
+	if bech32Polymod(append(bech32ExpandPrefix(prefix), data...)) != 1 {
+		return "", nil, errors.New("bad checksum")
+	}
+	return prefix, data[:len(data)-6], nil
+}
+
+// Regroups bits, e.g. from bytes to the 5 bit groups bech32 uses. When not
+// padding, leftover bits must be zero padding from an earlier conversion.
+func convertBits(data []byte, from uint, to uint, pad bool) ([]byte, error) {
+	acc := uint32(0)
+	bits := uint(0)
+	maxv := uint32(1)<<to - 1
+	result := make([]byte, 0, len(data)*int(from)/int(to)+1)
+	for _, d := range data {
+		if uint32(d)>>from != 0 {
+			return nil, errors.New("invalid data")
+		}
+		acc = acc<<from | uint32(d)
+		bits += from
+		for bits >= to {
+			bits -= to
+			result = append(result, byte(acc>>bits&maxv))
+		}
+	}
+
+	if pad {
+		if bits > 0 {
+			result = append(result, byte(acc<<(to-bits)&maxv))
+		}
+	} else if bits >= from || acc<<(to-bits)&maxv != 0 {
+		return nil, errors.New("invalid padding")
+	}
+	return result, nil
+}
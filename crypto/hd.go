@@ -0,0 +1,114 @@
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Hierarchical deterministic keys as described in SLIP-0010 for ed25519.
+// Every key is derived from a seed along a path of child indexes. ed25519
+// only supports hardened derivation, so every index must be hardened.
+
+const HardenedOffset uint32 = 0x80000000
+
+// Wallet keys are derived at m/44'/31042'/account'/0'/index'
+const CoinType uint32 = 31042
+
+type ExtendedKey struct {
+	Key       []byte // 32 byte ed25519 seed for the key at this node
+	ChainCode []byte
+}
+
+func NewMasterKey(seed []byte) ExtendedKey {
+	mac := hmac.New(sha512.New, []byte("ed25519 seed"))
+	mac.Write(seed)
+	sum := mac.Sum(nil)
+	return ExtendedKey{sum[:32], sum[32:]}
+}
+
+// Derives the hardened child at index, which must include HardenedOffset
+func (k ExtendedKey) Child(index uint32) (ExtendedKey, error) {
+	if index < HardenedOffset {
+		return ExtendedKey{}, errors.New("ed25519 keys only support hardened derivation")
+	}
+
+	indexBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(indexBytes, index)
+
+	mac := hmac.New(sha512.New, k.ChainCode)
+	mac.Write([]byte{0})
+	mac.Write(k.Key)
+	mac.Write(indexBytes)
+	sum := mac.Sum(nil)
+	return ExtendedKey{sum[:32], sum[32:]}, nil
+}
+
+// Parses a path like "m/44'/31042'/0'/0'/1'" into child indexes
+func ParsePath(path string) ([]uint32, error) {
+	parts := strings.Split(path, "/")
+	if parts[0] != "m" {
+		return nil, fmt.Errorf("path %q must start with m", path)
+	}
+
+	indexes := make([]uint32, 0, len(parts)-1)
+	for _, part := range parts[1:] {
+		hardened := strings.HasSuffix(part, "'") || strings.HasSuffix(part, "H")
+		if hardened {
+			part = part[:len(part)-1]
+		}
+		index, err := strconv.ParseUint(part, 10, 32)
+		if err != nil || uint32(index) >= HardenedOffset {
+			return nil, fmt.Errorf("invalid index %q in path %q", part, path)
+		}
+		if hardened {
+			index += uint64(HardenedOffset)
+		}
+		indexes = append(indexes, uint32(index))
+	}
+	return indexes, nil
+}
+
+func FormatPath(indexes []uint32) string {
+	path := "m"
+	for _, index := range indexes {
+		if index >= HardenedOffset {
+			path += "/" + strconv.FormatUint(uint64(index-HardenedOffset), 10) + "'"
+		} else {
+			path += "/" + strconv.FormatUint(uint64(index), 10)
+		}
+	}
+	return path
+}
+
+// The path of a wallet's key for an account and address index
+func WalletPath(account uint32, index uint32) string {
+	return FormatPath([]uint32{
+		44 + HardenedOffset,
+		CoinType + HardenedOffset,
+		account + HardenedOffset,
+		HardenedOffset,
+		index + HardenedOffset,
+	})
+}
+
+// Derives the key at path from the seed
+func DeriveKey(seed []byte, path string) (ExtendedKey, error) {
+	indexes, err := ParsePath(path)
+	if err != nil {
+		return ExtendedKey{}, err
+	}
+
+	key := NewMasterKey(seed)
+	for _, index := range indexes {
+		key, err = key.Child(index)
+		if err != nil {
+			return ExtendedKey{}, err
+		}
+	}
+	return key, nil
+}
@@ -0,0 +1,54 @@
+package crypto
+
+import (
+	"encoding/hex"
+	"golang.org/x/crypto/ed25519"
+	"testing"
+)
+
+// Test vector 1 for ed25519 from SLIP-0010
+func TestDeriveKey(t *testing.T) {
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	vectors := []struct {
+		path      string
+		chainCode string
+		key       string
+		pubKey    string
+	}{
+		{"m", "90046a93de5380a72b5e45010748567d5ea02bbf6522f979e05c0d8d8ca9fffb", "2b4be7f19ee27bbf30c667b642d5f4aa69fd169872f8fc3059c08ebae2eb19e7", "a4b2856bfec510abab89753fac1ac0e1112364e7d250545963f135f2a33188ed"},
+		{"m/0'", "8b59aa11380b624e81507a27fedda59fea6d0b779a778918a2fd3590e16e9c69", "68e0fe46dfb67e368c75379acec591dad19df3cde26e63b93a8e704f1dade7a3", "8c8a13df77a28f3445213a0f432fde644acaa215fc72dcdf300d5efaa85d350c"},
+		{"m/0'/1'", "a320425f77d1b5c2505a6b1b27382b37368ee640e3557c315416801243552f14", "b1d0bad404bf35da785a64ca1ac54b2617211d2777696fbffaf208f746ae84f2", "1932a5270f335bed617d5b935c80aedb1a35bd9fc1e31acafd5372c30f5c1187"},
+		{"m/0H/1H/2H/2H/1000000000H", "68789923a0cac2cd5a29172a475fe9e0fb14cd6adb5ad98a3fa70333e7afa230", "8f94d394a8e8fd6b1bc2f3f49f5c47e385281d5c17e65324b0f62483e37e8793", "3c24da049451555d51a7014a37337aa4e12d41e485abccfa46b47dfb2af54b7a"},
+	}
+
+	for _, v := range vectors {
+		key, err := DeriveKey(seed, v.path)
+		if err != nil {
+			t.Fatalf("%s: %s", v.path, err)
+		}
+		pubKey := ed25519.NewKeyFromSeed(key.Key).Public().(ed25519.PublicKey)
+		if hex.EncodeToString(key.ChainCode) != v.chainCode || hex.EncodeToString(key.Key) != v.key || hex.EncodeToString(pubKey) != v.pubKey {
+			t.Errorf("%s: derived wrong key", v.path)
+		}
+	}
+}
+
+func TestPaths(t *testing.T) {
+	path := WalletPath(2, 7)
+	if path != "m/44'/31042'/2'/0'/7'" {
+		t.Errorf("Wrong wallet path %s", path)
+	}
+	indexes, err := ParsePath(path)
+	if err != nil || FormatPath(indexes) != path {
+		t.Errorf("Path didn't round trip")
+	}
+
+	if _, err = DeriveKey(nil, "m/0"); err == nil {
+		t.Errorf("Derived non-hardened child")
+	}
+	for _, bad := range []string{"", "0'/1'", "m/x'", "m/2147483648'"} {
+		if _, err = ParsePath(bad); err == nil {
+			t.Errorf("Parsed invalid path %q", bad)
+		}
+	}
+}
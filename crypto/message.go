@@ -0,0 +1,34 @@
+package crypto
+
+import (
+	"crypto/sha512"
+	"encoding/binary"
+	"golang.org/x/crypto/ed25519"
+)
+
+// Signed messages prove control of an address. What's signed is the hash
+// of the message behind a fixed prefix. Transaction hashes start with an
+// address version byte, which is never the prefix's first byte, so a
+// message signature can't be replayed as a transaction signature.
+const messagePrefix = "Arachnacoin Signed Message:\n"
+
+// Returns the hash signed for a message
+func MessageHash(message []byte) []byte {
+	h := sha512.New()
+	length := make([]byte, 8)
+	binary.BigEndian.PutUint64(length, uint64(len(message)))
+	h.Write([]byte(messagePrefix))
+	h.Write(length)
+	h.Write(message)
+	return h.Sum(nil)
+}
+
+// Returns whether signature is of message by the key of a public key
+// address
+func VerifyMessage(address string, message []byte, signature []byte) bool {
+	version, pubKey, err := DecodeAddress(address)
+	if err != nil || version != PubKeyAddress {
+		return false
+	}
+	return ed25519.Verify(pubKey, MessageHash(message), signature)
+}
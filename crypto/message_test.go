@@ -0,0 +1,32 @@
+package crypto
+
+import (
+	"golang.org/x/crypto/ed25519"
+	"testing"
+)
+
+func TestSignMessage(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	address := EncodeAddress(PubKeyAddress, pub)
+	message := []byte("I control this address")
+	signature := ed25519.Sign(priv, MessageHash(message))
+
+	if !VerifyMessage(address, message, signature) {
+		t.Errorf("Valid message signature rejected")
+	}
+	if VerifyMessage(address, []byte("I control this address!"), signature) {
+		t.Errorf("Signature accepted for a different message")
+	}
+	other, _, _ := ed25519.GenerateKey(nil)
+	if VerifyMessage(EncodeAddress(PubKeyAddress, other), message, signature) {
+		t.Errorf("Signature accepted for a different address")
+	}
+	if VerifyMessage(EncodeAddress(ScriptAddress, pub), message, signature) {
+		t.Errorf("Signature accepted for a script address")
+	}
+
+	// Transaction hashes start with an address version byte
+	if messagePrefix[0] <= byte(ScriptAddress) {
+		t.Errorf("Message prefix could start a transaction hash")
+	}
+}
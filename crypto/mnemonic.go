@@ -0,0 +1,126 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"golang.org/x/crypto/pbkdf2"
+	"strings"
+)
+
+// Mnemonic phrases as described in BIP 39. Entropy is followed by the
+// first bits of its sha256 as a checksum, then written 11 bits per word
+// from the wordlist. The phrase and an optional passphrase are stretched
+// into the wallet seed.
+//
+// BIP 39 asks for phrases and passphrases to be NFKD normalised first.
+// The wordlist is plain ASCII, so this only matters for passphrases
+// outside of ASCII, which are used exactly as given.
+
+// Entropy for new phrases, giving 24 words
+const MnemonicEntropySize = 32
+
+var ErrMnemonicChecksum = errors.New("mnemonic checksum doesn't match, check the words")
+
+var mnemonicWordIndexes = make(map[string]int)
+
+func init() {
+	for i, word := range mnemonicWords {
+		mnemonicWordIndexes[word] = i
+	}
+}
+
+// Generates a new random phrase
+func GenerateMnemonic() string {
+	entropy := make([]byte, MnemonicEntropySize)
+	_, err := rand.Read(entropy)
+	if err != nil {
+		panic("Couldn't generate mnemonic")
+	}
+	mnemonic, _ := NewMnemonic(entropy)
+	return mnemonic
+}
+
+// Writes entropy as a phrase. Entropy must be 16 to 32 bytes, in multiples
+// of 4.
+func NewMnemonic(entropy []byte) (string, error) {
+	if len(entropy) < 16 || len(entropy) > 32 || len(entropy)%4 != 0 {
+		return "", errors.New("entropy must be 16 to 32 bytes, in multiples of 4")
+	}
+
+	hash := sha256.Sum256(entropy)
+	checksumBits := len(entropy) / 4
+	bits := append(toBits(entropy), toBits(hash[:1])[:checksumBits]...)
+
+	words := make([]string, len(bits)/11)
+	for i := range words {
+		index := 0
+		for _, bit := range bits[i*11 : i*11+11] {
+			index = index<<1 | int(bit)
+		}
+		words[i] = mnemonicWords[index]
+	}
+	return strings.Join(words, " "), nil
+}
+
+// Reads the entropy back from a phrase, checking every word is in the
+// wordlist and the checksum matches
+func MnemonicToEntropy(mnemonic string) ([]byte, error) {
+	words := strings.Fields(strings.ToLower(mnemonic))
+	if len(words) < 12 || len(words) > 24 || len(words)%3 != 0 {
+		return nil, errors.New("mnemonic must be 12, 15, 18, 21 or 24 words")
+	}
+
+	bits := make([]byte, 0, len(words)*11)
+	for _, word := range words {
+		index, ok := mnemonicWordIndexes[word]
+		if !ok {
+			return nil, errors.New("unknown mnemonic word " + word)
+		}
+		for i := uint(0); i < 11; i++ {
+			bits = append(bits, byte(index>>(10-i)&1))
+		}
+	}
+
+	checksumBits := len(words) / 3
+	entropyBits := len(bits) - checksumBits
+	entropy := fromBits(bits[:entropyBits])
+	hash := sha256.Sum256(entropy)
+	if !bytes.Equal(bits[entropyBits:], toBits(hash[:1])[:checksumBits]) {
+		return nil, ErrMnemonicChecksum
+	}
+	return entropy, nil
+}
+
+// Splits bytes into bits, most significant first, one per byte
+func toBits(data []byte) []byte {
+	bits := make([]byte, 0, len(data)*8)
+	for _, b := range data {
+		for i := uint(0); i < 8; i++ {
+			bits = append(bits, b>>(7-i)&1)
+		}
+	}
+	return bits
+}
+
+// Packs bits from toBits back into bytes
+func fromBits(bits []byte) []byte {
+	data := make([]byte, len(bits)/8)
+	for i, bit := range bits {
+		data[i/8] |= bit << uint(7-i%8)
+	}
+	return data
+}
+
+// Checks a phrase and stretches it, with an optional passphrase, into a 64
+// byte wallet seed
+func MnemonicToSeed(mnemonic string, passphrase string) ([]byte, error) {
+	_, err := MnemonicToEntropy(mnemonic)
+	if err != nil {
+		return nil, err
+	}
+	normalised := strings.Join(strings.Fields(strings.ToLower(mnemonic)), " ")
+	return pbkdf2.Key([]byte(normalised), []byte("mnemonic"+passphrase), 2048, 64, sha512.New), nil
+}
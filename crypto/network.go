@@ -0,0 +1,33 @@
+package crypto
+
+// The parameters that keep separate networks apart
+type Network struct {
+	Name          string
+	AddressPrefix string
+	Magic         [4]byte // Starts every message between peers
+	Difficulty    uint32  // Blocks' work must be above this, see the work package
+}
+
+var MainNet = &Network{
+	"main",
+	"arc",
+	[4]byte{0xa7, 0xac, 0x31, 0x42},
+	0xffffff00,
+}
+
+// For local testing, e.g. two nodes on one machine, with blocks quick
+// to mine
+var RegTest = &Network{
+	"regtest",
+	"rarc",
+	[4]byte{0xa7, 0xac, 0x31, 0x7e},
+	0xffff0000,
+}
+
+var Networks = map[string]*Network{
+	MainNet.Name: MainNet,
+	RegTest.Name: RegTest,
+}
+
+// The network this node is on, set once at start up
+var ActiveNetwork = MainNet
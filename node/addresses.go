@@ -0,0 +1,258 @@
+package node
+
+import (
+	"fmt"
+	"github.com/frankh/arachnacoin/store"
+	"github.com/frankh/arachnacoin/wire"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// Peers tell each other the addresses of peers they know of, which go in
+// the address book, see store.AddAddress. A node asks its outbound peers
+// for addresses when it connects to them, relays small batches of fresh
+// addresses on to a couple of its other peers, and fills its outbound
+// connections from the book, spread across address groups so a single
+// network can't easily take all of them.
+
+// Most addresses in an addr message
+const MaxAddrPerMessage = 1000
+
+// Addresses in an addr message this small, seen this recently, are
+// relayed to other peers
+const maxRelayedAddrs = 10
+
+var addrRelayAge = 10 * time.Minute
+var addrRelayPeers = 2
+
+// Most new addresses a peer can add to the book while it's connected, so
+// one peer can't replace the addresses the others told us of
+const maxAddrsAddedPerPeer = MaxAddrPerMessage
+
+// How often outbound connections are topped up from the address book
+var outboundInterval = 10 * time.Second
+
+// Delay before trying an address again after it fails, doubling with each
+// failure up to maxRetryDelay
+var retryDelay = time.Minute
+var maxRetryDelay = 4 * time.Hour
+
+type NetAddress struct {
+	Address string // host:port
+	Seen    uint64 // Unix time it was last known to be up
+}
+
+type MessageGetAddr struct{}
+
+type MessageAddr struct {
+	Addresses []NetAddress
+}
+
+func (m *MessageGetAddr) command() string { return "getaddr" }
+
+func (m *MessageGetAddr) encode(e *wire.Encoder) {}
+
+func (m *MessageGetAddr) decode(d *wire.Decoder) {}
+
+func (m *MessageAddr) command() string { return "addr" }
+
+func (m *MessageAddr) encode(e *wire.Encoder) {
+	e.VarUint(uint64(len(m.Addresses)))
+	for _, a := range m.Addresses {
+		e.String(a.Address)
+		e.Uint64(a.Seen)
+	}
+}
+
+func (m *MessageAddr) decode(d *wire.Decoder) {
+	// Each address takes at least its length and when it was seen
+	count := d.Count(1 + 8)
+	m.Addresses = make([]NetAddress, 0)
+	for i := 0; i < count; i++ {
+		m.Addresses = append(m.Addresses, NetAddress{Address: d.String(), Seen: d.Uint64()})
+	}
+}
+
+// Answers a peer's first getaddr with a sample of the address book
+func handleGetAddr(peer *Peer) {
+	if peer.sentAddr {
+		return
+	}
+	peer.sentAddr = true
+
+	book := store.ShareableAddresses(MaxAddrPerMessage)
+	addresses := make([]NetAddress, 0, len(book))
+	for _, a := range book {
+		addresses = append(addresses, NetAddress{a.Address, uint64(a.LastSeen)})
+	}
+	sendMessage(peer, &MessageAddr{addresses})
+}
+
+func receiveAddr(peer *Peer, addresses []NetAddress) {
+	if len(addresses) > MaxAddrPerMessage {
+		misbehaving(peer, scoreOversized, fmt.Sprintf("%d addresses", len(addresses)))
+		return
+	}
+	// More than a relayed batch only comes in answer to a getaddr
+	if len(addresses) > maxRelayedAddrs {
+		if !peer.addrRequested {
+			misbehaving(peer, scoreUnsolicited, fmt.Sprintf("unrequested addr of %d addresses", len(addresses)))
+			return
+		}
+		peer.addrRequested = false
+	}
+
+	now := time.Now()
+	fresh := make([]NetAddress, 0)
+	for _, a := range addresses {
+		if !routableAddress(a.Address) {
+			continue
+		}
+		// Don't believe times in the future
+		seen := time.Unix(int64(a.Seen), 0)
+		if seen.After(now) {
+			seen = now
+		}
+		known := store.FetchAddress(a.Address) != nil
+		if !known && peer.addrsAdded >= maxAddrsAddedPerPeer {
+			continue
+		}
+		// Only what's news to us is worth relaying
+		if !store.AddAddress(a.Address, seen.Unix()) {
+			continue
+		}
+		if !known {
+			peer.addrsAdded++
+		}
+		if now.Sub(seen) < addrRelayAge {
+			fresh = append(fresh, a)
+		}
+	}
+
+	if len(addresses) <= maxRelayedAddrs && len(fresh) > 0 {
+		relayAddresses(peer, fresh)
+	}
+}
+
+// Passes fresh addresses on to a few random peers other than the one
+// they came from
+func relayAddresses(from *Peer, addresses []NetAddress) {
+	peers := Peers.List()
+	rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	relayed := 0
+	for _, peer := range peers {
+		if relayed == addrRelayPeers {
+			return
+		}
+		if peer == from {
+			continue
+		}
+		sendMessage(peer, &MessageAddr{addresses})
+		relayed++
+	}
+}
+
+// Returns whether an address is an ip and port another node could
+// connect to
+func routableAddress(address string) bool {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || port == "0" {
+		return false
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	return !ip.IsLoopback() && !ip.IsUnspecified() && !ip.IsMulticast() && !ip.IsLinkLocalUnicast()
+}
+
+// Returns the group an address belongs to, its /16 for ipv4 or /32 for
+// ipv6, as addresses in one group are likely run by the same people
+func addressGroup(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(16, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(32, 128)).String()
+}
+
+// Picks an address from the book to connect out to, in a group none of
+// the outbound peers are in, or "" if there are none to try
+func selectOutbound() string {
+	banned := bannedAddresses()
+	used := make(map[string]bool)
+	for _, peer := range Peers.List() {
+		if !peer.Inbound {
+			used[addressGroup(peer.Address)] = true
+		}
+	}
+
+	now := time.Now()
+	groups := make(map[string][]store.PeerAddress)
+	for _, a := range store.FetchAddresses() {
+		group := addressGroup(a.Address)
+		host, _, _ := net.SplitHostPort(a.Address)
+		if used[group] || banned[a.Address] || banned[host] || isSelf(a.Address) || Peers.Connected(a.Address) {
+			continue
+		}
+		if now.Before(time.Unix(a.LastTry, 0).Add(backoff(a.Failures))) {
+			continue
+		}
+		groups[group] = append(groups[group], a)
+	}
+	if len(groups) == 0 {
+		return ""
+	}
+
+	// Choose a group at random, so big groups are no likelier than small,
+	// then its most reliable address
+	names := make([]string, 0, len(groups))
+	for group := range groups {
+		names = append(names, group)
+	}
+	candidates := groups[names[rand.Intn(len(names))]]
+	best := candidates[0]
+	for _, a := range candidates[1:] {
+		if a.Failures < best.Failures || (a.Failures == best.Failures && a.LastSeen > best.LastSeen) {
+			best = a
+		}
+	}
+	return best.Address
+}
+
+// How long to wait before trying an address that's failed in a row
+func backoff(failures int) time.Duration {
+	if failures == 0 {
+		return 0
+	}
+	delay := retryDelay
+	for i := 1; i < failures && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
+
+// Keeps the outbound connections filled from the address book, forever
+func ConnectOutbound() {
+	for {
+		for Peers.HasRoom(false) {
+			address := selectOutbound()
+			if address == "" {
+				break
+			}
+			tryConnect(address)
+		}
+		time.Sleep(outboundInterval)
+	}
+}
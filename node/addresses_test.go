@@ -0,0 +1,157 @@
+package node
+
+import (
+	"fmt"
+	"github.com/frankh/arachnacoin/store"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAddressGroups(t *testing.T) {
+	same := [][2]string{
+		{"10.1.2.3:31042", "10.1.200.1:1234"},
+		{"[2001:db8:1::1]:31042", "[2001:db8:ffff::2]:31042"},
+	}
+	for _, pair := range same {
+		if addressGroup(pair[0]) != addressGroup(pair[1]) {
+			t.Errorf("Expected %s and %s in the same group", pair[0], pair[1])
+		}
+	}
+	if addressGroup("10.1.2.3:31042") == addressGroup("10.2.2.3:31042") {
+		t.Errorf("Expected different /16s in different groups")
+	}
+
+	routable := map[string]bool{
+		"10.1.2.3:31042":    true,
+		"[2001:db8::1]:1":   true,
+		"127.0.0.1:31042":   false,
+		"0.0.0.0:31042":     false,
+		"224.0.0.1:31042":   false,
+		"10.1.2.3:0":        false,
+		"10.1.2.3":          false,
+		"example.com:31042": false,
+	}
+	for address, expected := range routable {
+		if routableAddress(address) != expected {
+			t.Errorf("Expected routableAddress(%q) to be %t", address, expected)
+		}
+	}
+}
+
+func TestSelectOutbound(t *testing.T) {
+	store.Init(":memory:")
+	Peers = NewPeerManager()
+	now := time.Now().Unix()
+
+	// One group is connected, another has many addresses
+	for i := 1; i <= 20; i++ {
+		store.AddAddress(fmt.Sprintf("10.1.0.%d:31042", i), now)
+	}
+	store.AddAddress("10.2.0.1:31042", now)
+	store.AddAddress("10.3.0.1:31042", now)
+	store.AddAddress("10.3.0.2:31042", now)
+	addTestPeer(t, "10.3.0.1", 0)
+
+	picked := make(map[string]int)
+	for i := 0; i < 200; i++ {
+		picked[addressGroup(selectOutbound())]++
+	}
+	if picked["10.3.0.0"] != 0 {
+		t.Errorf("Picked a group already connected to")
+	}
+	if picked["10.2.0.0"] < 50 || picked["10.1.0.0"] < 50 {
+		t.Errorf("Expected groups to be picked evenly: %v", picked)
+	}
+
+	// Addresses that just failed wait before they're tried again
+	for i := 1; i <= 20; i++ {
+		address := fmt.Sprintf("10.1.0.%d:31042", i)
+		store.MarkAttempt(address)
+		store.MarkFailed(address)
+	}
+	store.MarkAttempt("10.2.0.1:31042")
+	store.MarkFailed("10.2.0.1:31042")
+	if address := selectOutbound(); address != "" {
+		t.Errorf("Picked %s, which just failed", address)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	if backoff(0) != 0 || backoff(1) != retryDelay || backoff(3) != 4*retryDelay {
+		t.Errorf("Wrong backoff: %s %s %s", backoff(0), backoff(1), backoff(3))
+	}
+	if backoff(1000) != maxRetryDelay {
+		t.Errorf("Backoff over its limit: %s", backoff(1000))
+	}
+}
+
+func TestAddrMessages(t *testing.T) {
+	store.Init(":memory:")
+	Peers = NewPeerManager()
+	local, remote := connPair(t)
+	defer local.Close()
+	defer remote.Close()
+	peer := newPeer("10.9.0.1", local, true)
+
+	// The largest addr message fits its limit
+	addresses := make([]NetAddress, MaxAddrPerMessage)
+	for i := range addresses {
+		addresses[i] = NetAddress{"[ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff]:65535", 1 << 63}
+	}
+	m := &MessageAddr{addresses}
+	payload := encodeMessage(m)
+	if len(payload) > maxPayloadSize("addr") {
+		t.Errorf("Largest addr is %d bytes, over its limit", len(payload))
+	}
+	decoded := &MessageAddr{}
+	if err := decodeMessage(decoded, payload); err != nil || !reflect.DeepEqual(m, decoded) {
+		t.Errorf("addr changed decoding: %v", err)
+	}
+
+	// Only routable addresses are kept, and never seen in the future
+	future := uint64(time.Now().Add(time.Hour).Unix())
+	receiveAddr(peer, []NetAddress{{"10.5.0.1:31042", future}, {"127.0.0.1:31042", 1}})
+	book := store.FetchAddresses()
+	if len(book) != 1 || book[0].Address != "10.5.0.1:31042" || book[0].LastSeen > time.Now().Unix() {
+		t.Errorf("Wrong addresses kept: %+v", book)
+	}
+
+	// Peers get addresses for their first getaddr only
+	handleGetAddr(peer)
+	handleGetAddr(peer)
+	reader := newPeer("", remote, false)
+	received := &MessageAddr{}
+	if err := expectMessage(reader, received); err != nil || len(received.Addresses) != 1 {
+		t.Fatalf("Expected the address book, got %+v %v", received, err)
+	}
+	remote.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err := reader.reader.ReadByte(); err == nil {
+		t.Errorf("Answered a second getaddr")
+	} else if e, ok := err.(net.Error); !ok || !e.Timeout() {
+		t.Error(err)
+	}
+
+	// Big batches only come in answer to a getaddr
+	batch := make([]NetAddress, maxRelayedAddrs+1)
+	for i := range batch {
+		batch[i] = NetAddress{fmt.Sprintf("10.6.0.%d:31042", i), 1}
+	}
+	receiveAddr(peer, batch)
+	if peer.score != scoreUnsolicited || store.FetchAddress(batch[0].Address) != nil {
+		t.Errorf("Took an unrequested batch of addresses")
+	}
+	peer.addrRequested = true
+	receiveAddr(peer, batch)
+	if peer.score != scoreUnsolicited || store.FetchAddress(batch[0].Address) == nil {
+		t.Errorf("Requested batch of addresses not taken")
+	}
+
+	// And a peer can only add so many
+	peer.addrsAdded = maxAddrsAddedPerPeer
+	receiveAddr(peer, []NetAddress{{"10.7.0.1:31042", 1}, {batch[0].Address, 2}})
+	if store.FetchAddress("10.7.0.1:31042") != nil || store.FetchAddress(batch[0].Address).LastSeen != 2 {
+		t.Errorf("Peer added addresses over its limit")
+	}
+}
@@ -0,0 +1,219 @@
+package node
+
+import (
+	"errors"
+	"github.com/frankh/arachnacoin/store"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// Besides peers found by multicast, a node can be given peers to connect
+// to. Added nodes are kept connected, reconnecting with a growing delay
+// when the connection drops, and seeds are hosts whose addresses are
+// tried when the node has no peers at all.
+
+const DefaultPort = "31042"
+
+// The port PeerServer listens on
+var ListenPort = DefaultPort
+
+// Seed hosts tried when none are given
+var DefaultSeeds = []string{}
+
+var dialTimeout = 10 * time.Second
+var seedInterval = time.Minute
+
+var minReconnectDelay = time.Second
+var maxReconnectDelay = 5 * time.Minute
+
+type addedNode struct {
+	stop    chan struct{}
+	stopped chan struct{} // Closed once it's stopped reconnecting
+	peer    *Peer
+}
+
+var addedNodes = make(map[string]*addedNode)
+var addedLock sync.Mutex
+
+type AddedNodeInfo struct {
+	Address   string `json:"address"`
+	Connected bool   `json:"connected"`
+}
+
+// Adds the default port to an address without one
+func withPort(address string) string {
+	if _, _, err := net.SplitHostPort(address); err == nil {
+		return address
+	}
+	return net.JoinHostPort(address, DefaultPort)
+}
+
+// Connects to a peer at host or host:port, returning once it's done its
+// handshake, and handling its messages in the background. If it's already
+// connected, the connected peer is returned with ErrAlreadyConnected.
+func ConnectToPeer(address string) (*Peer, error) {
+	target, err := net.ResolveTCPAddr("tcp", withPort(address))
+	if err != nil {
+		return nil, err
+	}
+	if peer := Peers.Get(target.String()); peer != nil {
+		return peer, ErrAlreadyConnected
+	}
+	if !Peers.HasRoom(false) {
+		return nil, ErrTooManyPeers
+	}
+	if store.IsBanned(target.String()) {
+		return nil, errBanned
+	}
+
+	store.MarkAttempt(target.String())
+	conn, err := net.DialTimeout("tcp", target.String(), dialTimeout)
+	if err != nil {
+		store.MarkFailed(target.String())
+		return nil, err
+	}
+	peer, err := startPeer(target.String(), conn, false)
+	if err != nil {
+		store.MarkFailed(target.String())
+		return nil, err
+	}
+	store.MarkConnected(target.String())
+	log.Printf("Connected to peer %s", target)
+	peer.addrRequested = true
+	sendMessage(peer, &MessageGetAddr{})
+	BroadcastLatestBlock()
+	go handlePeerConnection(peer)
+	return peer, nil
+}
+
+func tryConnect(address string) {
+	_, err := ConnectToPeer(address)
+	if err != nil && err != ErrAlreadyConnected {
+		log.Printf("Failed to connect to peer %s: %s", address, err)
+	}
+}
+
+// Keeps the node connected to a peer until RemoveNode
+func AddNode(address string) error {
+	if _, err := net.ResolveTCPAddr("tcp", withPort(address)); err != nil {
+		return err
+	}
+	addedLock.Lock()
+	defer addedLock.Unlock()
+	if _, ok := addedNodes[address]; ok {
+		return errors.New(address + " is already added")
+	}
+	node := &addedNode{stop: make(chan struct{}), stopped: make(chan struct{})}
+	addedNodes[address] = node
+	go keepConnected(address, node)
+	return nil
+}
+
+// Stops reconnecting to an added peer. It stays connected until it
+// disconnects.
+func RemoveNode(address string) error {
+	addedLock.Lock()
+	defer addedLock.Unlock()
+	node, ok := addedNodes[address]
+	if !ok {
+		return errors.New(address + " isn't added")
+	}
+	close(node.stop)
+	delete(addedNodes, address)
+	return nil
+}
+
+func AddedNodes() []AddedNodeInfo {
+	addedLock.Lock()
+	defer addedLock.Unlock()
+	info := make([]AddedNodeInfo, 0)
+	for address, node := range addedNodes {
+		connected := node.peer != nil && !node.peer.isDisconnected()
+		info = append(info, AddedNodeInfo{address, connected})
+	}
+	return info
+}
+
+func keepConnected(address string, node *addedNode) {
+	defer close(node.stopped)
+	delay := minReconnectDelay
+	for {
+		peer, err := ConnectToPeer(address)
+		if peer != nil {
+			addedLock.Lock()
+			node.peer = peer
+			addedLock.Unlock()
+
+			connected := time.Now()
+			select {
+			case <-peer.done:
+			case <-node.stop:
+				return
+			}
+			// Start again from a short delay after a lasting connection
+			if time.Since(connected) > maxReconnectDelay {
+				delay = minReconnectDelay
+			}
+			log.Printf("Lost added peer %s, reconnecting in %s", address, delay)
+		} else {
+			log.Printf("Failed to connect to added peer %s: %s, retrying in %s", address, err, delay)
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-node.stop:
+			return
+		}
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+// Disconnects the peer at a host:port, or all those at an ip. Added peers
+// reconnect.
+func DisconnectNode(address string) error {
+	peers := Peers.Matching(address)
+	if len(peers) == 0 {
+		return errors.New(address + " isn't connected")
+	}
+	for _, peer := range peers {
+		peer.Disconnect()
+	}
+	return nil
+}
+
+// Connects to the addresses of seed hosts whenever the node has no peers,
+// forever
+func ConnectSeeds(seeds []string) {
+	for {
+		if len(Peers.List()) == 0 {
+			for _, seed := range seeds {
+				connectSeed(seed)
+			}
+		}
+		time.Sleep(seedInterval)
+	}
+}
+
+func connectSeed(seed string) {
+	host, port, err := net.SplitHostPort(withPort(seed))
+	if err != nil {
+		log.Printf("Bad seed %s: %s", seed, err)
+		return
+	}
+	ips, err := net.LookupHost(host)
+	if err != nil {
+		log.Printf("Couldn't look up seed %s: %s", seed, err)
+		return
+	}
+	for _, ip := range ips {
+		if !Peers.HasRoom(false) {
+			return
+		}
+		tryConnect(net.JoinHostPort(ip, port))
+	}
+}
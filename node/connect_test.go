@@ -0,0 +1,143 @@
+package node
+
+import (
+	"github.com/frankh/arachnacoin/store"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestWithPort(t *testing.T) {
+	cases := map[string]string{
+		"10.0.0.1":       "10.0.0.1:31042",
+		"10.0.0.1:8333":  "10.0.0.1:8333",
+		"seed.example":   "seed.example:31042",
+		"[::1]:31042":    "[::1]:31042",
+		"::1":            "[::1]:31042",
+		"localhost:1234": "localhost:1234",
+	}
+	for address, expected := range cases {
+		if got := withPort(address); got != expected {
+			t.Errorf("withPort(%q) = %q, expected %q", address, got, expected)
+		}
+	}
+}
+
+func TestAddNodeReconnects(t *testing.T) {
+	store.Init(":memory:")
+	Peers = NewPeerManager()
+	defer func(d time.Duration) { minReconnectDelay = d }(minReconnectDelay)
+	minReconnectDelay = 10 * time.Millisecond
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// Play a peer that hangs up straight after its handshake
+	accepted := make(chan bool, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			version := localVersion()
+			version.NodeID++
+			peer := newPeer("local", conn, true)
+			sendMessage(peer, &version)
+			expectMessage(peer, &MessageVersion{})
+			expectMessage(peer, &MessageVerack{})
+			sendMessage(peer, &MessageVerack{})
+			time.Sleep(10 * time.Millisecond)
+			peer.Disconnect()
+			accepted <- true
+		}
+	}()
+
+	address := ln.Addr().String()
+	if err := AddNode(address); err != nil {
+		t.Fatal(err)
+	}
+	if AddNode(address) == nil {
+		t.Error("Added the same peer twice")
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case <-accepted:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Added peer didn't reconnect, %d connections", i)
+		}
+	}
+
+	addedLock.Lock()
+	added := addedNodes[address]
+	addedLock.Unlock()
+	if err := RemoveNode(address); err != nil {
+		t.Fatal(err)
+	}
+	<-added.stopped
+	ln.Close()
+	Peers.Shutdown()
+	if len(AddedNodes()) != 0 {
+		t.Errorf("Removed peer still added: %+v", AddedNodes())
+	}
+	if RemoveNode(address) == nil {
+		t.Error("Removed a peer that wasn't added")
+	}
+}
+
+// Plays a node that handshakes with each connection and holds it open
+func listenAsNode(t *testing.T, nodeID uint64) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+			version := localVersion()
+			version.NodeID = nodeID
+			peer := newPeer("local", conn, true)
+			sendMessage(peer, &version)
+			expectMessage(peer, &MessageVersion{})
+			expectMessage(peer, &MessageVerack{})
+			sendMessage(peer, &MessageVerack{})
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestNodesOnOneMachine(t *testing.T) {
+	store.Init(":memory:")
+	Peers = NewPeerManager()
+	defer Peers.Shutdown()
+
+	first := listenAsNode(t, localNodeID+1)
+	second := listenAsNode(t, localNodeID+2)
+	for _, address := range []string{first, second} {
+		if _, err := ConnectToPeer(address); err != nil {
+			t.Fatalf("Couldn't connect to %s: %s", address, err)
+		}
+	}
+	if !Peers.Connected(first) || !Peers.Connected(second) {
+		t.Errorf("Nodes sharing an ip weren't both connected")
+	}
+
+	// The same node at another address is only connected once
+	again := listenAsNode(t, localNodeID+1)
+	if _, err := ConnectToPeer(again); err != ErrAlreadyConnected {
+		t.Errorf("Connected to a node twice, got %v", err)
+	}
+
+	self := listenAsNode(t, localNodeID)
+	if _, err := ConnectToPeer(self); err != errConnectedToSelf || !isSelf(self) || isSelf(first) {
+		t.Errorf("Connecting to self wasn't noted, got %v", err)
+	}
+}
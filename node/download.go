@@ -0,0 +1,347 @@
+package node
+
+import (
+	"fmt"
+	"github.com/frankh/arachnacoin/block"
+	"github.com/frankh/arachnacoin/store"
+	"log"
+	"sync"
+	"time"
+)
+
+// Once headers are known, their blocks are fetched from every peer that
+// has them. Runs of missing blocks are handed out to peers with room in
+// their window, blocks a peer is too slow to send are asked of another,
+// and blocks arriving early are kept until the ones before them are stored
+// so the chain is always validated in order.
+
+// Only blocks this far past the lowest missing one are asked for, which
+// bounds how many are kept waiting for the blocks before them
+const DownloadWindow = 128
+
+// How long a peer has to send a block before it's asked of another
+var blockStallTimeout = 30 * time.Second
+
+var downloadInterval = 5 * time.Second
+
+type blockRequest struct {
+	peer      string
+	requested time.Time
+}
+
+// A block that arrived before the blocks before it were stored
+type downloadedBlock struct {
+	block block.Block
+	from  string
+}
+
+var syncLock sync.Mutex
+
+// Blocks asked for and not yet received
+var blocksInFlight = make(map[string]blockRequest)
+
+// Blocks waiting on the blocks before them
+var downloadedBlocks = make(map[string]downloadedBlock)
+
+// The peer each block last stalled on, so it's asked of another
+var stalledBlocks = make(map[string]string)
+
+// How high each peer's chain is known to go
+var peerHeights = make(map[string]uint32)
+
+// When the current sync started and the height it started from, for
+// estimating how long is left
+var syncStarted time.Time
+var syncStartHeight uint32
+
+// Serialises storing downloaded blocks, so they go in in order
+var connectLock sync.Mutex
+
+type SyncProgress struct {
+	Height          uint32  `json:"height"`        // Of the highest stored block
+	HeaderHeight    uint32  `json:"header_height"` // Of the longest chain of headers, which blocks are fetched up to
+	Percent         float64 `json:"percent"`
+	BlocksPerSecond float64 `json:"blocks_per_second"`
+	Remaining       string  `json:"remaining,omitempty"` // Estimated time left
+	InFlight        int     `json:"in_flight"`
+	Peers           int     `json:"peers"` // Peers blocks are being fetched from
+}
+
+func noteHeight(peer *Peer, height uint32) {
+	syncLock.Lock()
+	defer syncLock.Unlock()
+	if height > peerHeights[peer.Address] {
+		peerHeights[peer.Address] = height
+	}
+}
+
+// Asks peers for the missing blocks in the download window that aren't
+// already on their way
+func scheduleBlocks() {
+	peers := make(map[string]*Peer)
+	for _, peer := range Peers.List() {
+		if peer.HasFeature(FeatureBlocks) && !peer.isDisconnected() {
+			peers[peer.Address] = peer
+		}
+	}
+
+	syncLock.Lock()
+	missing := store.MissingBlocks(DownloadWindow)
+	if len(missing) == 0 {
+		syncStarted = time.Time{}
+	} else if syncStarted.IsZero() {
+		syncStarted = time.Now()
+		syncStartHeight = store.FetchHighestBlock().Height
+	}
+
+	// Blocks no longer in the window, e.g. after a reorg, are dropped
+	inWindow := make(map[string]bool)
+	for _, h := range missing {
+		inWindow[h.Hash] = true
+	}
+	for hash := range downloadedBlocks {
+		if !inWindow[hash] {
+			delete(downloadedBlocks, hash)
+		}
+	}
+
+	load := make(map[string]int)
+	for _, request := range blocksInFlight {
+		load[request.peer]++
+	}
+	requests := make(map[string][]string)
+	last := ""
+	for _, h := range missing {
+		if _, ok := blocksInFlight[h.Hash]; ok {
+			continue
+		}
+		if _, ok := downloadedBlocks[h.Hash]; ok || isOrphan(h.Hash) {
+			continue
+		}
+		address := choosePeer(peers, load, h, last)
+		if address == "" {
+			continue
+		}
+		blocksInFlight[h.Hash] = blockRequest{address, time.Now()}
+		load[address]++
+		requests[address] = append(requests[address], h.Hash)
+		last = address
+	}
+	syncLock.Unlock()
+
+	for address, hashes := range requests {
+		sendMessage(peers[address], &MessageGetBlocks{hashes})
+	}
+}
+
+// Picks the peer to ask for a block, carrying on the last peer's run of
+// blocks if it has room, otherwise the least busy. Returns "" if no peer
+// can send it.
+func choosePeer(peers map[string]*Peer, load map[string]int, h block.Header, last string) string {
+	canSend := func(address string) bool {
+		return peerHeights[address] >= h.Height && load[address] < MaxBlocksPerPeer &&
+			stalledBlocks[h.Hash] != address
+	}
+	if _, ok := peers[last]; ok && canSend(last) {
+		return last
+	}
+	best := ""
+	for address := range peers {
+		if canSend(address) && (best == "" || load[address] < load[best]) {
+			best = address
+		}
+	}
+	return best
+}
+
+// Takes a block if it was asked for, storing it once the blocks before it
+// are. Returns false if it wasn't asked for.
+func blockArrived(peer *Peer, b block.Block) bool {
+	hash := b.HashString()
+	if !matchesHeader(b) {
+		misbehaving(peer, scoreInvalid, fmt.Sprintf("block doesn't match its header at height %d", b.Height))
+		return true
+	}
+	syncLock.Lock()
+	_, requested := blocksInFlight[hash]
+	if requested {
+		delete(blocksInFlight, hash)
+		delete(stalledBlocks, hash)
+		downloadedBlocks[hash] = downloadedBlock{b, peer.Address}
+	}
+	syncLock.Unlock()
+
+	if requested {
+		noteHeight(peer, b.Height)
+		connectDownloaded()
+		scheduleBlocks()
+	}
+	return requested
+}
+
+// Stores downloaded blocks for as long as the lowest missing block has
+// arrived
+func connectDownloaded() {
+	connectLock.Lock()
+	defer connectLock.Unlock()
+	for {
+		missing := store.MissingBlocks(1)
+		if len(missing) == 0 {
+			return
+		}
+		syncLock.Lock()
+		downloaded, ok := downloadedBlocks[missing[0].Hash]
+		delete(downloadedBlocks, missing[0].Hash)
+		syncLock.Unlock()
+		if !ok || !connectBlock(downloaded.block, downloaded.from) {
+			return
+		}
+	}
+}
+
+// Validates and stores a block whose previous block is stored, along with
+// any orphans waiting on it
+func connectBlock(b block.Block, from string) bool {
+	if !storeBlock(b, from) {
+		return false
+	}
+	waiting := []string{b.HashString()}
+	for len(waiting) > 0 {
+		for _, orphan := range takeOrphans(waiting[0]) {
+			if storeBlock(orphan.block, orphan.from) {
+				waiting = append(waiting, orphan.block.HashString())
+			}
+		}
+		waiting = waiting[1:]
+	}
+	return true
+}
+
+// Validates and stores a block, announcing it if it's the new tip
+func storeBlock(b block.Block, from string) bool {
+	// The header's fine, it's the block that's wrong, so it's kept to fetch
+	// from someone else
+	if !matchesHeader(b) {
+		misbehavingAddress(from, scoreInvalid, fmt.Sprintf("block doesn't match its header at height %d", b.Height))
+		return false
+	}
+	if !store.ValidateBlock(b) {
+		misbehavingAddress(from, scoreInvalid, fmt.Sprintf("bad block at height %d", b.Height))
+		// Don't fetch anything built on it
+		store.RemoveHeaders(b.HashString())
+		return false
+	}
+	oldHeight := store.FetchHighestBlock().Height
+	store.StoreBlock(b)
+	// Only announce the new tip once synced
+	if b.Height > oldHeight && b.Height >= store.FetchBestHeader().Height {
+		log.Printf("Saved block of height %d", b.Height)
+		BroadcastLatestBlock()
+	}
+	return true
+}
+
+// Returns whether a block's height and work are those of its header, if
+// its header is known. They aren't covered by the block's hash.
+func matchesHeader(b block.Block) bool {
+	h := store.FetchHeader(b.HashString())
+	return h == nil || *h == b.Header()
+}
+
+// Forgets what a peer was asked for, asking the other peers instead
+func peerDisconnected(peer *Peer) {
+	syncLock.Lock()
+	for hash, request := range blocksInFlight {
+		if request.peer == peer.Address {
+			delete(blocksInFlight, hash)
+		}
+	}
+	delete(peerHeights, peer.Address)
+	syncLock.Unlock()
+	scheduleBlocks()
+}
+
+// Asks other peers for blocks that are taking too long. A peer stalling
+// the lowest missing block, which holds up the whole download, is dropped
+// if there are other peers to fetch from, making room for a faster one.
+func reassignStalled() {
+	var lowest string
+	if missing := store.MissingBlocks(1); len(missing) > 0 {
+		lowest = missing[0].Hash
+	}
+	stalling := ""
+	syncLock.Lock()
+	for hash, request := range blocksInFlight {
+		if time.Since(request.requested) > blockStallTimeout {
+			log.Printf("Block %s stalled on %s, asking another peer", hash[:16], request.peer)
+			delete(blocksInFlight, hash)
+			stalledBlocks[hash] = request.peer
+			if hash == lowest {
+				stalling = request.peer
+			}
+		}
+	}
+	syncLock.Unlock()
+
+	if stalling == "" {
+		return
+	}
+	others := 0
+	for _, peer := range Peers.List() {
+		if peer.Address != stalling && peer.HasFeature(FeatureBlocks) && !peer.isDisconnected() {
+			others++
+		}
+	}
+	if peer := Peers.Get(stalling); peer != nil && others > 0 {
+		log.Printf("Disconnecting from peer %s: stalling the download", stalling)
+		peer.Disconnect()
+	}
+}
+
+func SyncStatus() SyncProgress {
+	progress := SyncProgress{
+		Height:       store.FetchHighestBlock().Height,
+		HeaderHeight: store.FetchBestHeader().Height,
+		Percent:      100,
+	}
+	if progress.HeaderHeight > progress.Height {
+		progress.Percent = 100 * float64(progress.Height) / float64(progress.HeaderHeight)
+	}
+
+	syncLock.Lock()
+	defer syncLock.Unlock()
+	progress.InFlight = len(blocksInFlight)
+	peers := make(map[string]bool)
+	for _, request := range blocksInFlight {
+		peers[request.peer] = true
+	}
+	progress.Peers = len(peers)
+
+	if !syncStarted.IsZero() && progress.Height > syncStartHeight {
+		progress.BlocksPerSecond = float64(progress.Height-syncStartHeight) / time.Since(syncStarted).Seconds()
+		if progress.HeaderHeight > progress.Height {
+			left := float64(progress.HeaderHeight-progress.Height) / progress.BlocksPerSecond
+			progress.Remaining = (time.Duration(left) * time.Second).String()
+		}
+	}
+	return progress
+}
+
+// Reassigns stalled blocks and reports progress while syncing, forever
+func DownloadBlocks() {
+	for {
+		time.Sleep(downloadInterval)
+		reassignStalled()
+		scheduleBlocks()
+
+		progress := SyncStatus()
+		if progress.HeaderHeight > progress.Height {
+			remaining := progress.Remaining
+			if remaining == "" {
+				remaining = "unknown time"
+			}
+			log.Printf("Synced %d of %d blocks (%.1f%%) from %d peers, %s left",
+				progress.Height, progress.HeaderHeight, progress.Percent, progress.Peers, remaining)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package node
+
+import (
+	"database/sql"
+	"github.com/frankh/arachnacoin/block"
+	"github.com/frankh/arachnacoin/store"
+	"github.com/frankh/arachnacoin/work"
+	"testing"
+	"time"
+)
+
+// Sets up a node that has the headers of a chain of n blocks but none of
+// the blocks, returning the database holding the whole chain
+func syncingNode(t *testing.T, n int) *sql.DB {
+	work.Difficulty = 0xff000000
+	store.Init(":memory:")
+	chain := store.Conn
+	miner := store.GenerateWallet()
+	for i := 0; i < n; i++ {
+		store.StoreBlock(work.Mine(store.FetchHighestBlock(), nil, miner.Address()))
+	}
+	headers := store.LocateHeaders([]string{block.GenesisBlock.HashString()}, n)
+
+	store.Init(":memory:")
+	for _, h := range headers {
+		store.StoreHeader(h)
+	}
+
+	Peers = NewPeerManager()
+	blocksInFlight = make(map[string]blockRequest)
+	downloadedBlocks = make(map[string]downloadedBlock)
+	stalledBlocks = make(map[string]string)
+	peerHeights = make(map[string]uint32)
+	resetOrphans()
+	return chain
+}
+
+func addTestPeer(t *testing.T, address string, height uint32) {
+	local, remote := connPair(t)
+	t.Cleanup(func() {
+		local.Close()
+		remote.Close()
+	})
+	// Nothing reads what's sent, but it fits in the socket's buffer
+	peer := newPeer(address, local, false)
+	peer.Features = FeatureBlocks
+	if err := Peers.Add(peer); err != nil {
+		t.Fatal(err)
+	}
+	peerHeights[address] = height
+}
+
+func fetchFrom(chain *sql.DB, hash string) block.Block {
+	node := store.Conn
+	store.Conn = chain
+	defer func() { store.Conn = node }()
+	return *store.FetchBlock(hash)
+}
+
+func assignments() map[string][]uint32 {
+	heights := make(map[string][]uint32)
+	for hash, request := range blocksInFlight {
+		heights[request.peer] = append(heights[request.peer], store.FetchHeader(hash).Height)
+	}
+	return heights
+}
+
+func TestScheduleBlocks(t *testing.T) {
+	syncingNode(t, 40)
+	addTestPeer(t, "a", 40)
+	addTestPeer(t, "b", 40)
+	addTestPeer(t, "short", 10)
+	scheduleBlocks()
+
+	// At least the two full windows of the peers with the whole chain
+	if len(blocksInFlight) < 2*MaxBlocksPerPeer {
+		t.Fatalf("Only %d blocks asked for", len(blocksInFlight))
+	}
+	for peer, heights := range assignments() {
+		if len(heights) > MaxBlocksPerPeer {
+			t.Errorf("Asked %s for %d blocks", peer, len(heights))
+		}
+		lowest, highest := heights[0], heights[0]
+		for _, height := range heights {
+			if height < lowest {
+				lowest = height
+			}
+			if height > highest {
+				highest = height
+			}
+		}
+		if peer == "short" && highest > 10 {
+			t.Errorf("Asked a peer for block %d above its chain", highest)
+		}
+		if int(highest-lowest)+1 != len(heights) {
+			t.Errorf("Asked %s for scattered blocks %v", peer, heights)
+		}
+	}
+}
+
+func TestDownloadInOrder(t *testing.T) {
+	chain := syncingNode(t, 5)
+	addTestPeer(t, "a", 5)
+	addTestPeer(t, "b", 5)
+	scheduleBlocks()
+
+	missing := store.MissingBlocks(5)
+	peer := Peers.Get("a")
+	// Blocks arriving early wait for the ones before them
+	for _, i := range []int{3, 1, 4} {
+		if !blockArrived(peer, fetchFrom(chain, missing[i].Hash)) {
+			t.Fatalf("Block %d wasn't asked for", i+1)
+		}
+	}
+	if store.FetchHighestBlock().Height != 0 {
+		t.Fatalf("Stored blocks out of order")
+	}
+	blockArrived(peer, fetchFrom(chain, missing[0].Hash))
+	if store.FetchHighestBlock().Height != 2 {
+		t.Fatalf("Didn't store arrived blocks in order")
+	}
+	blockArrived(peer, fetchFrom(chain, missing[2].Hash))
+	if store.FetchHighestBlock().Height != 5 || len(downloadedBlocks) != 0 {
+		t.Errorf("Chain not synced")
+	}
+	if blockArrived(peer, fetchFrom(chain, missing[2].Hash)) {
+		t.Errorf("Took a block that wasn't asked for")
+	}
+}
+
+func TestReassignBlocks(t *testing.T) {
+	syncingNode(t, 10)
+	addTestPeer(t, "slow", 10)
+	scheduleBlocks()
+	addTestPeer(t, "fast", 10)
+
+	// Stalled blocks are asked of another peer
+	for hash, request := range blocksInFlight {
+		request.requested = time.Now().Add(-2 * blockStallTimeout)
+		blocksInFlight[hash] = request
+	}
+	reassignStalled()
+	scheduleBlocks()
+	if heights := assignments(); len(heights["fast"]) != 10 {
+		t.Errorf("Stalled blocks not reassigned: %v", heights)
+	}
+	// The slow peer held up the lowest block, so it's dropped
+	if !Peers.Get("slow").isDisconnected() {
+		t.Errorf("Stalling peer wasn't disconnected")
+	}
+
+	// As are a disconnected peer's
+	addTestPeer(t, "other", 10)
+	fast := Peers.Get("fast")
+	fast.Disconnect()
+	Peers.Remove(fast)
+	peerDisconnected(fast)
+	if heights := assignments(); len(heights["fast"]) != 0 || len(heights["other"]) != 10 {
+		t.Errorf("Disconnected peer's blocks not reassigned: %v", heights)
+	}
+}
+
+func TestSyncStatus(t *testing.T) {
+	chain := syncingNode(t, 4)
+	addTestPeer(t, "a", 4)
+	scheduleBlocks()
+	missing := store.MissingBlocks(4)
+	blockArrived(Peers.Get("a"), fetchFrom(chain, missing[0].Hash))
+
+	progress := SyncStatus()
+	if progress.Height != 1 || progress.HeaderHeight != 4 || progress.Percent != 25 || progress.InFlight != 3 || progress.Peers != 1 {
+		t.Errorf("Wrong progress %+v", progress)
+	}
+	if progress.BlocksPerSecond <= 0 || progress.Remaining == "" {
+		t.Errorf("No estimate of time left: %+v", progress)
+	}
+}
@@ -0,0 +1,172 @@
+package node
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/frankh/arachnacoin/block"
+	"github.com/frankh/arachnacoin/crypto"
+	"github.com/frankh/arachnacoin/store"
+	"github.com/frankh/arachnacoin/wire"
+	"time"
+)
+
+// Peers start by sending each other a version message, and reply to the
+// other's with a verack once they've checked it's compatible. Nothing
+// else is sent until both have. Peers on another network or chain, or too
+// old a protocol version, are disconnected.
+
+const ProtocolVersion = 1
+
+// Oldest protocol version we can talk to
+const MinProtocolVersion = 1
+
+const UserAgent = "arachnacoin:0.2"
+
+// Feature bits, saying what a node offers its peers
+const (
+	FeatureBlocks       uint64 = 1 << 0 // Serves the blocks of its chain
+	FeatureTransactions uint64 = 1 << 1 // Relays unconfirmed transactions
+)
+
+// The features this node offers
+var LocalFeatures = FeatureBlocks | FeatureTransactions
+
+var handshakeTimeout = 10 * time.Second
+
+// Random ID for this run of the node, to spot connections to ourselves
+var localNodeID = randomNodeID()
+
+type MessageVersion struct {
+	Version    uint32
+	Network    string
+	Genesis    string // Hash of the genesis block
+	BestHeight uint32
+	NodeID     uint64
+	UserAgent  string
+	Features   uint64
+}
+
+type MessageVerack struct{}
+
+func (m *MessageVersion) command() string { return "version" }
+
+func (m *MessageVersion) encode(e *wire.Encoder) {
+	e.Uint32(m.Version)
+	e.String(m.Network)
+	e.Hex(m.Genesis)
+	e.Uint32(m.BestHeight)
+	e.Uint64(m.NodeID)
+	e.String(m.UserAgent)
+	e.Uint64(m.Features)
+}
+
+func (m *MessageVersion) decode(d *wire.Decoder) {
+	m.Version = d.Uint32()
+	m.Network = d.String()
+	m.Genesis = d.Hex()
+	m.BestHeight = d.Uint32()
+	m.NodeID = d.Uint64()
+	m.UserAgent = d.String()
+	m.Features = d.Uint64()
+}
+
+func (m *MessageVerack) command() string { return "verack" }
+
+func (m *MessageVerack) encode(e *wire.Encoder) {}
+
+func (m *MessageVerack) decode(d *wire.Decoder) {}
+
+var errConnectedToSelf = errors.New("connected to self")
+
+func randomNodeID() uint64 {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	checkErr(err)
+	return binary.BigEndian.Uint64(b)
+}
+
+func localVersion() MessageVersion {
+	return MessageVersion{
+		Version:    ProtocolVersion,
+		Network:    crypto.ActiveNetwork.Name,
+		Genesis:    block.GenesisBlock.HashString(),
+		BestHeight: store.FetchHighestBlock().Height,
+		NodeID:     localNodeID,
+		UserAgent:  UserAgent,
+		Features:   LocalFeatures,
+	}
+}
+
+// Exchanges versions with a newly connected peer, recording what was
+// negotiated on it
+func handshake(peer *Peer) error {
+	// Only reads are timed here. The writer gives each send its own
+	// deadline, which clearing ours afterwards could undo mid-write.
+	peer.Conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	defer peer.Conn.SetReadDeadline(time.Time{})
+
+	version := localVersion()
+	err := sendMessage(peer, &version)
+	if err != nil {
+		return err
+	}
+
+	err = expectMessage(peer, &version)
+	if err != nil {
+		return err
+	}
+	err = checkVersion(version)
+	if err != nil {
+		return err
+	}
+
+	err = sendMessage(peer, &MessageVerack{})
+	if err != nil {
+		return err
+	}
+	err = expectMessage(peer, &MessageVerack{})
+	if err != nil {
+		return err
+	}
+
+	peer.Version = version.Version
+	if peer.Version > ProtocolVersion {
+		peer.Version = ProtocolVersion
+	}
+	peer.NodeID = version.NodeID
+	peer.UserAgent = version.UserAgent
+	peer.Features = version.Features & LocalFeatures
+	peer.BestHeight = version.BestHeight
+	return nil
+}
+
+// Reads the next message from a peer into m, failing if it's any other
+func expectMessage(peer *Peer, m message) error {
+	command, payload, err := wire.ReadMessage(peer.reader, crypto.ActiveNetwork.Magic, maxPayloadSize)
+	if err != nil {
+		return err
+	}
+	if command != m.command() {
+		return errors.New("expected a " + m.command() + " message, got " + command)
+	}
+	return decodeMessage(m, payload)
+}
+
+// Checks a peer's version message says it's compatible
+func checkVersion(version MessageVersion) error {
+	if version.NodeID == localNodeID {
+		return errConnectedToSelf
+	}
+	if version.Version < MinProtocolVersion {
+		return fmt.Errorf("protocol version %d is too old", version.Version)
+	}
+	if version.Network != crypto.ActiveNetwork.Name {
+		return fmt.Errorf("peer is on the %s network", version.Network)
+	}
+	if version.Genesis != block.GenesisBlock.HashString() {
+		return errors.New("peer has a different genesis block")
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package node
+
+import (
+	"github.com/frankh/arachnacoin/store"
+	"net"
+	"testing"
+)
+
+// Returns both ends of a tcp connection on localhost
+func connPair(t *testing.T) (net.Conn, net.Conn) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn)
+	go func() {
+		conn, _ := ln.Accept()
+		accepted <- conn
+	}()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, <-accepted
+}
+
+func TestHandshake(t *testing.T) {
+	store.Init(":memory:")
+	local, remote := connPair(t)
+	defer local.Close()
+	defer remote.Close()
+
+	// Play the remote peer by hand, it'd share our node ID otherwise
+	go func() {
+		version := localVersion()
+		version.NodeID++
+		version.Version = ProtocolVersion + 1
+		version.UserAgent = "other"
+		version.Features = FeatureBlocks | 1<<10
+		peer := newPeer("local", remote, true)
+		sendMessage(peer, &version)
+		expectMessage(peer, &MessageVersion{})
+		expectMessage(peer, &MessageVerack{})
+		sendMessage(peer, &MessageVerack{})
+	}()
+
+	peer := newPeer("remote", local, false)
+	err := handshake(peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peer.Version != ProtocolVersion || peer.UserAgent != "other" || peer.NodeID != localNodeID+1 {
+		t.Errorf("Wrong negotiated version: %+v", peer)
+	}
+	if !peer.HasFeature(FeatureBlocks) || peer.HasFeature(FeatureTransactions) || peer.HasFeature(1<<10) {
+		t.Errorf("Wrong negotiated features %b", peer.Features)
+	}
+}
+
+func TestHandshakeSelf(t *testing.T) {
+	store.Init(":memory:")
+	local, remote := connPair(t)
+	defer local.Close()
+	defer remote.Close()
+
+	errs := make(chan error)
+	for _, conn := range []net.Conn{local, remote} {
+		go func(conn net.Conn) {
+			errs <- handshake(newPeer("self", conn, false))
+		}(conn)
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != errConnectedToSelf {
+			t.Errorf("Expected connecting to self, got %v", err)
+		}
+	}
+}
+
+func TestCheckVersion(t *testing.T) {
+	store.Init(":memory:")
+	version := localVersion()
+	version.NodeID++
+	if err := checkVersion(version); err != nil {
+		t.Errorf("Rejected compatible version: %s", err)
+	}
+
+	bad := []func(v *MessageVersion){
+		func(v *MessageVersion) { v.Version = MinProtocolVersion - 1 },
+		func(v *MessageVersion) { v.Network = "regtest" },
+		func(v *MessageVersion) { v.Genesis = "00" },
+	}
+	for i, change := range bad {
+		v := version
+		change(&v)
+		if checkVersion(v) == nil {
+			t.Errorf("Accepted incompatible version %d: %+v", i, v)
+		}
+	}
+}
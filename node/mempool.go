@@ -0,0 +1,151 @@
+package node
+
+import (
+	"errors"
+	"github.com/frankh/arachnacoin/store"
+	"github.com/frankh/arachnacoin/transaction"
+	"github.com/frankh/arachnacoin/wire"
+	"log"
+	"sync"
+)
+
+type MessageTransaction struct {
+	Transaction transaction.Transaction
+}
+
+func (m *MessageTransaction) command() string { return "transaction" }
+
+func (m *MessageTransaction) encode(e *wire.Encoder) {
+	e.Transaction(&m.Transaction)
+}
+
+func (m *MessageTransaction) decode(d *wire.Decoder) {
+	m.Transaction = d.Transaction()
+}
+
+// Most transactions waiting to be mined, a few blocks' worth
+const MaxMemPool = 10000
+
+var errMemPoolFull = errors.New("mempool is full")
+var errBlockReward = errors.New("block rewards can only be mined")
+
+// Transactions waiting to be mined, in the order they were received
+var memPool = make([]transaction.Transaction, 0)
+var memPoolLock sync.Mutex
+
+// The ledger at the highest block, kept so the chain isn't replayed for
+// each transaction
+var tipLedger *store.Ledger
+var tipHash string
+
+// Adds a transaction to the mempool if it's valid on top of the longest
+// chain and the transactions already waiting, then relays it to peers.
+// Transactions already in the mempool are silently ignored.
+func SubmitTransaction(t transaction.Transaction) error {
+	err := checkTransaction(t)
+	if err != nil {
+		return err
+	}
+	memPoolLock.Lock()
+	hash := t.HashString()
+	for _, pending := range memPool {
+		if pending.HashString() == hash {
+			memPoolLock.Unlock()
+			return nil
+		}
+	}
+
+	if len(memPool) >= MaxMemPool {
+		memPoolLock.Unlock()
+		return errMemPoolFull
+	}
+	l, err := pendingLedger()
+	if err == nil {
+		err = l.Apply(t)
+	}
+	if err != nil {
+		memPoolLock.Unlock()
+		return err
+	}
+	memPool = append(memPool, t)
+	memPoolLock.Unlock()
+
+	log.Printf("Added transaction %s to mempool", hash)
+	broadcastTransaction(t)
+	return nil
+}
+
+// Returns the transactions to include in the next block. Any that have
+// been mined or are no longer valid on the longest chain are dropped.
+func PendingTransactions() []transaction.Transaction {
+	memPoolLock.Lock()
+	defer memPoolLock.Unlock()
+
+	_, err := pendingLedger()
+	if err != nil {
+		log.Printf("Couldn't check mempool: %s", err)
+		return make([]transaction.Transaction, 0)
+	}
+
+	pending := make([]transaction.Transaction, len(memPool))
+	copy(pending, memPool)
+	return pending
+}
+
+// Builds the ledger for the next block on the longest chain with the
+// mempool applied, removing any transactions that no longer apply.
+// Must be called with memPoolLock held.
+func pendingLedger() (*store.Ledger, error) {
+	head := store.FetchHighestBlock()
+	if tipLedger == nil || head.HashString() != tipHash {
+		l, err := store.LedgerAt(head)
+		if err != nil {
+			return nil, err
+		}
+		tipLedger = l
+		tipHash = head.HashString()
+	}
+	l := tipLedger.Copy()
+	l.Height = head.Height + 1
+
+	valid := make([]transaction.Transaction, 0, len(memPool))
+	for _, t := range memPool {
+		if l.Apply(t) == nil {
+			valid = append(valid, t)
+		}
+	}
+	memPool = valid
+	return l, nil
+}
+
+func receiveTransaction(peer *Peer, t transaction.Transaction) {
+	// Only transactions invalid on any chain are the peer's fault, others
+	// may be fine on its side of a fork
+	err := checkTransaction(t)
+	if err != nil {
+		misbehaving(peer, scoreInvalid, "invalid transaction: "+err.Error())
+		return
+	}
+	err = SubmitTransaction(t)
+	if err != nil {
+		log.Printf("Rejected transaction from %s: %s", peer.Address, err)
+	}
+}
+
+// Checks what's wrong with a transaction whatever the chain, before it's
+// checked against ours
+func checkTransaction(t transaction.Transaction) error {
+	if t.Input == "blockReward" {
+		return errBlockReward
+	}
+	return store.CheckTransaction(t)
+}
+
+func broadcastTransaction(t transaction.Transaction) {
+	for _, peer := range Peers.List() {
+		if !peer.HasFeature(FeatureTransactions) {
+			continue
+		}
+		sendMessage(peer, &MessageTransaction{t})
+	}
+}
@@ -0,0 +1,19 @@
+package node
+
+import (
+	"github.com/frankh/arachnacoin/store"
+	"github.com/frankh/arachnacoin/transaction"
+	"testing"
+)
+
+func TestMemPoolLimit(t *testing.T) {
+	store.Init(":memory:")
+	memPool = make([]transaction.Transaction, MaxMemPool)
+	defer func() { memPool = make([]transaction.Transaction, 0) }()
+
+	sender := store.GenerateWallet()
+	pending, _ := sender.Send(sender.Address(), 1, nil)
+	if SubmitTransaction(pending) != errMemPoolFull {
+		t.Errorf("Took a transaction into a full mempool")
+	}
+}
@@ -0,0 +1,91 @@
+package node
+
+import (
+	"errors"
+	"github.com/frankh/arachnacoin/store"
+	"log"
+	"net"
+	"time"
+)
+
+// Peers score points for misbehaving, such as sending invalid blocks,
+// malformed or oversized messages, or responses nothing asked for. A peer
+// reaching BanThreshold is disconnected and banned for BanTime, by its
+// host:port if we connected to it, or by its ip if it connected to us, as
+// its port is then only for that connection.
+// Scores only last as long as the connection, bans are kept in the store.
+
+const BanThreshold = 100
+
+var BanTime = 24 * time.Hour
+
+// Points for each kind of misbehaviour
+const (
+	scoreInvalid       = 100 // Invalid blocks or transactions, or headers with bad work
+	scoreMalformed     = 20  // Messages that don't decode
+	scoreOversized     = 20  // Lists over their limit
+	scoreUnsolicited   = 10  // Responses we didn't ask for
+	scoreUnconnectable = 10  // Blocks or headers that don't connect to our chain
+)
+
+var errBanned = errors.New("peer is banned")
+
+// Adds to a peer's score, banning it if it reaches the threshold
+func misbehaving(peer *Peer, points int, reason string) {
+	peer.lock.Lock()
+	peer.score += points
+	score := peer.score
+	peer.lock.Unlock()
+
+	log.Printf("Peer %s misbehaving (score %d): %s", peer.Address, score, reason)
+	if score >= BanThreshold {
+		BanPeer(banAddress(peer), BanTime, reason)
+	}
+}
+
+// Returns the points for sending a header that failed validation. Only bad
+// work is sure to be wrong, a header that doesn't connect may be on a
+// chain we haven't heard of.
+func headerScore(err error) int {
+	if err == store.ErrBadWork {
+		return scoreInvalid
+	}
+	return scoreUnconnectable
+}
+
+// Adds to the score of the peer at an address, if it's still connected
+func misbehavingAddress(address string, points int, reason string) {
+	peer := Peers.Get(address)
+	if peer != nil {
+		misbehaving(peer, points, reason)
+	}
+}
+
+func banAddress(peer *Peer) string {
+	if !peer.Inbound {
+		return peer.Address
+	}
+	host, _, err := net.SplitHostPort(peer.Address)
+	if err != nil {
+		return peer.Address
+	}
+	return host
+}
+
+// Bans a host:port or an ip for a time, disconnecting any peers it covers
+func BanPeer(address string, duration time.Duration, reason string) {
+	log.Printf("Banning peer %s for %s: %s", address, duration, reason)
+	store.Ban(address, time.Now().Add(duration), reason)
+	for _, peer := range Peers.Matching(address) {
+		peer.Disconnect()
+	}
+}
+
+// Returns a map of the banned addresses
+func bannedAddresses() map[string]bool {
+	banned := make(map[string]bool)
+	for _, b := range store.FetchBans() {
+		banned[b.Address] = true
+	}
+	return banned
+}
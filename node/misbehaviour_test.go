@@ -0,0 +1,206 @@
+package node
+
+import (
+	"github.com/frankh/arachnacoin/block"
+	"github.com/frankh/arachnacoin/store"
+	"github.com/frankh/arachnacoin/work"
+	"testing"
+)
+
+func TestMisbehavingPeerBanned(t *testing.T) {
+	store.Init(":memory:")
+	Peers = NewPeerManager()
+	addTestPeer(t, "10.4.0.1", 0)
+	peer := Peers.Get("10.4.0.1")
+
+	// Headers it was asked for are fine, any more count against it
+	requestHeaders(peer)
+	receiveHeaders(peer, []block.Header{})
+	if peer.score != 0 {
+		t.Errorf("Requested headers scored %d", peer.score)
+	}
+	for i := 0; i < BanThreshold/scoreUnsolicited-1; i++ {
+		receiveHeaders(peer, []block.Header{})
+	}
+	if store.IsBanned(peer.Address) {
+		t.Fatalf("Banned below the threshold, score %d", peer.score)
+	}
+
+	receiveHeaders(peer, []block.Header{})
+	if !store.IsBanned(peer.Address) {
+		t.Fatalf("Not banned at score %d", peer.score)
+	}
+	select {
+	case <-peer.done:
+	default:
+		t.Errorf("Banned peer wasn't disconnected")
+	}
+
+	Peers.Remove(peer)
+	_, err := ConnectToPeer(peer.Address)
+	if err != errBanned {
+		t.Errorf("Expected connecting to a banned peer to fail, got %v", err)
+	}
+	if _, ok := bannedAddresses()[peer.Address]; !ok {
+		t.Errorf("Ban list is missing the peer")
+	}
+}
+
+func TestBansByAddress(t *testing.T) {
+	store.Init(":memory:")
+	Peers = NewPeerManager()
+	addTestPeer(t, "10.4.0.9:31042", 0)
+	addTestPeer(t, "10.4.0.9:31043", 0)
+	local, remote := connPair(t)
+	defer remote.Close()
+	inbound := newPeer("10.4.0.10:50123", local, true)
+	if err := Peers.Add(inbound); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another node on the same machine isn't banned with the one we
+	// connected to, but a peer that connected in is banned by ip
+	misbehaving(Peers.Get("10.4.0.9:31042"), BanThreshold, "test")
+	misbehaving(inbound, BanThreshold, "test")
+	if !store.IsBanned("10.4.0.9:31042") || store.IsBanned("10.4.0.9:31043") {
+		t.Errorf("Outbound peer wasn't banned by host:port")
+	}
+	if !store.IsBanned("10.4.0.10:50124") || !store.IsBanned("10.4.0.10:31042") {
+		t.Errorf("Inbound peer wasn't banned by ip")
+	}
+
+	BanPeer("10.4.0.9", BanTime, "test")
+	select {
+	case <-Peers.Get("10.4.0.9:31043").done:
+	default:
+		t.Errorf("Banning an ip didn't disconnect its peers")
+	}
+}
+
+func TestBadBlockBans(t *testing.T) {
+	store.Init(":memory:")
+	Peers = NewPeerManager()
+	addTestPeer(t, "10.4.0.2", 0)
+
+	genesis := block.GenesisBlock
+	b := block.Block{Previous: genesis.HashString(), Height: 1}
+	if storeBlock(b, "10.4.0.2") {
+		t.Fatal("Stored an invalid block")
+	}
+	if !store.IsBanned("10.4.0.2") {
+		t.Errorf("Peer sending an invalid block wasn't banned")
+	}
+}
+
+func TestBlockNotMatchingHeader(t *testing.T) {
+	chain := syncingNode(t, 1)
+	addTestPeer(t, "10.4.0.3", 1)
+	scheduleBlocks()
+
+	// The hash doesn't cover the height, so it's checked against the header
+	h := store.MissingBlocks(1)[0]
+	b := fetchFrom(chain, h.Hash)
+	b.Height = 7
+	blockArrived(Peers.Get("10.4.0.3"), b)
+	if !store.IsBanned("10.4.0.3") {
+		t.Errorf("Peer sending a block not matching its header wasn't banned")
+	}
+	if peerHeights["10.4.0.3"] > 1 {
+		t.Errorf("Noted the peer at height %d", peerHeights["10.4.0.3"])
+	}
+	if missing := store.MissingBlocks(1); len(missing) != 1 || missing[0] != h {
+		t.Errorf("Header removed for a block not matching it")
+	}
+}
+
+func TestBadOrphans(t *testing.T) {
+	syncingNode(t, 0)
+	addTestPeer(t, "10.4.0.4", 0)
+	peer := Peers.Get("10.4.0.4")
+
+	b := testOrphan(1)
+	for work.ValidateBlockWork(b) {
+		b.Work++
+	}
+	receiveBlock(peer, b)
+	if isOrphan(b.HashString()) || !store.IsBanned(peer.Address) {
+		t.Errorf("Kept an orphan with bad work")
+	}
+}
+
+func TestOneHeadersRequest(t *testing.T) {
+	store.Init(":memory:")
+	Peers = NewPeerManager()
+	addTestPeer(t, "10.4.0.5", 0)
+	peer := Peers.Get("10.4.0.5")
+
+	// Orphans asking again before the reply don't let it send twice
+	requestHeaders(peer)
+	requestHeaders(peer)
+	receiveHeaders(peer, []block.Header{})
+	receiveHeaders(peer, []block.Header{})
+	if peer.score != scoreUnsolicited {
+		t.Errorf("Scored %d for a second reply", peer.score)
+	}
+}
+
+func TestMisbehaviourScores(t *testing.T) {
+	work.Difficulty = 0xff000000
+	store.Init(":memory:")
+	Peers = NewPeerManager()
+	addTestPeer(t, "10.4.0.6", 0)
+	peer := Peers.Get("10.4.0.6")
+
+	// A header that doesn't connect may be on a chain we haven't heard of
+	requestHeaders(peer)
+	unknown := block.Header{Hash: "ab", Previous: "cd", Height: 1}
+	receiveHeaders(peer, []block.Header{unknown})
+	if peer.score != scoreUnconnectable {
+		t.Errorf("Scored %d for an unconnectable header", peer.score)
+	}
+
+	// As is a transaction that's only invalid against our chain
+	sender := store.GenerateWallet()
+	unfunded, _ := sender.Send(sender.Address(), 1, nil)
+	receiveTransaction(peer, unfunded)
+	if peer.score != scoreUnconnectable {
+		t.Errorf("Scored %d for a transaction our chain can't take", peer.score)
+	}
+
+	// Bad work is sure to be wrong
+	b := work.Mine(block.GenesisBlock, nil, "unspendable")
+	bad := b.Header()
+	for work.ValidateHeaderWork(bad) {
+		bad.Work++
+	}
+	requestHeaders(peer)
+	receiveHeaders(peer, []block.Header{bad})
+	if !store.IsBanned(peer.Address) {
+		t.Errorf("Peer sending a header with bad work wasn't banned")
+	}
+
+	// A transaction invalid on any chain is
+	addTestPeer(t, "10.4.0.8", 0)
+	forged := unfunded
+	forged.Amount++
+	receiveTransaction(Peers.Get("10.4.0.8"), forged)
+	if !store.IsBanned("10.4.0.8") {
+		t.Errorf("Peer sending a badly signed transaction wasn't banned")
+	}
+}
+
+func TestTipBlockHeight(t *testing.T) {
+	work.Difficulty = 0xff000000
+	store.Init(":memory:")
+	Peers = NewPeerManager()
+	addTestPeer(t, "10.4.0.7", 0)
+	miner := store.GenerateWallet()
+
+	// Blocks announced at the tip have no header to check against first
+	b := work.Mine(block.GenesisBlock, nil, miner.Address())
+	b.Height = 5
+	receiveBlock(Peers.Get("10.4.0.7"), b)
+	if store.FetchBlock(b.HashString()) != nil || !store.IsBanned("10.4.0.7") {
+		t.Errorf("Stored a block at the tip with a made up height")
+	}
+}
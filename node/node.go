@@ -1,46 +1,101 @@
 package node
 
 import (
-	"bufio"
 	"bytes"
-	"encoding/json"
+	"fmt"
 	"github.com/frankh/arachnacoin/block"
+	"github.com/frankh/arachnacoin/crypto"
 	"github.com/frankh/arachnacoin/store"
+	"github.com/frankh/arachnacoin/wire"
+	"github.com/frankh/arachnacoin/work"
 	"log"
 	"net"
-	"strings"
+	"sync"
 	"time"
 )
 
-type Message struct {
-	Type string `json:"type"`
+// A message between peers. Each is sent in a frame under its command,
+// see the wire package.
+type message interface {
+	command() string
+	encode(e *wire.Encoder)
+	decode(d *wire.Decoder)
 }
 
 type MessageBlock struct {
-	Type  string      `json:"type"`
-	Block block.Block `json:"block"`
+	Block block.Block
 }
 
-type MessageChainRequest struct {
-	Type   string `json:"type"`
-	Latest string `json:"latest"`
+func (m *MessageBlock) command() string { return "block" }
+
+func (m *MessageBlock) encode(e *wire.Encoder) {
+	e.Block(&m.Block)
+}
+
+func (m *MessageBlock) decode(d *wire.Decoder) {
+	m.Block = d.Block()
+}
+
+// Returns an empty message for a command, or nil if it's unknown
+func newMessage(command string) message {
+	switch command {
+	case "version":
+		return &MessageVersion{}
+	case "verack":
+		return &MessageVerack{}
+	case "block":
+		return &MessageBlock{}
+	case "getheaders":
+		return &MessageGetHeaders{}
+	case "headers":
+		return &MessageHeaders{}
+	case "getblocks":
+		return &MessageGetBlocks{}
+	case "transaction":
+		return &MessageTransaction{}
+	case "getaddr":
+		return &MessageGetAddr{}
+	case "addr":
+		return &MessageAddr{}
+	case "ping":
+		return &MessagePing{}
+	case "pong":
+		return &MessagePong{}
+	}
+	return nil
 }
 
-type MessageChainResponse struct {
-	Type   string        `json:"type"`
-	Blocks []block.Block `json:"blocks"`
+// Largest payload peers can send for each command. Payloads of unknown
+// commands, which are skipped, can be up to 1KiB.
+var maxPayloadSizes = map[string]int{
+	"version":     1 << 10,
+	"verack":      16,
+	"block":       4 << 20,
+	"getheaders":  8 << 10,
+	"headers":     512 << 10,
+	"getblocks":   4 << 10,
+	"transaction": 16 << 10,
+	"getaddr":     16,
+	"addr":        64 << 10,
+	"ping":        16,
+	"pong":        16,
 }
 
-type Peer struct {
-	Address string
-	Conn    net.Conn
+func maxPayloadSize(command string) int {
+	size, ok := maxPayloadSizes[command]
+	if !ok {
+		return 1 << 10
+	}
+	return size
 }
 
 var broadcastAddress, _ = net.ResolveUDPAddr("udp", "224.0.0.1:31042")
 var broadcastPacket = []byte("Arachnacoin")
 var broadcastInterval = 3 * time.Second
-var connectedPeers = make(map[string]Peer)
-var localIp string
+
+// Addresses found to be our own, which aren't worth connecting to
+var selfAddresses = make(map[string]bool)
+var selfLock sync.Mutex
 
 func checkErr(err error) {
 	if err != nil {
@@ -48,165 +103,185 @@ func checkErr(err error) {
 	}
 }
 
-func AddrToIp(addr net.Addr) string {
-	return strings.Split(addr.String(), ":")[0]
+func noteSelf(address string) {
+	selfLock.Lock()
+	defer selfLock.Unlock()
+	selfAddresses[address] = true
+}
+
+func isSelf(address string) bool {
+	selfLock.Lock()
+	defer selfLock.Unlock()
+	return selfAddresses[address]
 }
 
 func PeerServer() {
-	log.Printf("Listening for peer connections on 31042")
-	ln, err := net.Listen("tcp", "0.0.0.0:31042")
+	log.Printf("Listening for peer connections on %s", ListenPort)
+	ln, err := net.Listen("tcp", "0.0.0.0:"+ListenPort)
 	checkErr(err)
+	if Peers.listen(ln) != nil {
+		return
+	}
 
 	for {
 		conn, err := ln.Accept()
+		if Peers.isClosed() {
+			return
+		}
 		if err != nil {
 			continue
 		}
-		remoteIp := AddrToIp(conn.RemoteAddr())
-
-		if AddrToIp(conn.RemoteAddr()) == AddrToIp(conn.LocalAddr()) {
-			log.Printf("Ignored connecting to self")
+		// Connections to self are found by node id in the handshake, as
+		// other nodes may share our ip
+		remote := conn.RemoteAddr().String()
+		if store.IsBanned(remote) {
+			log.Printf("Turned away banned peer %s", remote)
+			conn.Close()
+			continue
+		}
+		if !Peers.HasRoom(true) {
+			log.Printf("Turned away peer %s, too many peers", remote)
+			conn.Close()
 			continue
 		}
 
-		log.Printf("Accepted connection from peer %s", remoteIp)
-		peer := Peer{remoteIp, conn}
-		connectedPeers[remoteIp] = peer
-		go handlePeerConnection(peer)
+		log.Printf("Accepted connection from peer %s", remote)
+		go func() {
+			peer, err := startPeer(remote, conn, true)
+			if err == nil {
+				handlePeerConnection(peer)
+			}
+		}()
 	}
 }
 
-func handlePeerConnection(peer Peer) {
-	var message Message
+// Handshakes with a newly connected peer, adding it to the connected peers
+// if it's compatible and there's room
+func startPeer(address string, conn net.Conn, inbound bool) (*Peer, error) {
+	peer := newPeer(address, conn, inbound)
+	err := handshake(peer)
+	if err == errConnectedToSelf && !inbound {
+		noteSelf(address)
+	}
+	if err == nil {
+		err = Peers.Add(peer)
+	}
+	if err != nil {
+		log.Printf("Disconnecting from peer %s: %s", address, err)
+		peer.Disconnect()
+		return nil, err
+	}
+	log.Printf("Peer %s is %s, protocol version %d, height %d", address, peer.UserAgent, peer.Version, peer.BestHeight)
+	startSync(peer)
+	return peer, nil
+}
 
+func encodeMessage(m message) []byte {
+	e := wire.NewEncoder()
+	m.encode(e)
+	return e.Bytes()
+}
+
+func decodeMessage(m message, payload []byte) error {
+	d := wire.NewDecoder(payload)
+	m.decode(d)
+	return d.Finish()
+}
+
+// Reads and handles a connected peer's messages until it disconnects
+func handlePeerConnection(peer *Peer) {
+	defer Peers.running.Done()
 	for {
-		jsonMessage, err := bufio.NewReader(peer.Conn).ReadBytes('\n')
+		peer.Conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		command, payload, err := wire.ReadMessage(peer.reader, crypto.ActiveNetwork.Magic, maxPayloadSize)
 		if err != nil {
-			log.Printf("Disconnecting from peer: %s", err)
-			delete(connectedPeers, peer.Address)
-			peer.Conn.Close()
+			log.Printf("Disconnecting from peer %s: %s", peer.Address, err)
+			peer.Disconnect()
+			Peers.Remove(peer)
+			peerDisconnected(peer)
 			return
 		}
 
-		err = json.Unmarshal(jsonMessage, &message)
+		m := newMessage(command)
+		if m == nil {
+			// Newer peers may send messages we don't know yet
+			log.Printf("Ignoring unknown %s message from peer %s", command, peer.Address)
+			continue
+		}
+		err = decodeMessage(m, payload)
 		if err != nil {
-			log.Printf("%s", jsonMessage)
+			misbehaving(peer, scoreMalformed, "bad "+command+": "+err.Error())
 			continue
 		}
 
-		switch message.Type {
-		case "block":
+		switch m := m.(type) {
+		case *MessageBlock:
 			// log.Printf("Received block from %s", peer.Address)
-			var messageBlock MessageBlock
-			err = json.Unmarshal(jsonMessage, &messageBlock)
-			if err != nil {
-				log.Printf("Bad block...ignoring")
-				continue
-			}
-			receiveBlock(peer, messageBlock.Block)
-		case "chain_req":
-			log.Printf("Received chain request from %s", peer.Address)
-			var messageChainRequest MessageChainRequest
-			err = json.Unmarshal(jsonMessage, &messageChainRequest)
-			if err != nil {
-				log.Printf("Bad chain request...ignoring")
-				continue
-			}
-			handleChainRequest(peer, messageChainRequest.Latest)
-		case "chain_resp":
-			log.Printf("Received chain response from %s", peer.Address)
-			var messageChainResponse MessageChainResponse
-			err = json.Unmarshal(jsonMessage, &messageChainResponse)
-			if err != nil {
-				log.Printf("Bad chain response...ignoring")
-				continue
-			}
-			handleChainResponse(messageChainResponse.Blocks)
-
+			receiveBlock(peer, m.Block)
+		case *MessageGetHeaders:
+			handleGetHeaders(peer, m.Locator)
+		case *MessageHeaders:
+			receiveHeaders(peer, m.Headers)
+		case *MessageGetBlocks:
+			handleGetBlocks(peer, m.Hashes)
+		case *MessageTransaction:
+			receiveTransaction(peer, m.Transaction)
+		case *MessageGetAddr:
+			handleGetAddr(peer)
+		case *MessageAddr:
+			receiveAddr(peer, m.Addresses)
+		case *MessagePing:
+			sendMessage(peer, &MessagePong{m.Nonce})
+		case *MessagePong:
+			receivePong(peer, m.Nonce)
 		default:
-			log.Printf("Ignoring unknown message from peer %s", peer.Address)
-			continue
+			log.Printf("Ignoring unexpected %s message from peer %s", command, peer.Address)
 		}
-
 	}
-
 }
 
-func handleChainResponse(blocks []block.Block) {
-	log.Printf("Storing blocks...")
-	for _, b := range blocks {
-		if store.ValidateBlock(b) {
-			store.StoreBlock(b)
-		} else {
-			log.Printf("Bad chain at height %d: %s", b.Height, b.HashString())
-			return
-		}
+func receiveBlock(peer *Peer, b block.Block) {
+	if blockArrived(peer, b) {
+		return
 	}
-	log.Printf("Done! New height %d", store.FetchHighestBlock().Height)
-}
-
-func receiveBlock(peer Peer, b block.Block) {
 	if store.FetchBlock(b.HashString()) != nil {
 		// log.Printf("Already have this block")
 		return
 	}
 
-	if b.Height < store.FetchHighestBlock().Height {
-		sendBlockToPeer(store.FetchHighestBlock(), peer)
-	} else if store.ValidateBlock(b) {
-		oldHeight := store.FetchHighestBlock().Height
-		log.Printf("Saved block of height %d", b.Height)
-		store.StoreBlock(b)
-		if b.Height > oldHeight {
-			BroadcastLatestBlock()
-		}
-	} else {
-		requestBlockChain(peer, b)
-	}
-}
-
-func requestBlockChain(peer Peer, b block.Block) {
-	message := MessageChainRequest{
-		"chain_req",
-		b.HashString(),
-	}
-
-	jsonMessage, err := json.Marshal(message)
-	if err != nil {
-		log.Printf("Couldn't read message %x", jsonMessage)
-		return
-	}
-
-	log.Printf("Requesting chain up to %d from %s", b.Height, peer.Address)
-	peer.Conn.Write(jsonMessage)
-	peer.Conn.Write([]byte{'\n'})
-}
-
-func handleChainRequest(peer Peer, latest string) {
-	b := store.FetchBlock(latest)
-	if b == nil {
-		log.Printf("Cannot handle chain request, block not found")
+	highest := store.FetchHighestBlock()
+	if b.Height < highest.Height {
+		sendBlockToPeer(highest, peer)
 		return
 	}
-
-	hashChain := store.GetBlockHashChain(b)
-	if hashChain == nil {
-		log.Printf("Cannot handle chain request, broken chain")
+	if store.FetchBlock(b.Previous) == nil {
+		// Orphans are checked as far as they can be before they're kept
+		if !work.ValidateBlockWork(b) {
+			misbehaving(peer, scoreInvalid, fmt.Sprintf("orphan block with bad work at height %d", b.Height))
+			return
+		}
+		if store.FetchHeader(b.Previous) == nil {
+			// Keep it until the blocks before it are fetched, finding out what's
+			// missing from the peer's headers
+			addOrphan(b, peer.Address)
+			requestHeaders(peer)
+			return
+		}
+		if err := store.ValidateHeader(b.Header()); err != nil {
+			misbehaving(peer, headerScore(err), fmt.Sprintf("bad orphan block at height %d: %s", b.Height, err))
+			return
+		}
+		addOrphan(b, peer.Address)
+		store.StoreHeader(b.Header())
+		noteHeight(peer, b.Height)
+		scheduleBlocks()
 		return
 	}
-
-	blocks := make([]block.Block, 0)
-
-	for i, _ := range hashChain {
-		// Send last block in hash chain (earliest) first
-		b = store.FetchBlock(hashChain[len(hashChain)-i-1])
-		if b != nil {
-			blocks = append(blocks, *b)
-		}
+	connectLock.Lock()
+	if connectBlock(b, peer.Address) {
+		noteHeight(peer, b.Height)
 	}
-
-	sendBlockChainToPeer(blocks, peer)
-
+	connectLock.Unlock()
 }
 
 func BroadcastLatestBlock() {
@@ -216,60 +291,14 @@ func BroadcastLatestBlock() {
 		return
 	}
 
-	for _, peer := range connectedPeers {
+	for _, peer := range Peers.List() {
 		sendBlockToPeer(b, peer)
 	}
 }
 
-func sendBlockToPeer(b block.Block, peer Peer) {
-	message := MessageBlock{
-		"block",
-		b,
-	}
-
-	jsonMessage, err := json.Marshal(message)
-	if err != nil {
-		log.Printf("Couldn't read message %x", jsonMessage)
-		return
-	}
-
-	// log.Printf("Sending block %d to %s", b.Height, peer.Address)
-	peer.Conn.Write(jsonMessage)
-	peer.Conn.Write([]byte{'\n'})
-}
-
-func sendBlockChainToPeer(blocks []block.Block, peer Peer) {
-	message := MessageChainResponse{
-		"chain_resp",
-		blocks,
-	}
-
-	jsonMessage, err := json.Marshal(message)
-	if err != nil {
-		log.Printf("Couldn't read message %x", jsonMessage)
-		return
-	}
-
+func sendBlockToPeer(b block.Block, peer *Peer) {
 	// log.Printf("Sending block %d to %s", b.Height, peer.Address)
-	peer.Conn.Write(jsonMessage)
-	peer.Conn.Write([]byte{'\n'})
-}
-
-func ConnectToPeer(peerIp string) {
-	conn, err := net.Dial("tcp", peerIp+":31042")
-	if err != nil {
-		log.Printf("Failed to connect to peer %s: %s", peerIp, err)
-		return
-	}
-	if AddrToIp(conn.RemoteAddr()) == AddrToIp(conn.LocalAddr()) {
-		localIp = AddrToIp(conn.LocalAddr())
-		return
-	}
-	peer := Peer{peerIp, conn}
-	connectedPeers[peerIp] = peer
-	log.Printf("Connected to peer %s", peerIp)
-	BroadcastLatestBlock()
-	handlePeerConnection(peer)
+	sendMessage(peer, &MessageBlock{b})
 }
 
 func ListenForPeers() {
@@ -284,14 +313,18 @@ func ListenForPeers() {
 			continue
 		}
 
-		peerIp := AddrToIp(addr)
-		// Don't try and connect to already connected peers
-		if peerIp == localIp || connectedPeers[peerIp].Conn != nil {
+		host, _, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			continue
+		}
+		// Don't try and connect to ourselves or already connected peers
+		address := withPort(host)
+		if isSelf(address) || Peers.Connected(address) {
 			continue
 		}
 
 		if bytes.Compare(buf, broadcastPacket) == 0 {
-			go ConnectToPeer(peerIp)
+			go tryConnect(address)
 		}
 	}
 }
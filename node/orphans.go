@@ -0,0 +1,132 @@
+package node
+
+import (
+	"fmt"
+	"github.com/frankh/arachnacoin/block"
+	"log"
+	"sync"
+	"time"
+)
+
+// Blocks whose previous block isn't stored yet are kept as orphans,
+// by the hash of the block they're waiting on, while the blocks before
+// them are fetched. Once those are stored the orphans go in after them.
+// The pool is bounded so peers can't fill memory with blocks that never
+// connect.
+
+const MaxOrphans = 100
+
+// Most bytes of orphans kept, and most for any one orphan
+const MaxOrphanBytes = 8 << 20
+const MaxOrphanSize = 1 << 20
+
+// How long an orphan is kept waiting
+var orphanExpiry = 20 * time.Minute
+
+type orphanBlock struct {
+	block block.Block
+	from  string
+	size  int
+	added time.Time
+}
+
+var orphanLock sync.Mutex
+var orphans = make(map[string]orphanBlock)
+
+// Hashes of the orphans waiting on each block
+var orphansByPrevious = make(map[string][]string)
+var orphanBytes int
+
+// Keeps a block until the block before it is stored, making room by
+// dropping the oldest orphans. Returns false if the block is too big.
+func addOrphan(b block.Block, from string) bool {
+	hash := b.HashString()
+	size := len(encodeMessage(&MessageBlock{b}))
+	if size > MaxOrphanSize {
+		return false
+	}
+
+	orphanLock.Lock()
+	if _, ok := orphans[hash]; ok {
+		orphanLock.Unlock()
+		return true
+	}
+	expired := make([]orphanBlock, 0)
+	for hash, orphan := range orphans {
+		if time.Since(orphan.added) > orphanExpiry {
+			removeOrphan(hash)
+			expired = append(expired, orphan)
+		}
+	}
+	for len(orphans) >= MaxOrphans || orphanBytes+size > MaxOrphanBytes {
+		removeOrphan(oldestOrphan())
+	}
+
+	orphans[hash] = orphanBlock{b, from, size, time.Now()}
+	orphansByPrevious[b.Previous] = append(orphansByPrevious[b.Previous], hash)
+	orphanBytes += size
+	count := len(orphans)
+	orphanLock.Unlock()
+
+	for _, orphan := range expired {
+		misbehavingAddress(orphan.from, scoreUnconnectable,
+			fmt.Sprintf("orphan block of height %d never connected", orphan.block.Height))
+	}
+	log.Printf("Keeping orphan block of height %d from %s, %d orphans", b.Height, from, count)
+	return true
+}
+
+func oldestOrphan() string {
+	oldest := ""
+	for hash, orphan := range orphans {
+		if oldest == "" || orphan.added.Before(orphans[oldest].added) {
+			oldest = hash
+		}
+	}
+	return oldest
+}
+
+// Drops an orphan. The caller holds orphanLock.
+func removeOrphan(hash string) {
+	orphan, ok := orphans[hash]
+	if !ok {
+		return
+	}
+	delete(orphans, hash)
+	orphanBytes -= orphan.size
+
+	previous := orphan.block.Previous
+	siblings := orphansByPrevious[previous]
+	for i, sibling := range siblings {
+		if sibling == hash {
+			siblings = append(siblings[:i], siblings[i+1:]...)
+			break
+		}
+	}
+	if len(siblings) == 0 {
+		delete(orphansByPrevious, previous)
+	} else {
+		orphansByPrevious[previous] = siblings
+	}
+}
+
+// Removes and returns the orphans waiting on a block
+func takeOrphans(previous string) []orphanBlock {
+	orphanLock.Lock()
+	defer orphanLock.Unlock()
+	taken := make([]orphanBlock, 0)
+	for _, hash := range orphansByPrevious[previous] {
+		taken = append(taken, orphans[hash])
+	}
+	for _, orphan := range taken {
+		removeOrphan(orphan.block.HashString())
+	}
+	return taken
+}
+
+func isOrphan(hash string) bool {
+	orphanLock.Lock()
+	defer orphanLock.Unlock()
+	_, ok := orphans[hash]
+	return ok
+}
@@ -0,0 +1,81 @@
+package node
+
+import (
+	"fmt"
+	"github.com/frankh/arachnacoin/block"
+	"github.com/frankh/arachnacoin/store"
+	"github.com/frankh/arachnacoin/transaction"
+	"strings"
+	"testing"
+	"time"
+)
+
+func resetOrphans() {
+	orphans = make(map[string]orphanBlock)
+	orphansByPrevious = make(map[string][]string)
+	orphanBytes = 0
+}
+
+func testOrphan(i int) block.Block {
+	return block.Block{Previous: fmt.Sprintf("%064x", i), Height: uint32(i + 1)}
+}
+
+func TestOrphanLimits(t *testing.T) {
+	resetOrphans()
+	for i := 0; i < MaxOrphans+10; i++ {
+		addOrphan(testOrphan(i), "peer")
+	}
+	if len(orphans) != MaxOrphans {
+		t.Errorf("Kept %d orphans", len(orphans))
+	}
+	first := testOrphan(0)
+	last := testOrphan(MaxOrphans + 9)
+	if isOrphan(first.HashString()) || !isOrphan(last.HashString()) {
+		t.Errorf("Didn't drop the oldest orphans")
+	}
+
+	// Old orphans expire
+	for hash, orphan := range orphans {
+		orphan.added = time.Now().Add(-2 * orphanExpiry)
+		orphans[hash] = orphan
+	}
+	addOrphan(testOrphan(-1), "peer")
+	if len(orphans) != 1 || len(orphansByPrevious) != 1 {
+		t.Errorf("Kept %d orphans after expiry", len(orphans))
+	}
+
+	big := testOrphan(-2)
+	data := strings.Repeat("ab", transaction.MaxDataSize)
+	for i := 0; i*transaction.MaxDataSize < MaxOrphanSize; i++ {
+		big.Transactions = append(big.Transactions, transaction.Transaction{Data: data})
+	}
+	if addOrphan(big, "peer") || isOrphan(big.HashString()) {
+		t.Errorf("Kept an orphan over the size limit")
+	}
+	if orphanBytes > MaxOrphanBytes {
+		t.Errorf("Orphans take %d bytes", orphanBytes)
+	}
+}
+
+func TestOrphansConnect(t *testing.T) {
+	chain := syncingNode(t, 3)
+	addTestPeer(t, "a", 3)
+	missing := store.MissingBlocks(3)
+	peer := Peers.Get("a")
+
+	// A block sent before the ones it follows is kept, and only those are
+	// asked for
+	receiveBlock(peer, fetchFrom(chain, missing[2].Hash))
+	if !isOrphan(missing[2].Hash) || store.FetchHighestBlock().Height != 0 {
+		t.Fatalf("Orphan not kept")
+	}
+	if _, ok := blocksInFlight[missing[2].Hash]; ok || len(blocksInFlight) != 2 {
+		t.Errorf("Asked for %d blocks, including the orphan", len(blocksInFlight))
+	}
+
+	receiveBlock(peer, fetchFrom(chain, missing[0].Hash))
+	receiveBlock(peer, fetchFrom(chain, missing[1].Hash))
+	if store.FetchHighestBlock().Height != 3 || len(orphans) != 0 {
+		t.Errorf("Orphan not connected, height %d", store.FetchHighestBlock().Height)
+	}
+}
@@ -0,0 +1,310 @@
+package node
+
+import (
+	"bufio"
+	"errors"
+	"github.com/frankh/arachnacoin/crypto"
+	"github.com/frankh/arachnacoin/wire"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// Connected peers are owned by a PeerManager. Each peer has a goroutine
+// reading its messages and one writing queued messages to it, so messages
+// sent from anywhere go out whole and in order, and a peer too slow to
+// keep up is disconnected rather than holding up the sender.
+
+const MaxInbound = 32
+const MaxOutbound = 8
+
+// Messages waiting to be sent to a peer before it's considered stuck
+const sendQueueSize = 256
+
+type Peer struct {
+	Address string // host:port
+	Conn    net.Conn
+	Inbound bool // Whether the peer connected to us
+
+	// Negotiated in the handshake
+	Version    uint32 // The lower of both sides' protocol versions
+	NodeID     uint64
+	UserAgent  string
+	Features   uint64 // Features the peer offers that we know of
+	BestHeight uint32 // The peer's height when it connected
+
+	reader    *bufio.Reader
+	send      chan []byte   // Framed messages for the writer
+	done      chan struct{} // Closed on disconnecting
+	closeOnce sync.Once
+	sentAddr  bool // Whether it's been sent addresses for its getaddr
+
+	addrRequested bool // Whether it's been sent a getaddr it hasn't answered
+	addrsAdded    int  // New addresses it's added to the book
+
+	lock               sync.Mutex
+	score              int  // Misbehaviour, see misbehaving
+	headersRequested   bool // Whether a getheaders is waiting for its reply
+	headersRequestedAt time.Time
+	pingNonce          uint64 // Of the ping waiting for a pong, or 0
+	pingSent           time.Time
+	latency            time.Duration
+}
+
+var errPeerDisconnected = errors.New("peer disconnected")
+
+// Wraps a new connection, starting its writer
+func newPeer(address string, conn net.Conn, inbound bool) *Peer {
+	peer := &Peer{
+		Address: address,
+		Conn:    conn,
+		Inbound: inbound,
+		reader:  bufio.NewReader(conn),
+		send:    make(chan []byte, sendQueueSize),
+		done:    make(chan struct{}),
+	}
+	go peer.writeMessages()
+	return peer
+}
+
+func (p *Peer) writeMessages() {
+	for {
+		select {
+		case frame := <-p.send:
+			p.Conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			_, err := p.Conn.Write(frame)
+			if err != nil {
+				p.Disconnect()
+				return
+			}
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// Closes the connection, which ends the peer's reader and writer. Safe to
+// call more than once.
+func (p *Peer) Disconnect() {
+	p.closeOnce.Do(func() {
+		close(p.done)
+		p.Conn.Close()
+	})
+}
+
+func (p *Peer) isDisconnected() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *Peer) HasFeature(feature uint64) bool {
+	return p.Features&feature != 0
+}
+
+// Queues a message for a peer, disconnecting it if it's too far behind
+func sendMessage(peer *Peer, m message) error {
+	frame, err := wire.Frame(crypto.ActiveNetwork.Magic, m.command(), encodeMessage(m))
+	if err != nil {
+		return err
+	}
+	select {
+	case <-peer.done:
+		return errPeerDisconnected
+	default:
+	}
+
+	select {
+	case peer.send <- frame:
+		return nil
+	default:
+		log.Printf("Disconnecting from peer %s: not reading its messages", peer.Address)
+		peer.Disconnect()
+		return errPeerDisconnected
+	}
+}
+
+type PeerManager struct {
+	lock     sync.Mutex
+	peers    map[string]*Peer
+	listener net.Listener
+	closed   bool
+	running  sync.WaitGroup // Connected peers' readers
+}
+
+var ErrTooManyPeers = errors.New("too many peers")
+var ErrAlreadyConnected = errors.New("already connected")
+var ErrShuttingDown = errors.New("shutting down")
+
+// The node's peers
+var Peers = NewPeerManager()
+
+func NewPeerManager() *PeerManager {
+	return &PeerManager{peers: make(map[string]*Peer)}
+}
+
+// Returns whether another peer connecting in or out would be accepted
+func (m *PeerManager) HasRoom(inbound bool) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return !m.closed && m.count(inbound) < limit(inbound)
+}
+
+func limit(inbound bool) int {
+	if inbound {
+		return MaxInbound
+	}
+	return MaxOutbound
+}
+
+// Counts peers connected in or out. The caller holds the lock.
+func (m *PeerManager) count(inbound bool) int {
+	n := 0
+	for _, peer := range m.peers {
+		if peer.Inbound == inbound {
+			n++
+		}
+	}
+	return n
+}
+
+// Adds a peer that's completed its handshake, failing if there's no room
+// or it's already connected
+func (m *PeerManager) Add(peer *Peer) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.closed {
+		return ErrShuttingDown
+	}
+	if _, ok := m.peers[peer.Address]; ok {
+		return ErrAlreadyConnected
+	}
+	// A node connected to both ways is only kept once
+	for _, other := range m.peers {
+		if peer.NodeID != 0 && other.NodeID == peer.NodeID {
+			return ErrAlreadyConnected
+		}
+	}
+	if m.count(peer.Inbound) >= limit(peer.Inbound) {
+		return ErrTooManyPeers
+	}
+	m.peers[peer.Address] = peer
+	m.running.Add(1)
+	return nil
+}
+
+// Removes a peer once its reader has stopped
+func (m *PeerManager) Remove(peer *Peer) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.peers[peer.Address] == peer {
+		delete(m.peers, peer.Address)
+	}
+}
+
+// Returns the peer connected at an address, or nil
+func (m *PeerManager) Get(address string) *Peer {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.peers[address]
+}
+
+func (m *PeerManager) Connected(address string) bool {
+	return m.Get(address) != nil
+}
+
+// Returns the peers at a host:port, or at any port of an ip
+func (m *PeerManager) Matching(address string) []*Peer {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	peers := make([]*Peer, 0)
+	for _, peer := range m.peers {
+		host, _, _ := net.SplitHostPort(peer.Address)
+		if peer.Address == address || host == address {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
+func (m *PeerManager) List() []*Peer {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	peers := make([]*Peer, 0, len(m.peers))
+	for _, peer := range m.peers {
+		peers = append(peers, peer)
+	}
+	return peers
+}
+
+type PeerInfo struct {
+	Address   string `json:"address"`
+	Inbound   bool   `json:"inbound"`
+	Version   uint32 `json:"version"`
+	UserAgent string `json:"user_agent"`
+	Features  uint64 `json:"features"`
+	Height    uint32 `json:"height"` // How high its chain is known to go
+	Score     int    `json:"score"`  // Misbehaviour score
+	Latency   int64  `json:"latency_ms"`
+}
+
+func (m *PeerManager) Info() []PeerInfo {
+	info := make([]PeerInfo, 0)
+	for _, peer := range m.List() {
+		syncLock.Lock()
+		height := peerHeights[peer.Address]
+		syncLock.Unlock()
+		peer.lock.Lock()
+		score := peer.score
+		peer.lock.Unlock()
+		latency := peer.Latency().Milliseconds()
+		info = append(info, PeerInfo{
+			Address:   peer.Address,
+			Inbound:   peer.Inbound,
+			Version:   peer.Version,
+			UserAgent: peer.UserAgent,
+			Features:  peer.Features,
+			Height:    height,
+			Score:     score,
+			Latency:   latency,
+		})
+	}
+	return info
+}
+
+// Sets the listener peers connect to, closed on shutdown
+func (m *PeerManager) listen(ln net.Listener) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.closed {
+		ln.Close()
+		return ErrShuttingDown
+	}
+	m.listener = ln
+	return nil
+}
+
+func (m *PeerManager) isClosed() bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.closed
+}
+
+// Stops accepting peers and disconnects every peer, waiting until they've
+// stopped
+func (m *PeerManager) Shutdown() {
+	m.lock.Lock()
+	m.closed = true
+	if m.listener != nil {
+		m.listener.Close()
+	}
+	for _, peer := range m.peers {
+		peer.Disconnect()
+	}
+	m.lock.Unlock()
+	m.running.Wait()
+}
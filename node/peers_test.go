@@ -0,0 +1,92 @@
+package node
+
+import (
+	"fmt"
+	"github.com/frankh/arachnacoin/crypto"
+	"github.com/frankh/arachnacoin/store"
+	"github.com/frankh/arachnacoin/wire"
+	"net"
+	"sync"
+	"testing"
+)
+
+func TestPeerLimits(t *testing.T) {
+	Peers = NewPeerManager()
+	for i := 0; i < MaxOutbound; i++ {
+		local, _ := net.Pipe()
+		if err := Peers.Add(newPeer(fmt.Sprint("out", i), local, false)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	local, _ := net.Pipe()
+	if Peers.HasRoom(false) || Peers.Add(newPeer("another", local, false)) != ErrTooManyPeers {
+		t.Errorf("Went over the outbound limit")
+	}
+	if !Peers.HasRoom(true) || Peers.Add(newPeer("in", local, true)) != nil {
+		t.Errorf("Outbound peers counted against inbound")
+	}
+	if Peers.Add(newPeer("out0", local, true)) != ErrAlreadyConnected {
+		t.Errorf("Connected to the same peer twice")
+	}
+	if len(Peers.List()) != MaxOutbound+1 || !Peers.Connected("out0") {
+		t.Errorf("Wrong peers listed")
+	}
+}
+
+func TestSendInOrder(t *testing.T) {
+	local, remote := connPair(t)
+	defer remote.Close()
+	peer := newPeer("peer", local, false)
+	defer peer.Disconnect()
+
+	// Messages sent at once from many goroutines arrive whole
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sendMessage(peer, &MessageGetBlocks{[]string{fmt.Sprintf("%064x", i)}})
+		}(i)
+	}
+	wg.Wait()
+	for i := 0; i < 50; i++ {
+		command, payload, err := wire.ReadMessage(remote, crypto.ActiveNetwork.Magic, maxPayloadSize)
+		if err != nil || command != "getblocks" || decodeMessage(&MessageGetBlocks{}, payload) != nil {
+			t.Fatalf("Bad message %d: %v", i, err)
+		}
+	}
+}
+
+func TestStuckPeer(t *testing.T) {
+	// Nothing reads from a pipe's other end, so the first write never ends
+	local, _ := net.Pipe()
+	peer := newPeer("stuck", local, false)
+	var err error
+	for i := 0; i < sendQueueSize+2 && err == nil; i++ {
+		err = sendMessage(peer, &MessageVerack{})
+	}
+	if err != errPeerDisconnected {
+		t.Errorf("Stuck peer not disconnected")
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	store.Init(":memory:")
+	Peers = NewPeerManager()
+	for i := 0; i < 3; i++ {
+		local, remote := connPair(t)
+		defer remote.Close()
+		peer := newPeer(fmt.Sprint("peer", i), local, false)
+		Peers.Add(peer)
+		go handlePeerConnection(peer)
+	}
+
+	Peers.Shutdown()
+	if len(Peers.List()) != 0 || Peers.HasRoom(true) {
+		t.Errorf("Peers left after shutdown")
+	}
+	local, _ := net.Pipe()
+	if Peers.Add(newPeer("late", local, true)) != ErrShuttingDown {
+		t.Errorf("Added a peer after shutdown")
+	}
+}
@@ -0,0 +1,114 @@
+package node
+
+import (
+	"github.com/frankh/arachnacoin/wire"
+	"log"
+	"time"
+)
+
+// Peers are pinged every pingInterval, and the time to their pong is
+// their latency. Dead connections are found by timeouts: a peer is
+// disconnected if it doesn't answer a ping or a getheaders in time, sends
+// nothing at all for idleTimeout, or takes longer than writeTimeout to
+// take a message. Peers holding up a sync are dropped in reassignStalled.
+
+var pingInterval = 2 * time.Minute
+var pingTimeout = time.Minute
+var headersTimeout = 2 * time.Minute
+var idleTimeout = 5 * time.Minute
+var writeTimeout = time.Minute
+
+// How often peers are checked for pings due and timeouts
+var keepAliveInterval = 5 * time.Second
+
+type MessagePing struct {
+	Nonce uint64
+}
+
+type MessagePong struct {
+	Nonce uint64 // The ping's
+}
+
+func (m *MessagePing) command() string { return "ping" }
+
+func (m *MessagePing) encode(e *wire.Encoder) {
+	e.Uint64(m.Nonce)
+}
+
+func (m *MessagePing) decode(d *wire.Decoder) {
+	m.Nonce = d.Uint64()
+}
+
+func (m *MessagePong) command() string { return "pong" }
+
+func (m *MessagePong) encode(e *wire.Encoder) {
+	e.Uint64(m.Nonce)
+}
+
+func (m *MessagePong) decode(d *wire.Decoder) {
+	m.Nonce = d.Uint64()
+}
+
+// Returns a random nonzero nonce, as zero means no ping is waiting
+func randomNonce() uint64 {
+	for {
+		if nonce := randomNodeID(); nonce != 0 {
+			return nonce
+		}
+	}
+}
+
+// Pings a peer if it's due one, or disconnects it if it's timed out
+func checkPeer(peer *Peer) {
+	now := time.Now()
+	problem := ""
+	var ping uint64
+	peer.lock.Lock()
+	switch {
+	case peer.pingNonce != 0 && now.Sub(peer.pingSent) > pingTimeout:
+		problem = "no pong to its ping"
+	case peer.headersRequested && now.Sub(peer.headersRequestedAt) > headersTimeout:
+		problem = "no headers sent"
+	case peer.pingNonce == 0 && now.Sub(peer.pingSent) > pingInterval:
+		ping = randomNonce()
+		peer.pingNonce = ping
+		peer.pingSent = now
+	}
+	peer.lock.Unlock()
+
+	if problem != "" {
+		log.Printf("Disconnecting from peer %s: %s", peer.Address, problem)
+		peer.Disconnect()
+	} else if ping != 0 {
+		sendMessage(peer, &MessagePing{ping})
+	}
+}
+
+func receivePong(peer *Peer, nonce uint64) {
+	peer.lock.Lock()
+	defer peer.lock.Unlock()
+	// Ignore pongs to old pings
+	if nonce == 0 || nonce != peer.pingNonce {
+		return
+	}
+	peer.latency = time.Since(peer.pingSent)
+	peer.pingNonce = 0
+}
+
+// Returns a peer's latency from its last pong, or 0 if it hasn't answered
+// a ping yet
+func (p *Peer) Latency() time.Duration {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.latency
+}
+
+// Pings peers and disconnects those that have timed out, forever
+func KeepPeersAlive() {
+	for {
+		time.Sleep(keepAliveInterval)
+		for _, peer := range Peers.List() {
+			checkPeer(peer)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package node
+
+import (
+	"github.com/frankh/arachnacoin/store"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPingPong(t *testing.T) {
+	store.Init(":memory:")
+	Peers = NewPeerManager()
+	local, remote := connPair(t)
+	defer local.Close()
+	defer remote.Close()
+	peer := newPeer("remote", local, false)
+	other := newPeer("local", remote, true)
+
+	checkPeer(peer)
+	ping := &MessagePing{}
+	if err := expectMessage(other, ping); err != nil || ping.Nonce == 0 {
+		t.Fatalf("Expected a ping, got %+v %v", ping, err)
+	}
+	// Not pinged again while waiting
+	checkPeer(peer)
+	if peer.pingNonce != ping.Nonce {
+		t.Errorf("Pinged again before the pong")
+	}
+
+	receivePong(peer, ping.Nonce+1)
+	if peer.Latency() != 0 {
+		t.Errorf("Took a pong to another ping")
+	}
+	time.Sleep(10 * time.Millisecond)
+	receivePong(peer, ping.Nonce)
+	if peer.Latency() < 10*time.Millisecond || peer.pingNonce != 0 {
+		t.Errorf("Latency not measured: %s", peer.Latency())
+	}
+}
+
+func TestPeerTimeouts(t *testing.T) {
+	store.Init(":memory:")
+	Peers = NewPeerManager()
+
+	timeouts := []func(p *Peer){
+		func(p *Peer) {
+			p.pingNonce = 1
+			p.pingSent = time.Now().Add(-2 * pingTimeout)
+		},
+		func(p *Peer) {
+			p.pingSent = time.Now()
+			p.headersRequested = true
+			p.headersRequestedAt = time.Now().Add(-2 * headersTimeout)
+		},
+	}
+	for i, timeout := range timeouts {
+		local, remote := connPair(t)
+		peer := newPeer("remote", local, false)
+		timeout(peer)
+		checkPeer(peer)
+		if !peer.isDisconnected() {
+			t.Errorf("Peer %d not disconnected", i)
+		}
+		remote.Close()
+	}
+}
+
+func TestWriteTimeout(t *testing.T) {
+	store.Init(":memory:")
+	defer func(d time.Duration) { writeTimeout = d }(writeTimeout)
+	writeTimeout = 50 * time.Millisecond
+
+	// The remote handshakes, then stops reading
+	local, remote := net.Pipe()
+	defer remote.Close()
+	go func() {
+		version := localVersion()
+		version.NodeID++
+		peer := newPeer("local", remote, true)
+		sendMessage(peer, &version)
+		expectMessage(peer, &MessageVersion{})
+		expectMessage(peer, &MessageVerack{})
+		sendMessage(peer, &MessageVerack{})
+	}()
+	peer := newPeer("remote", local, false)
+	if err := handshake(peer); err != nil {
+		t.Fatal(err)
+	}
+
+	sendMessage(peer, &MessageGetAddr{})
+	select {
+	case <-peer.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Peer not taking a message wasn't disconnected")
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	store.Init(":memory:")
+	Peers = NewPeerManager()
+	defer func(d time.Duration) { idleTimeout = d }(idleTimeout)
+	idleTimeout = 50 * time.Millisecond
+
+	local, remote := connPair(t)
+	defer remote.Close()
+	peer := newPeer("remote", local, false)
+	if err := Peers.Add(peer); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan bool)
+	go func() {
+		handlePeerConnection(peer)
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Silent peer wasn't disconnected")
+	}
+	if Peers.Connected("remote") {
+		t.Errorf("Silent peer still connected")
+	}
+}
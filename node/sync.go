@@ -0,0 +1,180 @@
+package node
+
+import (
+	"fmt"
+	"github.com/frankh/arachnacoin/block"
+	"github.com/frankh/arachnacoin/store"
+	"github.com/frankh/arachnacoin/wire"
+	"log"
+	"time"
+)
+
+// Chains are synced headers first. A node behind a peer sends it a block
+// locator, see store.BlockLocator, and gets back the headers following
+// where their chains fork, a batch at a time until it has them all.
+// Headers are checked and stored before any blocks are asked for, then
+// the blocks are fetched from every peer that has them, see
+// scheduleBlocks. Headers are kept until their blocks arrive, so syncing
+// picks up where it left off after a disconnect.
+
+const MaxHeadersPerMessage = 2000
+
+// Most hashes a locator can have, enough for a chain of 2^50 blocks
+const MaxLocatorSize = 64
+
+// Most blocks asked for at once from a peer
+const MaxBlocksPerPeer = 16
+
+type MessageGetHeaders struct {
+	Locator []string
+}
+
+type MessageHeaders struct {
+	Headers []block.Header
+}
+
+type MessageGetBlocks struct {
+	Hashes []string
+}
+
+func (m *MessageGetHeaders) command() string { return "getheaders" }
+
+func (m *MessageGetHeaders) encode(e *wire.Encoder) {
+	encodeHashes(e, m.Locator)
+}
+
+func (m *MessageGetHeaders) decode(d *wire.Decoder) {
+	m.Locator = decodeHashes(d)
+}
+
+func (m *MessageHeaders) command() string { return "headers" }
+
+func (m *MessageHeaders) encode(e *wire.Encoder) {
+	e.VarUint(uint64(len(m.Headers)))
+	for i := range m.Headers {
+		e.Header(&m.Headers[i])
+	}
+}
+
+func (m *MessageHeaders) decode(d *wire.Decoder) {
+	count := d.Count(wire.MinHeaderSize)
+	m.Headers = make([]block.Header, 0)
+	for i := 0; i < count; i++ {
+		m.Headers = append(m.Headers, d.Header())
+	}
+}
+
+func (m *MessageGetBlocks) command() string { return "getblocks" }
+
+func (m *MessageGetBlocks) encode(e *wire.Encoder) {
+	encodeHashes(e, m.Hashes)
+}
+
+func (m *MessageGetBlocks) decode(d *wire.Decoder) {
+	m.Hashes = decodeHashes(d)
+}
+
+func encodeHashes(e *wire.Encoder, hashes []string) {
+	e.VarUint(uint64(len(hashes)))
+	for _, hash := range hashes {
+		e.Hex(hash)
+	}
+}
+
+func decodeHashes(d *wire.Decoder) []string {
+	count := d.Count(wire.MinHexSize)
+	hashes := make([]string, 0)
+	for i := 0; i < count; i++ {
+		hashes = append(hashes, d.Hex())
+	}
+	return hashes
+}
+
+// Starts syncing from a newly connected peer if it's ahead, and gives it
+// a share of any blocks still to fetch
+func startSync(peer *Peer) {
+	noteHeight(peer, peer.BestHeight)
+	if !peer.HasFeature(FeatureBlocks) {
+		return
+	}
+	if peer.BestHeight > store.FetchBestHeader().Height {
+		requestHeaders(peer)
+	}
+	scheduleBlocks()
+}
+
+// Asks a peer for the headers after our best one, unless it's already
+// been asked and hasn't replied
+func requestHeaders(peer *Peer) {
+	peer.lock.Lock()
+	waiting := peer.headersRequested
+	peer.headersRequested = true
+	if !waiting {
+		peer.headersRequestedAt = time.Now()
+	}
+	peer.lock.Unlock()
+	if waiting {
+		return
+	}
+	log.Printf("Requesting headers from %s", peer.Address)
+	sendMessage(peer, &MessageGetHeaders{store.BlockLocator()})
+}
+
+func handleGetHeaders(peer *Peer, locator []string) {
+	if len(locator) > MaxLocatorSize {
+		misbehaving(peer, scoreOversized, fmt.Sprintf("locator of %d hashes", len(locator)))
+		return
+	}
+	headers := store.LocateHeaders(locator, MaxHeadersPerMessage)
+	sendMessage(peer, &MessageHeaders{headers})
+}
+
+func receiveHeaders(peer *Peer, headers []block.Header) {
+	if len(headers) > MaxHeadersPerMessage {
+		misbehaving(peer, scoreOversized, fmt.Sprintf("%d headers", len(headers)))
+		return
+	}
+	peer.lock.Lock()
+	requested := peer.headersRequested
+	peer.headersRequested = false
+	peer.lock.Unlock()
+	if !requested {
+		misbehaving(peer, scoreUnsolicited, "unrequested headers")
+		return
+	}
+	for _, h := range headers {
+		if store.FetchHeader(h.Hash) != nil {
+			continue
+		}
+		err := store.ValidateHeader(h)
+		if err != nil {
+			misbehaving(peer, headerScore(err), fmt.Sprintf("bad header at height %d: %s", h.Height, err))
+			return
+		}
+		store.StoreHeader(h)
+	}
+	if len(headers) > 0 {
+		last := headers[len(headers)-1]
+		noteHeight(peer, last.Height)
+		log.Printf("Received headers up to %d from %s", last.Height, peer.Address)
+	}
+
+	// A full batch means there are probably more
+	if len(headers) == MaxHeadersPerMessage {
+		requestHeaders(peer)
+	}
+	scheduleBlocks()
+}
+
+func handleGetBlocks(peer *Peer, hashes []string) {
+	if len(hashes) > MaxBlocksPerPeer {
+		misbehaving(peer, scoreOversized, fmt.Sprintf("request for %d blocks", len(hashes)))
+		return
+	}
+	for _, hash := range hashes {
+		b := store.FetchBlock(hash)
+		if b != nil {
+			sendBlockToPeer(*b, peer)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package node
+
+import (
+	"github.com/frankh/arachnacoin/block"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Checks the largest message of each kind fits under its command's limit
+// and decodes back the same
+func TestSyncMessageLimits(t *testing.T) {
+	if block.MaxBlockSize > maxPayloadSize("block") {
+		t.Errorf("Largest valid block is over the block message limit")
+	}
+	hash := strings.Repeat("ab", 64)
+	hashes := func(n int) []string {
+		list := make([]string, n)
+		for i := range list {
+			list[i] = hash
+		}
+		return list
+	}
+	headers := make([]block.Header, MaxHeadersPerMessage)
+	for i := range headers {
+		headers[i] = block.Header{Hash: hash, Previous: hash, Work: 0xffffffff, Height: 0xffffffff}
+	}
+
+	messages := []message{
+		&MessageGetHeaders{hashes(MaxLocatorSize)},
+		&MessageHeaders{headers},
+		&MessageGetBlocks{hashes(MaxBlocksPerPeer)},
+	}
+	for _, m := range messages {
+		payload := encodeMessage(m)
+		if len(payload) > maxPayloadSize(m.command()) {
+			t.Errorf("Largest %s is %d bytes, over its limit", m.command(), len(payload))
+		}
+		decoded := newMessage(m.command())
+		if err := decodeMessage(decoded, payload); err != nil || !reflect.DeepEqual(m, decoded) {
+			t.Errorf("%s changed decoding: %v", m.command(), err)
+		}
+	}
+}
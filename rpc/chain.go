@@ -0,0 +1,121 @@
+package rpc
+
+import (
+	"encoding/hex"
+	"github.com/frankh/arachnacoin/block"
+	"github.com/frankh/arachnacoin/script"
+	"github.com/frankh/arachnacoin/store"
+	"github.com/frankh/arachnacoin/transaction"
+)
+
+// Read only queries against the longest chain. Balances in replies are
+// written in coins, see transaction.FormatAmount.
+type Chain struct{}
+
+type ContractArgs struct {
+	Address string
+}
+
+type ContractReply struct {
+	Address  string           `json:"address"`
+	Terms    transaction.HTLC `json:"terms"`
+	Balance  string           `json:"balance"`
+	Preimage string           `json:"preimage,omitempty"`
+	Height   uint32           `json:"height"`
+}
+
+func (c *Chain) Contract(args *ContractArgs, reply *ContractReply) error {
+	err := checkAddress(args.Address)
+	if err != nil {
+		return err
+	}
+	contract, balance, err := store.FetchContract(args.Address)
+	if err != nil {
+		return err
+	}
+
+	reply.Address = args.Address
+	reply.Terms = contract.Terms
+	reply.Balance = transaction.FormatAmount(balance)
+	reply.Preimage = contract.Preimage
+	reply.Height = store.FetchHighestBlock().Height
+	return nil
+}
+
+type ScriptArgs struct {
+	Address string
+}
+
+type ScriptReply struct {
+	Address string `json:"address"`
+	Lock    string `json:"lock"`
+	Balance string `json:"balance"`
+}
+
+func (c *Chain) Script(args *ScriptArgs, reply *ScriptReply) error {
+	err := checkAddress(args.Address)
+	if err != nil {
+		return err
+	}
+	lock, balance, err := store.FetchScript(args.Address)
+	if err != nil {
+		return err
+	}
+
+	reply.Address = args.Address
+	reply.Lock, err = script.Disassemble(lock)
+	reply.Balance = transaction.FormatAmount(balance)
+	return err
+}
+
+type FindDataArgs struct {
+	Data string // Hex encoded
+}
+
+// Proof that a transaction carrying some data is in a block on the longest
+// chain. The block hash can be recomputed from Previous and Hashes, the
+// hashes of every transaction in the block in order.
+type DataProof struct {
+	Transaction   transaction.Transaction `json:"transaction"`
+	Block         string                  `json:"block"`
+	Previous      string                  `json:"previous"`
+	Height        uint32                  `json:"height"`
+	Confirmations uint32                  `json:"confirmations"`
+	Hashes        []string                `json:"hashes"`
+}
+
+// Checks the proof is self consistent. It's then up to the caller to check
+// the block is on the longest chain.
+func (p *DataProof) Verify() bool {
+	hash := p.Transaction.HashString()
+	found := false
+	transactionHashes := make([][]byte, len(p.Hashes))
+	for i, h := range p.Hashes {
+		found = found || h == hash
+		transactionHashes[i], _ = hex.DecodeString(h)
+	}
+	blockHash := hex.EncodeToString(block.HashFromParts(p.Previous, transactionHashes))
+	return found && blockHash == p.Block
+}
+
+// Finds transactions on the longest chain carrying some data, oldest first
+func (c *Chain) FindData(args *FindDataArgs, reply *[]DataProof) error {
+	height := store.FetchHighestBlock().Height
+	proofs := make([]DataProof, 0)
+	for _, found := range store.FetchTransactionsWithData(args.Data) {
+		hashes := make([]string, len(found.Block.Transactions))
+		for i, t := range found.Block.Transactions {
+			hashes[i] = t.HashString()
+		}
+		proofs = append(proofs, DataProof{
+			found.Transaction,
+			found.Block.HashString(),
+			found.Block.Previous,
+			found.Block.Height,
+			height - found.Block.Height + 1,
+			hashes,
+		})
+	}
+	*reply = proofs
+	return nil
+}
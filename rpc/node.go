@@ -0,0 +1,113 @@
+package rpc
+
+import (
+	"errors"
+	"github.com/frankh/arachnacoin/node"
+	"github.com/frankh/arachnacoin/store"
+	"net"
+	"time"
+)
+
+// Queries about the node itself and its peers
+type Node struct{}
+
+type SyncStatusArgs struct{}
+
+func (n *Node) SyncStatus(args *SyncStatusArgs, reply *node.SyncProgress) error {
+	*reply = node.SyncStatus()
+	return nil
+}
+
+type PeersArgs struct{}
+
+func (n *Node) Peers(args *PeersArgs, reply *[]node.PeerInfo) error {
+	*reply = node.Peers.Info()
+	return nil
+}
+
+type AddNodeArgs struct {
+	Address string
+	Command string // add, remove or onetry
+}
+
+// Adds a peer to keep connected to, removes one, or connects to one once
+func (n *Node) AddNode(args *AddNodeArgs, reply *bool) error {
+	var err error
+	switch args.Command {
+	case "add":
+		err = node.AddNode(args.Address)
+	case "remove":
+		err = node.RemoveNode(args.Address)
+	case "onetry":
+		_, err = node.ConnectToPeer(args.Address)
+	default:
+		err = errors.New("unknown command " + args.Command + ", expected add, remove or onetry")
+	}
+	*reply = err == nil
+	return err
+}
+
+type DisconnectNodeArgs struct {
+	Address string
+}
+
+func (n *Node) DisconnectNode(args *DisconnectNodeArgs, reply *bool) error {
+	err := node.DisconnectNode(args.Address)
+	*reply = err == nil
+	return err
+}
+
+type AddedNodesArgs struct{}
+
+func (n *Node) AddedNodes(args *AddedNodesArgs, reply *[]node.AddedNodeInfo) error {
+	*reply = node.AddedNodes()
+	return nil
+}
+
+type AddressBookArgs struct{}
+
+// Lists the address book of peers
+func (n *Node) AddressBook(args *AddressBookArgs, reply *[]store.PeerAddress) error {
+	*reply = store.FetchAddresses()
+	return nil
+}
+
+type ListBannedArgs struct{}
+
+func (n *Node) ListBanned(args *ListBannedArgs, reply *[]store.BannedPeer) error {
+	*reply = store.FetchBans()
+	return nil
+}
+
+type SetBanArgs struct {
+	Address string // A peer's ip:port, or an ip to ban all its ports
+	Command string // add or remove
+	Seconds int64  // How long to ban for, 0 for node.BanTime
+}
+
+// Bans a peer's address, disconnecting it, or lifts its ban
+func (n *Node) SetBan(args *SetBanArgs, reply *bool) error {
+	host, _, err := net.SplitHostPort(args.Address)
+	if err != nil {
+		host = args.Address
+	}
+	if net.ParseIP(host) == nil {
+		return errors.New(args.Address + " isn't an ip or ip:port")
+	}
+	switch args.Command {
+	case "add":
+		duration := node.BanTime
+		if args.Seconds > 0 {
+			duration = time.Duration(args.Seconds) * time.Second
+		}
+		node.BanPeer(args.Address, duration, "banned by hand")
+	case "remove":
+		if !store.Unban(args.Address) {
+			return errors.New(args.Address + " isn't banned")
+		}
+	default:
+		return errors.New("unknown command " + args.Command + ", expected add or remove")
+	}
+	*reply = true
+	return nil
+}
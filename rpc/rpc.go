@@ -0,0 +1,46 @@
+package rpc
+
+import (
+	"github.com/frankh/arachnacoin/crypto"
+	"log"
+	"net"
+	netrpc "net/rpc"
+	"net/rpc/jsonrpc"
+)
+
+// Only listen locally by default, the wallet methods can spend funds
+const DefaultAddress = "127.0.0.1:31043"
+
+// Serves JSON-RPC requests on address until the listener fails
+func Serve(address string) {
+	server := netrpc.NewServer()
+	server.Register(new(Wallet))
+	server.Register(new(Chain))
+	server.Register(new(Tx))
+	server.Register(new(Node))
+
+	log.Printf("Listening for rpc connections on %s", address)
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		panic(err)
+	}
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			continue
+		}
+		go server.ServeCodec(jsonrpc.NewServerCodec(conn))
+	}
+}
+
+// Checks an address given by a caller, so that typos and addresses for
+// other networks are reported before anything is sent to them
+func checkAddress(address string) error {
+	_, _, err := crypto.DecodeAddress(address)
+	return err
+}
+
+func Dial(address string) (*netrpc.Client, error) {
+	return jsonrpc.Dial("tcp", address)
+}
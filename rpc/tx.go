@@ -0,0 +1,51 @@
+package rpc
+
+import (
+	"encoding/hex"
+	"errors"
+	"github.com/frankh/arachnacoin/store"
+	"github.com/frankh/arachnacoin/transaction"
+)
+
+// Methods for transactions signed away from the node, see store.Partial.
+// Amounts in arguments are written in coins, see transaction.FormatAmount.
+type Tx struct{}
+
+type CreateTxArgs struct {
+	From   string // Any address, it needn't be in a wallet
+	Output string
+	Amount string
+	Data   string // Hex encoded, optional
+	Unlock string // Unlocking script for a script input, with <sig:address> for each signature
+}
+
+type BroadcastArgs struct {
+	Partial store.Partial
+}
+
+// Builds an unsigned partial transaction for signing elsewhere
+func (x *Tx) Create(args *CreateTxArgs, reply *store.Partial) error {
+	err := checkAddress(args.Output)
+	if err != nil {
+		return err
+	}
+	amount, err := transaction.ParseAmount(args.Amount)
+	if err != nil {
+		return err
+	}
+	data, err := hex.DecodeString(args.Data)
+	if err != nil {
+		return errors.New("data is not hex")
+	}
+	*reply, err = store.NewPartial(args.From, args.Output, amount, data, args.Unlock)
+	return err
+}
+
+// Finalizes a fully signed partial transaction and submits it
+func (x *Tx) Broadcast(args *BroadcastArgs, reply *transaction.Transaction) error {
+	t, err := args.Partial.Finalize()
+	if err != nil {
+		return err
+	}
+	return submit(t, reply)
+}
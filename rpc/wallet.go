@@ -0,0 +1,718 @@
+package rpc
+
+import (
+	"encoding/hex"
+	"errors"
+	"github.com/frankh/arachnacoin/crypto"
+	"github.com/frankh/arachnacoin/node"
+	"github.com/frankh/arachnacoin/script"
+	"github.com/frankh/arachnacoin/store"
+	"github.com/frankh/arachnacoin/transaction"
+	"golang.org/x/crypto/ed25519"
+	"strings"
+	"time"
+)
+
+// Methods acting on the node's wallets. Amounts in arguments and replies
+// are written in coins, see transaction.FormatAmount. Arguments with a
+// Wallet field act on the named wallet, or the default wallet if it's
+// empty.
+type Wallet struct{}
+
+type AddressArgs struct {
+	Wallet string
+}
+
+type BalanceArgs struct {
+	Wallet  string
+	Address string // Defaults to the total of all the wallet's addresses
+}
+
+type SendArgs struct {
+	Wallet string
+	Output string
+	Amount string
+	Data   string // Hex encoded, optional
+	From   string // An address in any wallet, defaults to the wallet's first
+	// Whether the payment was confirmed, for wallets whose policies require
+	// it for large payments
+	Confirm bool
+}
+
+type NewAddressArgs struct {
+	Wallet  string
+	Account uint32
+	Label   string
+}
+
+type AddressesArgs struct {
+	Wallet string
+}
+
+type AddressInfo struct {
+	Address   string
+	Path      string // Empty for keys not derived from the seed
+	Label     string
+	Balance   string
+	WatchOnly bool
+}
+
+type RescanArgs struct {
+	Wallet string
+}
+
+type SeedArgs struct {
+	Wallet string
+}
+
+type MnemonicArgs struct {
+	Wallet string
+}
+
+type CreateWalletArgs struct {
+	Name string
+}
+
+type ListWalletsArgs struct{}
+
+type WalletInfo struct {
+	Name      string
+	Default   bool
+	Balance   string
+	WatchOnly bool
+}
+
+type SetDefaultArgs struct {
+	Name string
+}
+
+type SetLabelArgs struct {
+	Address string
+	Label   string
+}
+
+type HistoryArgs struct {
+	Wallet string
+}
+
+type HistoryEntry struct {
+	Transaction transaction.Transaction
+	Height      uint32
+	Received    string
+	Sent        string
+}
+
+type WatchArgs struct {
+	Wallet  string // Created if it doesn't exist
+	Address string
+	Label   string
+}
+
+type ImportSignerArgs struct {
+	Wallet string // Created if it doesn't exist
+}
+
+type SignMessageArgs struct {
+	Wallet  string
+	Address string // An address in any wallet, defaults to the wallet's first
+	Message string
+}
+
+type VerifyMessageArgs struct {
+	Address   string
+	Message   string
+	Signature string // Hex encoded
+}
+
+type ImportKeyArgs struct {
+	Wallet     string // Created if it doesn't exist
+	PrivateKey string // Hex encoded ed25519 private key
+	Label      string
+}
+
+type PolicyArgs struct {
+	Wallet string
+}
+
+// A wallet's spending policy, see store.Policy. Limits of "0" mean no
+// limit.
+type PolicyInfo struct {
+	Wallet       string
+	DailyLimit   string
+	MaxPayment   string
+	ConfirmAbove string
+	Allowed      []string
+	SpentToday   string // Replies only
+	Passphrase   string // Needed to set a policy while the wallet is locked
+}
+
+type SetRewardArgs struct {
+	Address string
+}
+
+type EncryptArgs struct {
+	Passphrase string
+}
+
+type UnlockArgs struct {
+	Passphrase string
+	Timeout    uint32 // Seconds until the wallet locks again
+}
+
+type LockArgs struct{}
+
+type ChangePassphraseArgs struct {
+	Old string
+	New string
+}
+
+type CreateHTLCArgs struct {
+	Wallet    string
+	Recipient string
+	Amount    string
+	HashLock  string
+	Blocks    uint32 // Number of blocks from the current height until the refund is available
+}
+
+type ClaimHTLCArgs struct {
+	Address  string
+	Preimage string
+}
+
+type RefundHTLCArgs struct {
+	Address string
+}
+
+type PayToScriptArgs struct {
+	Wallet string
+	Lock   string // Locking script in its text form
+	Amount string
+}
+
+type SpendScriptArgs struct {
+	Wallet  string
+	Address string
+	Output  string
+	Amount  string
+	// Unlocking script in its text form. Any "<sig>" is replaced with the
+	// wallet's signature of the spending transaction.
+	Unlock string
+}
+
+// Returns the first key of the named wallet, or of the default wallet
+func walletKey(name string) (*store.Wallet, error) {
+	if name == "" {
+		name = store.DefaultWallet()
+	}
+	key, err := store.FetchWallet(name)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// Checks the named wallet exists, returning the default wallet's name if
+// name is empty
+func walletName(name string) (string, error) {
+	key, err := walletKey(name)
+	if err != nil {
+		return "", err
+	}
+	return key.WalletName, nil
+}
+
+func (w *Wallet) Address(args *AddressArgs, reply *string) error {
+	key, err := walletKey(args.Wallet)
+	if err != nil {
+		return err
+	}
+	*reply = key.Address()
+	return nil
+}
+
+func (w *Wallet) Balance(args *BalanceArgs, reply *string) error {
+	if args.Address == "" {
+		name, err := walletName(args.Wallet)
+		if err != nil {
+			return err
+		}
+		total, err := store.GetWalletBalance(name)
+		if err != nil {
+			return err
+		}
+		*reply = transaction.FormatAmount(total)
+		return nil
+	}
+
+	err := checkAddress(args.Address)
+	if err != nil {
+		return err
+	}
+	*reply = transaction.FormatAmount(store.GetBalance(args.Address))
+	return nil
+}
+
+// Derives the next address in an account from the wallet seed
+func (w *Wallet) NewAddress(args *NewAddressArgs, reply *string) error {
+	name, err := walletName(args.Wallet)
+	if err != nil {
+		return err
+	}
+	key, err := store.NewAddress(name, args.Account)
+	if err != nil {
+		return err
+	}
+	if args.Label != "" {
+		err = store.SetLabel(key.Address(), args.Label)
+		if err != nil {
+			return err
+		}
+	}
+	*reply = key.Address()
+	return nil
+}
+
+func (w *Wallet) Addresses(args *AddressesArgs, reply *[]AddressInfo) error {
+	name, err := walletName(args.Wallet)
+	if err != nil {
+		return err
+	}
+	*reply = []AddressInfo{}
+	for _, key := range store.FetchWallets(name) {
+		*reply = append(*reply, AddressInfo{
+			Address:   key.Address(),
+			Path:      key.Path,
+			Label:     key.Label,
+			Balance:   transaction.FormatAmount(store.GetBalance(key.Address())),
+			WatchOnly: key.WatchOnly,
+		})
+	}
+	return nil
+}
+
+// Scans the chain for used addresses derived from the seed, replying with
+// the number of new ones found
+func (w *Wallet) Rescan(args *RescanArgs, reply *int) error {
+	name, err := walletName(args.Wallet)
+	if err != nil {
+		return err
+	}
+	found, err := store.ScanWallet(name)
+	*reply = found
+	return err
+}
+
+// Replies with the mnemonic phrase the wallet seed came from. This plus
+// any passphrase chosen when creating the wallet restores it.
+func (w *Wallet) Mnemonic(args *MnemonicArgs, reply *string) error {
+	name, err := walletName(args.Wallet)
+	if err != nil {
+		return err
+	}
+	mnemonic, err := store.FetchMnemonic(name)
+	if err != nil {
+		return err
+	}
+	if mnemonic == "" {
+		return errors.New("wallet seed didn't come from a mnemonic, back up wallet seed instead")
+	}
+	*reply = mnemonic
+	return nil
+}
+
+// Replies with the hex encoded wallet seed, from which every derived
+// address can be restored
+func (w *Wallet) Seed(args *SeedArgs, reply *string) error {
+	name, err := walletName(args.Wallet)
+	if err != nil {
+		return err
+	}
+	seed, err := store.FetchSeed(name)
+	if err != nil {
+		return err
+	}
+	if seed == nil {
+		return errors.New("wallet has no seed")
+	}
+	*reply = hex.EncodeToString(seed)
+	return nil
+}
+
+func (w *Wallet) Send(args *SendArgs, reply *transaction.Transaction) error {
+	err := checkAddress(args.Output)
+	if err != nil {
+		return err
+	}
+	amount, err := transaction.ParseAmount(args.Amount)
+	if err != nil {
+		return err
+	}
+	data, err := hex.DecodeString(args.Data)
+	if err != nil {
+		return errors.New("data is not hex")
+	}
+	from, err := walletKey(args.Wallet)
+	if err != nil {
+		return err
+	}
+	if args.From != "" {
+		from = store.FetchWalletByAddress(args.From)
+		if from == nil {
+			return errors.New("address " + args.From + " is not in any wallet")
+		}
+	}
+	if args.Confirm {
+		from = from.Confirmed()
+	}
+	t, err := from.Send(args.Output, amount, data)
+	if err != nil {
+		return err
+	}
+	return submit(t, reply)
+}
+
+// Locks funds from the wallet into a new contract, replying with the
+// funding transaction. Its output is the contract address.
+func (w *Wallet) CreateHTLC(args *CreateHTLCArgs, reply *transaction.Transaction) error {
+	err := checkAddress(args.Recipient)
+	if err != nil {
+		return err
+	}
+	amount, err := transaction.ParseAmount(args.Amount)
+	if err != nil {
+		return err
+	}
+	key, err := walletKey(args.Wallet)
+	if err != nil {
+		return err
+	}
+	t, err := key.LockHTLC(transaction.HTLC{
+		Recipient: args.Recipient,
+		Refund:    key.Address(),
+		HashLock:  args.HashLock,
+		Timeout:   store.FetchHighestBlock().Height + args.Blocks,
+	}, amount)
+	if err != nil {
+		return err
+	}
+	return submit(t, reply)
+}
+
+func (w *Wallet) ClaimHTLC(args *ClaimHTLCArgs, reply *transaction.Transaction) error {
+	t, err := store.ClaimHTLC(args.Address, args.Preimage)
+	if err != nil {
+		return err
+	}
+	return submit(t, reply)
+}
+
+func (w *Wallet) RefundHTLC(args *RefundHTLCArgs, reply *transaction.Transaction) error {
+	t, err := store.RefundHTLC(args.Address)
+	if err != nil {
+		return err
+	}
+	return submit(t, reply)
+}
+
+func submit(t transaction.Transaction, reply *transaction.Transaction) error {
+	err := node.SubmitTransaction(t)
+	if err != nil {
+		// It was never sent, so it doesn't count towards a spending limit
+		store.ForgetSpend(t.HashString())
+		return err
+	}
+	*reply = t
+	return nil
+}
+
+// Pays funds from the wallet to a locking script, replying with the
+// transaction. Its output is the script address.
+func (w *Wallet) PayToScript(args *PayToScriptArgs, reply *transaction.Transaction) error {
+	amount, err := transaction.ParseAmount(args.Amount)
+	if err != nil {
+		return err
+	}
+	lock, err := script.Assemble(args.Lock)
+	if err != nil {
+		return err
+	}
+	key, err := walletKey(args.Wallet)
+	if err != nil {
+		return err
+	}
+	t, err := key.PayToScript(lock, amount)
+	if err != nil {
+		return err
+	}
+	return submit(t, reply)
+}
+
+func (w *Wallet) SpendScript(args *SpendScriptArgs, reply *transaction.Transaction) error {
+	err := checkAddress(args.Output)
+	if err != nil {
+		return err
+	}
+	amount, err := transaction.ParseAmount(args.Amount)
+	if err != nil {
+		return err
+	}
+	key, err := walletKey(args.Wallet)
+	if err != nil {
+		return err
+	}
+	t, err := store.SpendScript(args.Address, args.Output, amount)
+	if err != nil {
+		return err
+	}
+
+	signature, err := key.SignTransactionHash(&t)
+	if err != nil {
+		return err
+	}
+	unlock, err := script.Assemble(strings.Replace(args.Unlock, "<sig>", "0x"+hex.EncodeToString(signature), -1))
+	if err != nil {
+		return err
+	}
+	t.Unlock = hex.EncodeToString(unlock)
+	return submit(t, reply)
+}
+
+// Encrypts the wallet's keys with a passphrase, leaving it locked
+func (w *Wallet) Encrypt(args *EncryptArgs, reply *bool) error {
+	err := store.EncryptWallet(args.Passphrase)
+	*reply = err == nil
+	return err
+}
+
+// Unlocks the wallet for signing for a number of seconds
+func (w *Wallet) Unlock(args *UnlockArgs, reply *bool) error {
+	if args.Timeout == 0 {
+		return errors.New("timeout must be at least a second")
+	}
+	err := store.Unlock(args.Passphrase, time.Duration(args.Timeout)*time.Second)
+	*reply = err == nil
+	return err
+}
+
+func (w *Wallet) Lock(args *LockArgs, reply *bool) error {
+	store.Lock()
+	*reply = true
+	return nil
+}
+
+func (w *Wallet) ChangePassphrase(args *ChangePassphraseArgs, reply *bool) error {
+	err := store.ChangePassphrase(args.Old, args.New)
+	*reply = err == nil
+	return err
+}
+
+// Adds a wallet with a new seed
+func (w *Wallet) Create(args *CreateWalletArgs, reply *string) error {
+	err := store.CreateWallet(args.Name)
+	if err != nil {
+		return err
+	}
+	key, _ := store.FetchWallet(args.Name)
+	*reply = key.Address()
+	return nil
+}
+
+func (w *Wallet) List(args *ListWalletsArgs, reply *[]WalletInfo) error {
+	*reply = []WalletInfo{}
+	for _, name := range store.WalletNames() {
+		balance, err := store.GetWalletBalance(name)
+		if err != nil {
+			return err
+		}
+		*reply = append(*reply, WalletInfo{
+			Name:      name,
+			Default:   name == store.DefaultWallet(),
+			Balance:   transaction.FormatAmount(balance),
+			WatchOnly: store.IsWatchOnly(name),
+		})
+	}
+	return nil
+}
+
+func (w *Wallet) SetDefault(args *SetDefaultArgs, reply *bool) error {
+	err := store.SetDefaultWallet(args.Name)
+	*reply = err == nil
+	return err
+}
+
+func (w *Wallet) SetLabel(args *SetLabelArgs, reply *bool) error {
+	err := store.SetLabel(args.Address, args.Label)
+	*reply = err == nil
+	return err
+}
+
+// Replies with the transactions on the longest chain affecting the
+// wallet, oldest first
+func (w *Wallet) History(args *HistoryArgs, reply *[]HistoryEntry) error {
+	name, err := walletName(args.Wallet)
+	if err != nil {
+		return err
+	}
+	*reply = []HistoryEntry{}
+	for _, entry := range store.FetchHistory(name) {
+		*reply = append(*reply, HistoryEntry{
+			Transaction: entry.Transaction,
+			Height:      entry.Height,
+			Received:    transaction.FormatAmount(entry.Received),
+			Sent:        transaction.FormatAmount(entry.Sent),
+		})
+	}
+	return nil
+}
+
+// Adds an address whose key is kept elsewhere to the wallet, so its
+// balance and history can be followed
+func (w *Wallet) Watch(args *WatchArgs, reply *bool) error {
+	name := args.Wallet
+	if name == "" {
+		name = store.DefaultWallet()
+	}
+	err := store.WatchAddress(name, args.Address, args.Label)
+	*reply = err == nil
+	return err
+}
+
+// Watches the addresses of the external signer's keys, so the wallet can
+// spend from them. Replies with the number of new addresses.
+func (w *Wallet) ImportSigner(args *ImportSignerArgs, reply *int) error {
+	if store.ExternalSigner == nil {
+		return errors.New("node wasn't started with a signer")
+	}
+	addresses, err := store.ExternalSigner.Addresses()
+	if err != nil {
+		return err
+	}
+	name := args.Wallet
+	if name == "" {
+		name = store.DefaultWallet()
+	}
+	for _, address := range addresses {
+		if store.FetchWalletByAddress(address) != nil {
+			continue
+		}
+		err = store.WatchAddress(name, address, "")
+		if err != nil {
+			return err
+		}
+		*reply++
+	}
+	return nil
+}
+
+// Signs a message with an address's key to prove control of it, replying
+// with the hex signature
+func (w *Wallet) SignMessage(args *SignMessageArgs, reply *string) error {
+	key, err := walletKey(args.Wallet)
+	if err != nil {
+		return err
+	}
+	if args.Address != "" {
+		key = store.FetchWalletByAddress(args.Address)
+		if key == nil {
+			return errors.New("address " + args.Address + " is not in any wallet")
+		}
+	}
+	signature, err := key.SignMessage(args.Message)
+	if err != nil {
+		return err
+	}
+	*reply = hex.EncodeToString(signature)
+	return nil
+}
+
+func (w *Wallet) VerifyMessage(args *VerifyMessageArgs, reply *bool) error {
+	err := checkAddress(args.Address)
+	if err != nil {
+		return err
+	}
+	signature, err := hex.DecodeString(args.Signature)
+	if err != nil {
+		return errors.New("signature is not hex")
+	}
+	*reply = crypto.VerifyMessage(args.Address, []byte(args.Message), signature)
+	return nil
+}
+
+// Adds a key generated elsewhere to the wallet, replying with its address.
+// Backups of the wallet's seed don't cover it.
+func (w *Wallet) ImportKey(args *ImportKeyArgs, reply *string) error {
+	priv, err := hex.DecodeString(args.PrivateKey)
+	if err != nil || len(priv) != ed25519.PrivateKeySize {
+		return errors.New("private key must be 64 bytes of hex")
+	}
+	// The second half is the public key, check it matches the first
+	full := ed25519.NewKeyFromSeed(priv[:ed25519.SeedSize])
+	if hex.EncodeToString(full) != strings.ToLower(args.PrivateKey) {
+		return errors.New("private key doesn't match its public key")
+	}
+	key := store.Wallet{
+		PublicKey:  full.Public().(ed25519.PublicKey),
+		PrivateKey: full,
+	}
+	name := args.Wallet
+	if name == "" {
+		name = store.DefaultWallet()
+	}
+	err = store.ImportKey(name, key, args.Label)
+	if err != nil {
+		return err
+	}
+	*reply = key.Address()
+	return nil
+}
+
+func (w *Wallet) Policy(args *PolicyArgs, reply *PolicyInfo) error {
+	name, err := walletName(args.Wallet)
+	if err != nil {
+		return err
+	}
+	policy := store.FetchPolicy(name)
+	*reply = PolicyInfo{
+		Wallet:       name,
+		DailyLimit:   transaction.FormatAmount(policy.DailyLimit),
+		MaxPayment:   transaction.FormatAmount(policy.MaxPayment),
+		ConfirmAbove: transaction.FormatAmount(policy.ConfirmAbove),
+		Allowed:      append([]string{}, policy.Allowed...),
+		SpentToday:   transaction.FormatAmount(store.SpentToday(name)),
+	}
+	return nil
+}
+
+// Replaces the wallet's spending policy
+func (w *Wallet) SetPolicy(args *PolicyInfo, reply *bool) error {
+	name, err := walletName(args.Wallet)
+	if err != nil {
+		return err
+	}
+	var policy store.Policy
+	amounts := []struct {
+		text  string
+		value *uint64
+	}{
+		{args.DailyLimit, &policy.DailyLimit},
+		{args.MaxPayment, &policy.MaxPayment},
+		{args.ConfirmAbove, &policy.ConfirmAbove},
+	}
+	for _, amount := range amounts {
+		*amount.value, err = transaction.ParseAmount(amount.text)
+		if err != nil {
+			return err
+		}
+	}
+	policy.Allowed = args.Allowed
+	err = store.SetPolicy(name, policy, args.Passphrase)
+	*reply = err == nil
+	return err
+}
+
+// Sets the address mining rewards are paid to, which can be in any wallet
+func (w *Wallet) SetReward(args *SetRewardArgs, reply *bool) error {
+	err := store.SetRewardAddress(args.Address)
+	*reply = err == nil
+	return err
+}
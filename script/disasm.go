@@ -0,0 +1,55 @@
+package script
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Returns the text form of a script. Opcodes are written by name and data
+// pushes as 0x prefixed hex, e.g. "OP_SHA256 0x2cf2... OP_EQUAL".
+func Disassemble(script []byte) (string, error) {
+	instructions, err := parse(script)
+	if err != nil {
+		return "", err
+	}
+
+	words := make([]string, 0, len(instructions))
+	for _, ins := range instructions {
+		if ins.op > OP_0 && ins.op <= OP_PUSHDATA2 {
+			words = append(words, "0x"+hex.EncodeToString(ins.data))
+		} else if name, ok := opcodeNames[ins.op]; ok {
+			words = append(words, name)
+		} else {
+			words = append(words, fmt.Sprintf("OP_UNKNOWN_%02x", ins.op))
+		}
+	}
+	return strings.Join(words, " "), nil
+}
+
+// Builds a script from its text form. As well as the output of
+// Disassemble, plain decimal numbers are accepted and pushed as numbers.
+func Assemble(text string) ([]byte, error) {
+	script := make([]byte, 0)
+	for _, word := range strings.Fields(text) {
+		if op, ok := opcodesByName[word]; ok {
+			script = append(script, op)
+		} else if strings.HasPrefix(word, "0x") {
+			data, err := hex.DecodeString(word[2:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid data %q", word)
+			}
+			script = AddData(script, data)
+		} else if n, err := strconv.ParseUint(word, 10, 32); err == nil {
+			script = AddNumber(script, uint32(n))
+		} else {
+			return nil, fmt.Errorf("unknown word %q", word)
+		}
+	}
+
+	if len(script) > MaxScriptSize {
+		return nil, ErrScriptTooBig
+	}
+	return script, nil
+}
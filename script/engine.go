@@ -0,0 +1,290 @@
+package script
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ed25519"
+)
+
+// What a script can see of the transaction spending it
+type Context struct {
+	Hash   []byte // Transaction hash that OP_CHECKSIG signatures are checked against
+	Height uint32 // Height of the block the transaction is in
+}
+
+var ErrFailed = errors.New("script returned false")
+
+type engine struct {
+	ctx   Context
+	stack [][]byte
+	ops   int
+}
+
+// Runs the unlocking script followed by the locking script on the same
+// stack. Returns nil if the spend is allowed, which is when both run
+// without error and leave a true value on top of the stack.
+func Execute(unlock []byte, lock []byte, ctx Context) error {
+	if !IsPushOnly(unlock) {
+		return errors.New("unlocking script must only push data")
+	}
+
+	e := &engine{ctx, make([][]byte, 0), 0}
+	err := e.run(unlock)
+	if err != nil {
+		return err
+	}
+	err = e.run(lock)
+	if err != nil {
+		return err
+	}
+
+	if len(e.stack) == 0 || !asBool(e.stack[len(e.stack)-1]) {
+		return ErrFailed
+	}
+	return nil
+}
+
+func (e *engine) run(script []byte) error {
+	instructions, err := parse(script)
+	if err != nil {
+		return err
+	}
+
+	// Whether each enclosing OP_IF branch is being executed
+	branches := make([]bool, 0)
+	for _, ins := range instructions {
+		executing := true
+		for _, b := range branches {
+			executing = executing && b
+		}
+
+		if !isPush(ins.op) {
+			e.ops++
+			if e.ops > MaxOps {
+				return ErrTooManyOps
+			}
+		}
+
+		switch ins.op {
+		case OP_IF, OP_NOTIF:
+			branch := false
+			if executing {
+				v, err := e.pop()
+				if err != nil {
+					return err
+				}
+				branch = asBool(v) == (ins.op == OP_IF)
+			}
+			branches = append(branches, branch)
+			continue
+		case OP_ELSE:
+			if len(branches) == 0 {
+				return errors.New("OP_ELSE without OP_IF")
+			}
+			branches[len(branches)-1] = !branches[len(branches)-1]
+			continue
+		case OP_ENDIF:
+			if len(branches) == 0 {
+				return errors.New("OP_ENDIF without OP_IF")
+			}
+			branches = branches[:len(branches)-1]
+			continue
+		}
+
+		if !executing {
+			continue
+		}
+		err = e.step(ins)
+		if err != nil {
+			return err
+		}
+		if len(e.stack) > MaxStackSize {
+			return ErrStackSize
+		}
+	}
+
+	if len(branches) != 0 {
+		return errors.New("OP_IF without OP_ENDIF")
+	}
+	return nil
+}
+
+func (e *engine) step(ins instruction) error {
+	switch {
+	case ins.op == OP_0 || (ins.op > OP_0 && ins.op <= OP_PUSHDATA2):
+		e.push(ins.data)
+		return nil
+	case ins.op >= OP_1 && ins.op <= OP_16:
+		e.push(encodeNumber(uint32(ins.op - OP_1 + 1)))
+		return nil
+	}
+
+	switch ins.op {
+	case OP_VERIFY:
+		return e.verify()
+	case OP_RETURN:
+		return ErrFailed
+
+	case OP_DROP:
+		_, err := e.pop()
+		return err
+	case OP_DUP:
+		v, err := e.peek()
+		if err != nil {
+			return err
+		}
+		e.push(v)
+	case OP_SWAP:
+		a, b, err := e.pop2()
+		if err != nil {
+			return err
+		}
+		e.push(b)
+		e.push(a)
+	case OP_SIZE:
+		v, err := e.peek()
+		if err != nil {
+			return err
+		}
+		e.push(encodeNumber(uint32(len(v))))
+
+	case OP_EQUAL, OP_EQUALVERIFY:
+		a, b, err := e.pop2()
+		if err != nil {
+			return err
+		}
+		e.pushBool(bytes.Equal(a, b))
+		if ins.op == OP_EQUALVERIFY {
+			return e.verify()
+		}
+
+	case OP_NOT:
+		v, err := e.pop()
+		if err != nil {
+			return err
+		}
+		e.pushBool(!asBool(v))
+	case OP_BOOLAND, OP_BOOLOR:
+		a, b, err := e.pop2()
+		if err != nil {
+			return err
+		}
+		if ins.op == OP_BOOLAND {
+			e.pushBool(asBool(a) && asBool(b))
+		} else {
+			e.pushBool(asBool(a) || asBool(b))
+		}
+
+	case OP_SHA256:
+		v, err := e.pop()
+		if err != nil {
+			return err
+		}
+		hash := sha256.Sum256(v)
+		e.push(hash[:])
+	case OP_SHA512:
+		v, err := e.pop()
+		if err != nil {
+			return err
+		}
+		hash := sha512.Sum512(v)
+		e.push(hash[:])
+
+	// Expects the signature below the public key
+	case OP_CHECKSIG, OP_CHECKSIGVERIFY:
+		signature, pubKey, err := e.pop2()
+		if err != nil {
+			return err
+		}
+		e.pushBool(len(pubKey) == ed25519.PublicKeySize && ed25519.Verify(pubKey, e.ctx.Hash, signature))
+		if ins.op == OP_CHECKSIGVERIFY {
+			return e.verify()
+		}
+
+	// Fails unless the transaction is in a block at least as high as the
+	// number on top of the stack, which is removed. Blocks' heights are
+	// checked against the chain before their scripts are run, see
+	// store.ValidateBlock.
+	case OP_CHECKHEIGHTVERIFY:
+		v, err := e.pop()
+		if err != nil {
+			return err
+		}
+		height, err := decodeNumber(v)
+		if err != nil {
+			return err
+		}
+		if e.ctx.Height < height {
+			return fmt.Errorf("locked until height %d", height)
+		}
+
+	default:
+		return fmt.Errorf("unknown opcode 0x%02x", ins.op)
+	}
+	return nil
+}
+
+func (e *engine) push(v []byte) {
+	e.stack = append(e.stack, v)
+}
+
+func (e *engine) pushBool(b bool) {
+	if b {
+		e.push([]byte{1})
+	} else {
+		e.push([]byte{})
+	}
+}
+
+func (e *engine) peek() ([]byte, error) {
+	if len(e.stack) == 0 {
+		return nil, errors.New("stack empty")
+	}
+	return e.stack[len(e.stack)-1], nil
+}
+
+func (e *engine) pop() ([]byte, error) {
+	v, err := e.peek()
+	if err != nil {
+		return nil, err
+	}
+	e.stack = e.stack[:len(e.stack)-1]
+	return v, nil
+}
+
+// Pops the top two items, returning them in the order they were pushed
+func (e *engine) pop2() ([]byte, []byte, error) {
+	b, err := e.pop()
+	if err != nil {
+		return nil, nil, err
+	}
+	a, err := e.pop()
+	if err != nil {
+		return nil, nil, err
+	}
+	return a, b, nil
+}
+
+func (e *engine) verify() error {
+	v, err := e.pop()
+	if err != nil {
+		return err
+	}
+	if !asBool(v) {
+		return ErrFailed
+	}
+	return nil
+}
+
+// Any item other than empty or all zeroes is true
+func asBool(v []byte) bool {
+	for _, b := range v {
+		if b != 0 {
+			return true
+		}
+	}
+	return false
+}
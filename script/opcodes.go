@@ -0,0 +1,78 @@
+package script
+
+import (
+	"strconv"
+)
+
+// Opcodes are a single byte. 0x01-0x4b push that many following bytes.
+const (
+	OP_0         = 0x00
+	OP_PUSHDATA1 = 0x4c // Next byte is the length of the data to push
+	OP_PUSHDATA2 = 0x4d // Next 2 bytes (little endian) are the length of the data to push
+	OP_1         = 0x51 // OP_1 to OP_16 push the numbers 1 to 16
+	OP_16        = 0x60
+
+	OP_IF     = 0x63
+	OP_NOTIF  = 0x64
+	OP_ELSE   = 0x67
+	OP_ENDIF  = 0x68
+	OP_VERIFY = 0x69
+	OP_RETURN = 0x6a
+
+	OP_DROP = 0x75
+	OP_DUP  = 0x76
+	OP_SWAP = 0x7c
+	OP_SIZE = 0x82
+
+	OP_EQUAL       = 0x87
+	OP_EQUALVERIFY = 0x88
+
+	OP_NOT     = 0x91
+	OP_BOOLAND = 0x9a
+	OP_BOOLOR  = 0x9b
+
+	OP_SHA256 = 0xa8
+	OP_SHA512 = 0xa9
+
+	OP_CHECKSIG       = 0xac
+	OP_CHECKSIGVERIFY = 0xad
+
+	OP_CHECKHEIGHTVERIFY = 0xb1
+)
+
+var opcodeNames = map[byte]string{
+	OP_0:                 "OP_0",
+	OP_PUSHDATA1:         "OP_PUSHDATA1",
+	OP_PUSHDATA2:         "OP_PUSHDATA2",
+	OP_IF:                "OP_IF",
+	OP_NOTIF:             "OP_NOTIF",
+	OP_ELSE:              "OP_ELSE",
+	OP_ENDIF:             "OP_ENDIF",
+	OP_VERIFY:            "OP_VERIFY",
+	OP_RETURN:            "OP_RETURN",
+	OP_DROP:              "OP_DROP",
+	OP_DUP:               "OP_DUP",
+	OP_SWAP:              "OP_SWAP",
+	OP_SIZE:              "OP_SIZE",
+	OP_EQUAL:             "OP_EQUAL",
+	OP_EQUALVERIFY:       "OP_EQUALVERIFY",
+	OP_NOT:               "OP_NOT",
+	OP_BOOLAND:           "OP_BOOLAND",
+	OP_BOOLOR:            "OP_BOOLOR",
+	OP_SHA256:            "OP_SHA256",
+	OP_SHA512:            "OP_SHA512",
+	OP_CHECKSIG:          "OP_CHECKSIG",
+	OP_CHECKSIGVERIFY:    "OP_CHECKSIGVERIFY",
+	OP_CHECKHEIGHTVERIFY: "OP_CHECKHEIGHTVERIFY",
+}
+
+var opcodesByName = make(map[string]byte)
+
+func init() {
+	for op := byte(OP_1); op <= OP_16; op++ {
+		opcodeNames[op] = "OP_" + strconv.Itoa(int(op-OP_1+1))
+	}
+	for op, name := range opcodeNames {
+		opcodesByName[name] = op
+	}
+}
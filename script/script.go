@@ -0,0 +1,145 @@
+package script
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Resource limits, checked before and during execution so that a script
+// can never make validation slow or memory hungry.
+const (
+	MaxScriptSize = 1024 // Bytes in a single locking or unlocking script
+	MaxPushSize   = 520  // Bytes in a single stack item
+	MaxOps        = 200  // Non-push opcodes executed across both scripts
+	MaxStackSize  = 100  // Items on the stack at once
+)
+
+var (
+	ErrScriptTooBig = errors.New("script too big")
+	ErrPushTooBig   = errors.New("push too big")
+	ErrTruncated    = errors.New("script ended inside a push")
+	ErrTooManyOps   = errors.New("too many operations")
+	ErrStackSize    = errors.New("stack too big")
+)
+
+type instruction struct {
+	op   byte
+	data []byte
+}
+
+// Splits a script into its instructions, checking pushes are well formed.
+func parse(script []byte) ([]instruction, error) {
+	if len(script) > MaxScriptSize {
+		return nil, ErrScriptTooBig
+	}
+
+	instructions := make([]instruction, 0)
+	for i := 0; i < len(script); {
+		op := script[i]
+		i++
+
+		var size int
+		switch {
+		case op > OP_0 && op < OP_PUSHDATA1:
+			size = int(op)
+		case op == OP_PUSHDATA1:
+			if i+1 > len(script) {
+				return nil, ErrTruncated
+			}
+			size = int(script[i])
+			i++
+		case op == OP_PUSHDATA2:
+			if i+2 > len(script) {
+				return nil, ErrTruncated
+			}
+			size = int(binary.LittleEndian.Uint16(script[i:]))
+			i += 2
+		default:
+			instructions = append(instructions, instruction{op, nil})
+			continue
+		}
+
+		if size > MaxPushSize {
+			return nil, ErrPushTooBig
+		}
+		if i+size > len(script) {
+			return nil, ErrTruncated
+		}
+		instructions = append(instructions, instruction{op, script[i : i+size]})
+		i += size
+	}
+
+	return instructions, nil
+}
+
+// Whether the script only pushes data. Unlocking scripts must be push
+// only so they can't change what the locking script does.
+func IsPushOnly(script []byte) bool {
+	instructions, err := parse(script)
+	if err != nil {
+		return false
+	}
+	for _, ins := range instructions {
+		if !isPush(ins.op) {
+			return false
+		}
+	}
+	return true
+}
+
+func isPush(op byte) bool {
+	return op <= OP_PUSHDATA2 || (op >= OP_1 && op <= OP_16)
+}
+
+// Appends a push of data to the script using the smallest encoding
+func AddData(script []byte, data []byte) []byte {
+	switch {
+	case len(data) == 0:
+		return append(script, OP_0)
+	case len(data) < OP_PUSHDATA1:
+		script = append(script, byte(len(data)))
+	case len(data) <= 0xff:
+		script = append(script, OP_PUSHDATA1, byte(len(data)))
+	default:
+		size := make([]byte, 2)
+		binary.LittleEndian.PutUint16(size, uint16(len(data)))
+		script = append(script, OP_PUSHDATA2)
+		script = append(script, size...)
+	}
+	return append(script, data...)
+}
+
+// Appends a push of a number to the script
+func AddNumber(script []byte, n uint32) []byte {
+	if n == 0 {
+		return append(script, OP_0)
+	}
+	if n <= 16 {
+		return append(script, byte(OP_1+n-1))
+	}
+	return AddData(script, encodeNumber(n))
+}
+
+// Numbers are little endian with no padding, up to 4 bytes
+func encodeNumber(n uint32) []byte {
+	b := make([]byte, 0, 4)
+	for n > 0 {
+		b = append(b, byte(n))
+		n >>= 8
+	}
+	return b
+}
+
+func decodeNumber(b []byte) (uint32, error) {
+	if len(b) > 4 {
+		return 0, errors.New("number too big")
+	}
+	if len(b) > 0 && b[len(b)-1] == 0 {
+		return 0, errors.New("number not minimally encoded")
+	}
+	n := uint32(0)
+	for i := len(b) - 1; i >= 0; i-- {
+		n = n<<8 | uint32(b[i])
+	}
+	return n, nil
+}
@@ -0,0 +1,161 @@
+package script
+
+import (
+	"bytes"
+	"encoding/hex"
+	"golang.org/x/crypto/ed25519"
+	"strings"
+	"testing"
+)
+
+var testKey = ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+var testPub = hex.EncodeToString(testKey.Public().(ed25519.PublicKey))
+var testHash = []byte("transaction hash")
+var testSig = hex.EncodeToString(ed25519.Sign(testKey, testHash))
+
+// sha256("secret")
+const secretHash = "2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b"
+
+var vectors = []struct {
+	name   string
+	unlock string
+	lock   string
+	height uint32
+	valid  bool
+}{
+	{"true", "", "OP_1", 0, true},
+	{"false", "", "OP_0", 0, false},
+	{"empty stack", "", "", 0, false},
+	{"push from unlock", "OP_1", "", 0, true},
+	{"zero is false", "0x0000", "", 0, false},
+	{"equal", "0x0102", "0x0102 OP_EQUAL", 0, true},
+	{"not equal", "0x0102", "0x0103 OP_EQUAL", 0, false},
+	{"equalverify", "0x01", "0x01 OP_EQUALVERIFY", 0, false},
+	{"equalverify then true", "0x01", "0x01 OP_EQUALVERIFY OP_1", 0, true},
+	{"sha256", "0x736563726574", "OP_SHA256 0x" + secretHash + " OP_EQUAL", 0, true},
+	{"sha256 wrong preimage", "0x736563726575", "OP_SHA256 0x" + secretHash + " OP_EQUAL", 0, false},
+	{"sha512 size", "0x00", "OP_SHA512 OP_SIZE 64 OP_EQUAL OP_SWAP OP_DROP", 0, true},
+	{"checksig", "0x" + testSig, "0x" + testPub + " OP_CHECKSIG", 0, true},
+	{"checksig bad signature", "0x" + strings.Repeat("00", 64), "0x" + testPub + " OP_CHECKSIG", 0, false},
+	{"checksig bad key", "0x" + testSig, "0x00 OP_CHECKSIG", 0, false},
+	{"height reached", "", "10 OP_CHECKHEIGHTVERIFY OP_1", 10, true},
+	{"height not reached", "", "10 OP_CHECKHEIGHTVERIFY OP_1", 9, false},
+	{"height large", "", "100000 OP_CHECKHEIGHTVERIFY OP_1", 100000, true},
+	{"if", "OP_1", "OP_IF OP_1 OP_ELSE OP_0 OP_ENDIF", 0, true},
+	{"else", "OP_0", "OP_IF OP_1 OP_ELSE OP_0 OP_ENDIF", 0, false},
+	{"notif", "OP_0", "OP_NOTIF OP_1 OP_ELSE OP_0 OP_ENDIF", 0, true},
+	{"nested if", "OP_1 OP_0", "OP_IF OP_IF OP_0 OP_ELSE OP_1 OP_ENDIF OP_ENDIF", 0, true},
+	{"unbalanced if", "OP_1", "OP_IF OP_1", 0, false},
+	{"unbalanced endif", "", "OP_1 OP_ENDIF", 0, false},
+	{"return", "", "OP_1 OP_RETURN", 0, false},
+	{"return in skipped branch", "OP_0", "OP_IF OP_RETURN OP_ENDIF OP_1", 0, true},
+	{"booland", "OP_1 OP_0", "OP_BOOLAND", 0, false},
+	{"boolor", "OP_1 OP_0", "OP_BOOLOR", 0, true},
+	{"not", "OP_0", "OP_NOT", 0, true},
+	{"dup", "OP_1", "OP_DUP OP_DROP", 0, true},
+	{"pop empty stack", "", "OP_DROP OP_1", 0, false},
+	{"unlock not push only", "OP_1 OP_DUP", "", 0, false},
+}
+
+// Scripts the assembler can't write, given as raw bytes
+var rawVectors = []struct {
+	name  string
+	lock  []byte
+	valid bool
+}{
+	{"unknown opcode", []byte{0xff}, false},
+	{"truncated push", []byte{0x05, 0x01}, false},
+}
+
+func TestVectors(t *testing.T) {
+	for _, v := range vectors {
+		unlock, err := Assemble(v.unlock)
+		if err != nil {
+			t.Fatalf("%s: bad unlocking script: %s", v.name, err)
+		}
+		lock, err := Assemble(v.lock)
+		if err != nil {
+			t.Fatalf("%s: bad locking script: %s", v.name, err)
+		}
+
+		err = Execute(unlock, lock, Context{testHash, v.height})
+		if v.valid && err != nil {
+			t.Errorf("%s: expected valid, got %s", v.name, err)
+		}
+		if !v.valid && err == nil {
+			t.Errorf("%s: expected invalid", v.name)
+		}
+	}
+
+	for _, v := range rawVectors {
+		err := Execute(nil, v.lock, Context{testHash, 0})
+		if v.valid != (err == nil) {
+			t.Errorf("%s: expected valid to be %t, got %v", v.name, v.valid, err)
+		}
+	}
+}
+
+func TestHashTimeLock(t *testing.T) {
+	pub := testKey.Public().(ed25519.PublicKey)
+	hashLock, _ := hex.DecodeString(secretHash)
+	lock := HashTimeLock(pub, pub, hashLock, 50)
+
+	claim := AddData(nil, ed25519.Sign(testKey, testHash))
+	claim = AddData(claim, []byte("secret"))
+	claim = AddNumber(claim, 1)
+	if err := Execute(claim, lock, Context{testHash, 10}); err != nil {
+		t.Errorf("Claim failed: %s", err)
+	}
+
+	refund := AddData(nil, ed25519.Sign(testKey, testHash))
+	refund = AddNumber(refund, 0)
+	if err := Execute(refund, lock, Context{testHash, 49}); err == nil {
+		t.Errorf("Refund allowed before timeout")
+	}
+	if err := Execute(refund, lock, Context{testHash, 50}); err != nil {
+		t.Errorf("Refund failed: %s", err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	if _, err := parse(make([]byte, MaxScriptSize+1)); err != ErrScriptTooBig {
+		t.Errorf("Oversized script accepted")
+	}
+	if _, err := parse(AddData(nil, make([]byte, MaxPushSize+1))); err != ErrPushTooBig {
+		t.Errorf("Oversized push accepted")
+	}
+	if _, err := parse([]byte{0x05, 0x01}); err != ErrTruncated {
+		t.Errorf("Truncated push accepted")
+	}
+
+	ops := bytes.Repeat([]byte{OP_1, OP_DROP}, MaxOps+1)
+	if err := Execute(nil, append(ops, OP_1), Context{}); err != ErrTooManyOps {
+		t.Errorf("Too many ops accepted: %v", err)
+	}
+
+	pushes := bytes.Repeat([]byte{OP_1}, MaxStackSize+1)
+	if err := Execute(pushes, nil, Context{}); err != ErrStackSize {
+		t.Errorf("Stack overflow accepted: %v", err)
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	text := "OP_IF OP_SHA256 0x" + secretHash + " OP_EQUALVERIFY OP_ELSE 0xe803 OP_CHECKHEIGHTVERIFY OP_ENDIF OP_16"
+	script, err := Assemble(text)
+	if err != nil {
+		t.Fatalf("Couldn't assemble: %s", err)
+	}
+	disassembled, err := Disassemble(script)
+	if err != nil || disassembled != text {
+		t.Errorf("Round trip failed, got %q", disassembled)
+	}
+
+	numbers, _ := Assemble("0 5 1000")
+	if disassembled, _ = Disassemble(numbers); disassembled != "OP_0 OP_5 0xe803" {
+		t.Errorf("Numbers assembled wrong, got %q", disassembled)
+	}
+
+	if _, err = Assemble("OP_NOPE"); err == nil {
+		t.Errorf("Unknown word assembled")
+	}
+}
@@ -0,0 +1,23 @@
+package script
+
+// Locking script paying to the holder of an ed25519 key. Spent with an
+// unlocking script pushing a signature of the spending transaction.
+func PayToPubKey(pubKey []byte) []byte {
+	script := AddData(nil, pubKey)
+	return append(script, OP_CHECKSIG)
+}
+
+// Locking script equivalent to an HTLC. The recipient spends by pushing
+// their signature, the preimage of hashLock and OP_1. The refund key can
+// spend from timeout onwards by pushing its signature and OP_0.
+func HashTimeLock(recipient []byte, refund []byte, hashLock []byte, timeout uint32) []byte {
+	script := []byte{OP_IF, OP_SHA256}
+	script = AddData(script, hashLock)
+	script = append(script, OP_EQUALVERIFY)
+	script = AddData(script, recipient)
+	script = append(script, OP_CHECKSIG, OP_ELSE)
+	script = AddNumber(script, timeout)
+	script = append(script, OP_CHECKHEIGHTVERIFY)
+	script = AddData(script, refund)
+	return append(script, OP_CHECKSIG, OP_ENDIF)
+}
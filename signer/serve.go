@@ -0,0 +1,112 @@
+package signer
+
+import (
+	"encoding/hex"
+	"errors"
+	"github.com/frankh/arachnacoin/store"
+	"io"
+	"log"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// Signs with the keys in the database for a node, asking Confirm before
+// each signature
+type Service struct {
+	Confirm store.ConfirmFunc
+
+	lock sync.Mutex // Confirms one request at a time, as they share a terminal
+}
+
+type AddressesArgs struct{}
+
+func (s *Service) Sign(request *store.SignRequest, reply *string) error {
+	key := store.FetchWalletByAddress(request.Address)
+	if key == nil || key.WatchOnly {
+		return errors.New("no key for " + request.Address)
+	}
+	local := store.LocalSigner{Key: key, Confirm: s.Confirm}
+	s.lock.Lock()
+	signature, err := local.Sign(*request)
+	s.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	*reply = hex.EncodeToString(signature)
+	return nil
+}
+
+func (s *Service) Addresses(args *AddressesArgs, reply *[]string) error {
+	*reply = []string{}
+	for _, name := range store.WalletNames() {
+		for _, key := range store.FetchWallets(name) {
+			if !key.WatchOnly {
+				*reply = append(*reply, key.Address())
+			}
+		}
+	}
+	return nil
+}
+
+// Serves signing requests on a connection until it closes
+func (s *Service) ServeConn(conn io.ReadWriteCloser) {
+	server := rpc.NewServer()
+	server.RegisterName("Signer", s)
+	server.ServeCodec(jsonrpc.NewServerCodec(conn))
+}
+
+// Listens on a unix socket only its owner can connect to
+func ListenUnix(path string) (net.Listener, error) {
+	// Create the socket without permissions for anyone else, so there's no
+	// moment another user can connect before it's chmodded
+	mask := syscall.Umask(0177)
+	ln, err := net.Listen("unix", path)
+	syscall.Umask(mask)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chmod(path, 0600)
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// Serves signing requests on a listener until it fails
+func (s *Service) Serve(ln net.Listener) error {
+	defer ln.Close()
+	log.Printf("Listening for signing requests on %s", ln.Addr())
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// Serves signing requests on stdin and stdout, for a node that started
+// this process
+func (s *Service) ServeStdio() {
+	s.ServeConn(&stdio{})
+}
+
+type stdio struct{}
+
+func (stdio) Read(b []byte) (int, error) {
+	return os.Stdin.Read(b)
+}
+
+func (stdio) Write(b []byte) (int, error) {
+	return os.Stdout.Write(b)
+}
+
+func (stdio) Close() error {
+	return os.Stdin.Close()
+}
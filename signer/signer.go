@@ -0,0 +1,122 @@
+package signer
+
+import (
+	"encoding/hex"
+	"errors"
+	"github.com/frankh/arachnacoin/store"
+	"golang.org/x/crypto/ed25519"
+	"io"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// A signer process holds private keys away from the node, standing in for
+// a hardware wallet or HSM. The node asks it to sign over JSON-RPC, either
+// on a unix socket or on the stdin and stdout of a command it starts.
+// There are two methods: Signer.Sign, taking a store.SignRequest and
+// replying with a hex signature, and Signer.Addresses, replying with the
+// addresses of the keys it holds.
+
+// Signs by asking a signer process
+type Remote struct {
+	target string
+	client *rpc.Client
+	lock   sync.Mutex
+}
+
+// Returns a signer for target, either "unix:<socket>" or a command to run.
+// Nothing is connected until it's first used.
+func NewRemote(target string) *Remote {
+	return &Remote{target: target}
+}
+
+func (r *Remote) Sign(request store.SignRequest) ([]byte, error) {
+	var signature string
+	err := r.call("Signer.Sign", &request, &signature)
+	if err != nil {
+		return nil, err
+	}
+	return decodeSignature(signature)
+}
+
+func (r *Remote) Addresses() ([]string, error) {
+	var addresses []string
+	err := r.call("Signer.Addresses", &AddressesArgs{}, &addresses)
+	return addresses, err
+}
+
+func (r *Remote) call(method string, args interface{}, reply interface{}) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.client == nil {
+		conn, err := r.connect()
+		if err != nil {
+			return errors.New("couldn't reach signer: " + err.Error())
+		}
+		r.client = jsonrpc.NewClient(conn)
+	}
+
+	err := r.client.Call(method, args, reply)
+	if _, ok := err.(rpc.ServerError); err != nil && !ok {
+		// Reconnect next time, the signer may have restarted
+		r.client.Close()
+		r.client = nil
+	}
+	return err
+}
+
+func (r *Remote) connect() (io.ReadWriteCloser, error) {
+	if strings.HasPrefix(r.target, "unix:") {
+		return net.Dial("unix", strings.TrimPrefix(r.target, "unix:"))
+	}
+
+	args := strings.Fields(r.target)
+	if len(args) == 0 {
+		return nil, errors.New("no signer command")
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	return &pipe{stdout, stdin, cmd}, nil
+}
+
+// Joins a command's stdout and stdin into one connection
+type pipe struct {
+	io.ReadCloser
+	in  io.WriteCloser
+	cmd *exec.Cmd
+}
+
+func (p *pipe) Write(b []byte) (int, error) {
+	return p.in.Write(b)
+}
+
+func (p *pipe) Close() error {
+	p.in.Close()
+	p.ReadCloser.Close()
+	return p.cmd.Wait()
+}
+
+func decodeSignature(signature string) ([]byte, error) {
+	decoded, err := hex.DecodeString(signature)
+	if err != nil || len(decoded) != ed25519.SignatureSize {
+		return nil, errors.New("signer returned an invalid signature")
+	}
+	return decoded, nil
+}
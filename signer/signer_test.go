@@ -0,0 +1,66 @@
+package signer
+
+import (
+	"github.com/frankh/arachnacoin/store"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRemoteSigner(t *testing.T) {
+	store.Init(":memory:")
+	key := *store.MyWallet
+	// The node only knows the public key
+	watched := store.Wallet{PublicKey: key.PublicKey, WatchOnly: true}
+
+	if _, err := watched.Send(key.Address(), 1, nil); err != store.ErrWatchOnly {
+		t.Errorf("Signed without a signer: %v", err)
+	}
+
+	dir, _ := ioutil.TempDir("", "signer")
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "signer.sock")
+	var requests []store.SignRequest
+	approve := true
+	service := Service{Confirm: func(request store.SignRequest) bool {
+		requests = append(requests, request)
+		return approve
+	}}
+	ln, err := ListenUnix(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go service.Serve(ln)
+	if info, err := os.Stat(socket); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Socket can be used by other users: %v %v", info.Mode(), err)
+	}
+
+	remote := NewRemote("unix:" + socket)
+	store.ExternalSigner = remote
+	defer func() { store.ExternalSigner = nil }()
+
+	addresses, err := remote.Addresses()
+	if err != nil || len(addresses) != 1 || addresses[0] != key.Address() {
+		t.Errorf("Unexpected signer addresses %v %v", addresses, err)
+	}
+
+	payment, err := watched.Send(key.Address(), 1, []byte("rent"))
+	if err != nil || !store.VerifySignature(payment) {
+		t.Fatalf("Remote signing failed: %v", err)
+	}
+	if len(requests) != 1 || requests[0].Transaction == nil || requests[0].Transaction.Data != payment.Data {
+		t.Errorf("Signer wasn't shown the transaction")
+	}
+
+	approve = false
+	if _, err := watched.Send(key.Address(), 1, nil); err == nil {
+		t.Errorf("Signed a refused request")
+	}
+
+	stranger := store.GenerateWallet()
+	stranger.WatchOnly = true
+	if _, err := stranger.Send(key.Address(), 1, nil); err == nil {
+		t.Errorf("Signed for a key the signer doesn't hold")
+	}
+}
@@ -0,0 +1,131 @@
+package store
+
+import (
+	"time"
+)
+
+// The address book holds host:port addresses of peers, learnt from
+// connecting to them and from other peers, so a node can find peers again
+// after restarting. Times are unix seconds.
+
+// Most addresses kept. Beyond this the least recently seen are dropped,
+// keeping ones we've connected to over ones we've only been told of.
+const MaxAddresses = 5000
+
+// Failed connections in a row after which an address that hasn't
+// connected for a week is dropped
+const MaxAddressFailures = 10
+
+const week = 7 * 24 * 60 * 60
+
+type PeerAddress struct {
+	Address     string `json:"address"`
+	LastSeen    int64  `json:"last_seen"`    // When it was last known to be up
+	LastTry     int64  `json:"last_try"`     // When we last tried connecting to it
+	LastSuccess int64  `json:"last_success"` // When we last connected to it
+	Successes   int    `json:"successes"`
+	Failures    int    `json:"failures"` // Failed connections since it last connected
+}
+
+// Adds an address to the book, or updates when it was last seen. Returns
+// whether it was new or seen more recently than the book had it.
+func AddAddress(address string, seen int64) bool {
+	result, err := Conn.Exec(`INSERT OR IGNORE INTO arach_peer_address (address, last_seen) values (?, ?)`, address, seen)
+	if err != nil {
+		panic(err)
+	}
+	added, err := result.RowsAffected()
+	if err != nil {
+		panic(err)
+	}
+	result, err = Conn.Exec(`UPDATE arach_peer_address SET last_seen=? WHERE address=? AND last_seen < ?`, seen, address, seen)
+	if err != nil {
+		panic(err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		panic(err)
+	}
+	_, err = Conn.Exec(`DELETE FROM arach_peer_address WHERE address IN (
+		SELECT address FROM arach_peer_address ORDER BY last_success > 0 DESC, last_seen DESC LIMIT -1 OFFSET ?)`, MaxAddresses)
+	if err != nil {
+		panic(err)
+	}
+	return added > 0 || updated > 0
+}
+
+// Records that we're trying to connect to an address
+func MarkAttempt(address string) {
+	AddAddress(address, 0)
+	_, err := Conn.Exec(`UPDATE arach_peer_address SET last_try=? WHERE address=?`, time.Now().Unix(), address)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Records a successful connection to an address
+func MarkConnected(address string) {
+	now := time.Now().Unix()
+	AddAddress(address, now)
+	_, err := Conn.Exec(`UPDATE arach_peer_address SET last_success=?, successes=successes+1, failures=0 WHERE address=?`,
+		now, address)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Records a failed connection to an address, dropping it if it keeps failing
+func MarkFailed(address string) {
+	_, err := Conn.Exec(`UPDATE arach_peer_address SET failures=failures+1 WHERE address=?`, address)
+	if err != nil {
+		panic(err)
+	}
+	_, err = Conn.Exec(`DELETE FROM arach_peer_address WHERE address=? AND failures >= ? AND last_success < ?`,
+		address, MaxAddressFailures, time.Now().Unix()-week)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Returns an address's entry in the book, or nil if it isn't in it
+func FetchAddress(address string) *PeerAddress {
+	addresses := queryAddresses(`SELECT address, last_seen, last_try, last_success, successes, failures
+		FROM arach_peer_address WHERE address=?`, address)
+	if len(addresses) == 0 {
+		return nil
+	}
+	return &addresses[0]
+}
+
+// Returns every address in the book, the most recently seen first
+func FetchAddresses() []PeerAddress {
+	return queryAddresses(`SELECT address, last_seen, last_try, last_success, successes, failures
+		FROM arach_peer_address ORDER BY last_seen DESC, address`)
+}
+
+// Returns up to limit random addresses seen in the last week that are
+// working, to tell other peers about
+func ShareableAddresses(limit int) []PeerAddress {
+	return queryAddresses(`SELECT address, last_seen, last_try, last_success, successes, failures
+		FROM arach_peer_address WHERE last_seen > ? AND failures < 3 ORDER BY random() LIMIT ?`,
+		time.Now().Unix()-week, limit)
+}
+
+func queryAddresses(query string, args ...interface{}) []PeerAddress {
+	rows, err := Conn.Query(query, args...)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	addresses := make([]PeerAddress, 0)
+	for rows.Next() {
+		var a PeerAddress
+		err = rows.Scan(&a.Address, &a.LastSeen, &a.LastTry, &a.LastSuccess, &a.Successes, &a.Failures)
+		if err != nil {
+			panic(err)
+		}
+		addresses = append(addresses, a)
+	}
+	return addresses
+}
@@ -0,0 +1,61 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestAddressBook(t *testing.T) {
+	Init(":memory:")
+	if !AddAddress("10.0.0.1:31042", 100) || AddAddress("10.0.0.1:31042", 50) || !AddAddress("10.0.0.2:31042", 200) {
+		t.Errorf("Wrong addresses reported as new or updated")
+	}
+
+	a := FetchAddress("10.0.0.1:31042")
+	if a == nil || a.LastSeen != 100 {
+		t.Fatalf("Older sighting replaced last seen: %+v", a)
+	}
+	if all := FetchAddresses(); len(all) != 2 || all[0].Address != "10.0.0.2:31042" {
+		t.Errorf("Expected most recently seen first: %+v", all)
+	}
+
+	MarkAttempt("10.0.0.1:31042")
+	MarkFailed("10.0.0.1:31042")
+	MarkFailed("10.0.0.1:31042")
+	a = FetchAddress("10.0.0.1:31042")
+	if a.Failures != 2 || a.LastTry == 0 || a.Successes != 0 {
+		t.Errorf("Failures not recorded: %+v", a)
+	}
+	MarkConnected("10.0.0.1:31042")
+	a = FetchAddress("10.0.0.1:31042")
+	if a.Failures != 0 || a.Successes != 1 || a.LastSeen < time.Now().Unix()-1 {
+		t.Errorf("Success not recorded: %+v", a)
+	}
+	if len(ShareableAddresses(10)) != 1 {
+		t.Errorf("Expected only the recently seen address to be shared")
+	}
+
+	// Addresses that never connect are dropped
+	for i := 0; i < MaxAddressFailures; i++ {
+		MarkFailed("10.0.0.2:31042")
+	}
+	if FetchAddress("10.0.0.2:31042") != nil {
+		t.Errorf("Failing address wasn't dropped")
+	}
+}
+
+func TestAddressBookFull(t *testing.T) {
+	Init(":memory:")
+	MarkConnected("10.0.0.1:31042")
+
+	// Addresses we've only been told of, however recent, don't push out ones
+	// we've connected to
+	seen := time.Now().Unix() + 1
+	for i := 0; i < MaxAddresses; i++ {
+		AddAddress(fmt.Sprintf("10.1.%d.%d:31042", i/256, i%256), seen)
+	}
+	if len(FetchAddresses()) != MaxAddresses || FetchAddress("10.0.0.1:31042") == nil {
+		t.Errorf("Connected address dropped for ones only heard of")
+	}
+}
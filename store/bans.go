@@ -0,0 +1,77 @@
+package store
+
+import (
+	"net"
+	"time"
+)
+
+// Peers that misbehave are banned for a time, see node.BanPeer. A ban is
+// on a host:port, or on a whole ip, so nodes sharing a machine can be
+// banned apart.
+
+type BannedPeer struct {
+	Address string `json:"address"`
+	Until   int64  `json:"until"` // Unix time the ban ends
+	Reason  string `json:"reason"`
+}
+
+// Bans an address until a time, replacing any ban it already has
+func Ban(address string, until time.Time, reason string) {
+	_, err := Conn.Exec(`INSERT OR REPLACE INTO arach_ban (address, until, reason) values (?, ?, ?)`,
+		address, until.Unix(), reason)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Lifts an address's ban, returning whether it was banned
+func Unban(address string) bool {
+	result, err := Conn.Exec(`DELETE FROM arach_ban WHERE address=?`, address)
+	if err != nil {
+		panic(err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		panic(err)
+	}
+	return n > 0
+}
+
+// Returns whether a host:port is banned, by itself or by its ip
+func IsBanned(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	var banned bool
+	err = Conn.QueryRow(`SELECT count(*) > 0 FROM arach_ban WHERE address IN (?, ?) AND until > ?`,
+		address, host, time.Now().Unix()).Scan(&banned)
+	if err != nil {
+		panic(err)
+	}
+	return banned
+}
+
+// Returns the current bans, dropping any that have ended
+func FetchBans() []BannedPeer {
+	_, err := Conn.Exec(`DELETE FROM arach_ban WHERE until <= ?`, time.Now().Unix())
+	if err != nil {
+		panic(err)
+	}
+	rows, err := Conn.Query(`SELECT address, until, reason FROM arach_ban ORDER BY until, address`)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	bans := make([]BannedPeer, 0)
+	for rows.Next() {
+		var b BannedPeer
+		err = rows.Scan(&b.Address, &b.Until, &b.Reason)
+		if err != nil {
+			panic(err)
+		}
+		bans = append(bans, b)
+	}
+	return bans
+}
@@ -0,0 +1,22 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBans(t *testing.T) {
+	Init(":memory:")
+	Ban("10.0.0.1", time.Now().Add(time.Hour), "bad block")
+	Ban("10.0.0.2", time.Now().Add(-time.Second), "over")
+	if !IsBanned("10.0.0.1") || IsBanned("10.0.0.2") || IsBanned("10.0.0.3") {
+		t.Errorf("Wrong ips banned")
+	}
+	bans := FetchBans()
+	if len(bans) != 1 || bans[0].Address != "10.0.0.1" || bans[0].Reason != "bad block" {
+		t.Errorf("Expected only the current ban: %+v", bans)
+	}
+	if !Unban("10.0.0.1") || Unban("10.0.0.1") || IsBanned("10.0.0.1") {
+		t.Errorf("Ban wasn't lifted once")
+	}
+}
@@ -0,0 +1,61 @@
+package store
+
+import (
+	"github.com/frankh/arachnacoin/block"
+	"github.com/frankh/arachnacoin/transaction"
+)
+
+// A transaction on the longest chain along with the block containing it
+type TransactionInBlock struct {
+	Transaction transaction.Transaction
+	Block       block.Block
+}
+
+// Finds the transactions on the longest chain carrying the hex encoded
+// data, oldest first.
+func FetchTransactionsWithData(data string) []TransactionInBlock {
+	if Conn == nil {
+		panic("Database connection not initialised")
+	}
+
+	results := make([]TransactionInBlock, 0)
+	if data == "" {
+		return results
+	}
+
+	rows, err := Conn.Query(`SELECT DISTINCT block
+    FROM 'arach_transaction' WHERE data=? ORDER BY block_height asc`, data)
+	if err != nil {
+		panic(err)
+	}
+
+	blockHashes := make([]string, 0)
+	for rows.Next() {
+		var hash string
+		err = rows.Scan(&hash)
+		if err != nil {
+			panic(err)
+		}
+		blockHashes = append(blockHashes, hash)
+	}
+	rows.Close()
+
+	head := FetchHighestBlock()
+	longestChain := make(map[string]bool)
+	for _, hash := range GetBlockHashChain(&head) {
+		longestChain[hash] = true
+	}
+
+	for _, hash := range blockHashes {
+		if !longestChain[hash] {
+			continue
+		}
+		b := FetchBlock(hash)
+		for _, t := range b.Transactions {
+			if t.Data == data {
+				results = append(results, TransactionInBlock{t, *b})
+			}
+		}
+	}
+	return results
+}
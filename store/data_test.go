@@ -0,0 +1,44 @@
+package store
+
+import (
+	"encoding/hex"
+	"github.com/frankh/arachnacoin/transaction"
+	"github.com/frankh/arachnacoin/work"
+	"strings"
+	"testing"
+)
+
+func TestTransactionData(t *testing.T) {
+	work.Difficulty = 0xff000000
+	alice := GenerateWallet()
+	Init(":memory:")
+	chain := Conn
+	mineOn(chain, nil, alice.Address())
+
+	memo := []byte("invoice 1234")
+	bob := GenerateWallet()
+	payment, _ := alice.Send(bob.Address(), 10, memo)
+	if !mineOn(chain, []transaction.Transaction{payment}, alice.Address()) {
+		t.Fatalf("Failed to mine payment with data")
+	}
+
+	found := FetchTransactionsWithData(hex.EncodeToString(memo))
+	if len(found) != 1 || found[0].Transaction.HashString() != payment.HashString() {
+		t.Fatalf("Payment not found by its data")
+	}
+	if found[0].Block.Height != 2 {
+		t.Errorf("Payment found in wrong block")
+	}
+
+	// Data is committed to by the signature
+	tampered := payment
+	tampered.Data = hex.EncodeToString([]byte("invoice 9999"))
+	if mineOn(chain, []transaction.Transaction{tampered}, alice.Address()) {
+		t.Errorf("Tampered data accepted")
+	}
+
+	tooBig, _ := alice.Send(bob.Address(), 10, []byte(strings.Repeat("x", transaction.MaxDataSize+1)))
+	if mineOn(chain, []transaction.Transaction{tooBig}, alice.Address()) {
+		t.Errorf("Oversized data accepted")
+	}
+}
@@ -0,0 +1,297 @@
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"golang.org/x/crypto/scrypt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Wallet encryption, covering every wallet on the node with one
+// passphrase. Secrets in the wallet tables, the private keys, seeds and
+// mnemonics, are sealed with AES-256-GCM under a random master key. The
+// master key is sealed under a key stretched from the passphrase with
+// scrypt, so changing the passphrase only reseals the master key. The
+// master key is only kept in memory while the wallet is unlocked.
+
+// scrypt cost parameters for new passphrases. The ones used are stored
+// alongside the sealed master key.
+const scryptN = 1 << 15
+const scryptR = 8
+const scryptP = 1
+
+var ErrWalletLocked = errors.New("wallet is locked, unlock it first")
+var ErrWrongPassphrase = errors.New("wrong passphrase")
+
+var masterKey []byte
+var lockTimer *time.Timer
+var masterKeyLock sync.Mutex
+
+func IsEncrypted() bool {
+	var count int
+	err := Conn.QueryRow(`SELECT count(*) FROM arach_encryption`).Scan(&count)
+	if err != nil {
+		panic(err)
+	}
+	return count > 0
+}
+
+func IsLocked() bool {
+	masterKeyLock.Lock()
+	defer masterKeyLock.Unlock()
+	return masterKey == nil && IsEncrypted()
+}
+
+// Encrypts the wallet's secrets with a passphrase, leaving it locked
+func EncryptWallet(passphrase string) error {
+	if IsEncrypted() {
+		return errors.New("wallet is already encrypted")
+	}
+	if passphrase == "" {
+		return errors.New("passphrase can't be empty")
+	}
+
+	master := randomBytes(32)
+	salt := randomBytes(16)
+	sealedMaster := seal(passphraseKey(passphrase, salt, scryptN, scryptR, scryptP), master, []byte("master"))
+
+	// Read everything before starting the transaction, which holds the
+	// only connection
+	wallets := fetchKeys("")
+	type seedRow struct {
+		wallet, seed, mnemonic string
+	}
+	var seeds []seedRow
+	rows, err := Conn.Query(`SELECT wallet, seed, mnemonic FROM arach_seed`)
+	if err != nil {
+		panic(err)
+	}
+	for rows.Next() {
+		var row seedRow
+		err = rows.Scan(&row.wallet, &row.seed, &row.mnemonic)
+		if err != nil {
+			panic(err)
+		}
+		seeds = append(seeds, row)
+	}
+	rows.Close()
+
+	tx, err := Conn.Begin()
+	if err != nil {
+		panic(err)
+	}
+	for _, w := range wallets {
+		if w.WatchOnly {
+			continue
+		}
+		sealed := seal(master, w.PrivateKey, keyLabel(w))
+		_, err = tx.Exec(`UPDATE arach_wallet SET private_key=? WHERE public_key=?`,
+			hex.EncodeToString(sealed), hex.EncodeToString(w.PublicKey))
+		if err != nil {
+			panic(err)
+		}
+	}
+	for _, row := range seeds {
+		seed, err := hex.DecodeString(row.seed)
+		if err != nil {
+			panic("Invalid seed")
+		}
+		sealedMnemonic := ""
+		if row.mnemonic != "" {
+			sealedMnemonic = hex.EncodeToString(seal(master, []byte(row.mnemonic), []byte("mnemonic")))
+		}
+		_, err = tx.Exec(`UPDATE arach_seed SET seed=?, mnemonic=? WHERE wallet=?`,
+			hex.EncodeToString(seal(master, seed, []byte("seed"))), sealedMnemonic, row.wallet)
+		if err != nil {
+			panic(err)
+		}
+	}
+	_, err = tx.Exec(`INSERT INTO arach_encryption (salt, master_key, scrypt_n, scrypt_r, scrypt_p) values (?, ?, ?, ?, ?)`,
+		hex.EncodeToString(salt), hex.EncodeToString(sealedMaster), scryptN, scryptR, scryptP)
+	if err != nil {
+		panic(err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		panic(err)
+	}
+
+	// Don't leave the plaintext behind in free pages
+	_, err = Conn.Exec(`VACUUM`)
+	if err != nil {
+		panic(err)
+	}
+
+	loadMyWallet()
+	return nil
+}
+
+// Unlocks the wallet for signing until timeout has passed or Lock is
+// called
+func Unlock(passphrase string, timeout time.Duration) error {
+	if !IsEncrypted() {
+		return errors.New("wallet isn't encrypted")
+	}
+	master, err := openMasterKey(passphrase)
+	if err != nil {
+		return err
+	}
+
+	masterKeyLock.Lock()
+	defer masterKeyLock.Unlock()
+	masterKey = master
+	if lockTimer != nil {
+		lockTimer.Stop()
+	}
+	lockTimer = time.AfterFunc(timeout, Lock)
+	log.Printf("Wallet unlocked for %s", timeout)
+	return nil
+}
+
+func Lock() {
+	masterKeyLock.Lock()
+	defer masterKeyLock.Unlock()
+	if masterKey == nil {
+		return
+	}
+	for i := range masterKey {
+		masterKey[i] = 0
+	}
+	masterKey = nil
+	if lockTimer != nil {
+		lockTimer.Stop()
+		lockTimer = nil
+	}
+	log.Printf("Wallet locked")
+}
+
+func ChangePassphrase(oldPassphrase string, newPassphrase string) error {
+	if newPassphrase == "" {
+		return errors.New("passphrase can't be empty")
+	}
+	master, err := openMasterKey(oldPassphrase)
+	if err != nil {
+		return err
+	}
+
+	salt := randomBytes(16)
+	sealedMaster := seal(passphraseKey(newPassphrase, salt, scryptN, scryptR, scryptP), master, []byte("master"))
+	_, err = Conn.Exec(`UPDATE arach_encryption SET salt=?, master_key=?, scrypt_n=?, scrypt_r=?, scrypt_p=?`,
+		hex.EncodeToString(salt), hex.EncodeToString(sealedMaster), scryptN, scryptR, scryptP)
+	if err != nil {
+		panic(err)
+	}
+	return nil
+}
+
+func openMasterKey(passphrase string) ([]byte, error) {
+	var saltString, sealedString string
+	var n, r, p int
+	err := Conn.QueryRow(`SELECT salt, master_key, scrypt_n, scrypt_r, scrypt_p FROM arach_encryption`).Scan(
+		&saltString, &sealedString, &n, &r, &p)
+	if err != nil {
+		return nil, errors.New("wallet isn't encrypted")
+	}
+	salt, _ := hex.DecodeString(saltString)
+	sealed, _ := hex.DecodeString(sealedString)
+
+	master, err := open(passphraseKey(passphrase, salt, n, r, p), sealed, []byte("master"))
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return master, nil
+}
+
+// Encodes a wallet secret for storage, sealing it if the wallet is
+// encrypted. label ties the secret to where it's stored.
+func sealSecret(secret []byte, label []byte) (string, error) {
+	if !IsEncrypted() {
+		return hex.EncodeToString(secret), nil
+	}
+	masterKeyLock.Lock()
+	defer masterKeyLock.Unlock()
+	if masterKey == nil {
+		return "", ErrWalletLocked
+	}
+	return hex.EncodeToString(seal(masterKey, secret, label)), nil
+}
+
+// Decodes a wallet secret stored by sealSecret
+func openSecret(stored string, label []byte) ([]byte, error) {
+	secret, err := hex.DecodeString(stored)
+	if err != nil {
+		return nil, errors.New("invalid wallet secret")
+	}
+	if !IsEncrypted() {
+		return secret, nil
+	}
+	return openSealed(secret, label)
+}
+
+func openSealed(sealed []byte, label []byte) ([]byte, error) {
+	masterKeyLock.Lock()
+	defer masterKeyLock.Unlock()
+	if masterKey == nil {
+		return nil, ErrWalletLocked
+	}
+	secret, err := open(masterKey, sealed, label)
+	if err != nil {
+		return nil, errors.New("couldn't decrypt wallet secret")
+	}
+	return secret, nil
+}
+
+func passphraseKey(passphrase string, salt []byte, n int, r int, p int) []byte {
+	key, err := scrypt.Key([]byte(passphrase), salt, n, r, p, 32)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// Encrypts and authenticates plaintext along with label, prefixing the
+// random nonce
+func seal(key []byte, plaintext []byte, label []byte) []byte {
+	gcm := newGCM(key)
+	nonce := randomBytes(gcm.NonceSize())
+	return gcm.Seal(nonce, nonce, plaintext, label)
+}
+
+func open(key []byte, sealed []byte, label []byte) ([]byte, error) {
+	gcm := newGCM(key)
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("sealed data too short")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], label)
+}
+
+func newGCM(key []byte) cipher.AEAD {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+	return gcm
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic("Couldn't generate random bytes")
+	}
+	return b
+}
+
+// Ties a sealed private key to its public key
+func keyLabel(w Wallet) []byte {
+	return append([]byte("key:"), w.PublicKey...)
+}
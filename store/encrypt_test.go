@@ -0,0 +1,83 @@
+package store
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+func TestEncryptWallet(t *testing.T) {
+	Init(":memory:")
+	defer Lock()
+	w := *MyWallet
+	mnemonic, _ := FetchMnemonic(DefaultWalletName)
+
+	err := EncryptWallet("correct horse")
+	if err != nil {
+		t.Fatalf("Encrypt failed: %s", err)
+	}
+	if EncryptWallet("correct horse") == nil {
+		t.Errorf("Encrypted twice")
+	}
+
+	// Nothing secret is left in plain text
+	var stored string
+	Conn.QueryRow(`SELECT private_key FROM arach_wallet`).Scan(&stored)
+	if stored == hex.EncodeToString(w.PrivateKey) || MyWallet.PrivateKey != nil {
+		t.Errorf("Private key not encrypted")
+	}
+	Conn.QueryRow(`SELECT mnemonic FROM arach_seed`).Scan(&stored)
+	if stored == mnemonic {
+		t.Errorf("Mnemonic not encrypted")
+	}
+
+	// Signing and deriving need the wallet unlocked
+	if !IsLocked() {
+		t.Fatalf("Wallet not locked after encrypting")
+	}
+	if _, err := MyWallet.Send(w.Address(), 1, nil); err != ErrWalletLocked {
+		t.Errorf("Signed while locked: %v", err)
+	}
+	if _, err := NewAddress(DefaultWalletName, 0); err != ErrWalletLocked {
+		t.Errorf("Derived while locked: %v", err)
+	}
+	if _, err := FetchMnemonic(DefaultWalletName); err != ErrWalletLocked {
+		t.Errorf("Read mnemonic while locked: %v", err)
+	}
+
+	if Unlock("wrong horse", time.Minute) != ErrWrongPassphrase {
+		t.Errorf("Unlocked with the wrong passphrase")
+	}
+	if err := Unlock("correct horse", time.Minute); err != nil {
+		t.Fatalf("Unlock failed: %s", err)
+	}
+	payment, err := MyWallet.Send(w.Address(), 1, nil)
+	if err != nil || !VerifySignature(payment) {
+		t.Errorf("Signing failed once unlocked: %v", err)
+	}
+	if _, err := NewAddress(DefaultWalletName, 0); err != nil {
+		t.Errorf("Deriving failed once unlocked: %s", err)
+	}
+	if unlocked, _ := FetchMnemonic(DefaultWalletName); unlocked != mnemonic {
+		t.Errorf("Mnemonic changed by encryption")
+	}
+
+	// New passphrases replace the old one, and unlocking times out
+	if ChangePassphrase("wrong horse", "battery staple") != ErrWrongPassphrase {
+		t.Errorf("Changed passphrase without the old one")
+	}
+	if err := ChangePassphrase("correct horse", "battery staple"); err != nil {
+		t.Fatalf("Changing passphrase failed: %s", err)
+	}
+	Lock()
+	if Unlock("correct horse", time.Minute) == nil {
+		t.Errorf("Old passphrase still works")
+	}
+	if err := Unlock("battery staple", 10*time.Millisecond); err != nil {
+		t.Fatalf("New passphrase doesn't work: %s", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, err := MyWallet.Send(w.Address(), 1, nil); err != ErrWalletLocked {
+		t.Errorf("Wallet didn't lock after its timeout")
+	}
+}
@@ -0,0 +1,229 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"github.com/frankh/arachnacoin/crypto"
+	"golang.org/x/crypto/ed25519"
+	"log"
+)
+
+// The keys of each named wallet are derived from its own seed along
+// crypto.WalletPath, so every address can be restored from the seed alone.
+// New seeds come from a mnemonic phrase, which is the backup people write
+// down. Keys stored before wallets had seeds have an empty path and can't
+// be restored this way.
+
+// How many unused addresses in a row a scan looks past before deciding an
+// account has no more
+const GapLimit = 20
+
+// Returns a wallet's seed, or nil if it doesn't have one. Fails while the
+// wallet is locked.
+func FetchSeed(name string) ([]byte, error) {
+	var seed string
+	err := Conn.QueryRow(`SELECT seed FROM arach_seed WHERE wallet=?`, name).Scan(&seed)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		panic(err)
+	}
+	return openSecret(seed, []byte("seed"))
+}
+
+// Returns the phrase a wallet's seed came from, or an empty string if it
+// didn't come from one. Fails while the wallet is locked.
+func FetchMnemonic(name string) (string, error) {
+	var mnemonic string
+	err := Conn.QueryRow(`SELECT mnemonic FROM arach_seed WHERE wallet=?`, name).Scan(&mnemonic)
+	if err == sql.ErrNoRows || mnemonic == "" {
+		return "", nil
+	}
+	if err != nil {
+		panic(err)
+	}
+	// Phrases are kept as they are unless encrypted
+	if !IsEncrypted() {
+		return mnemonic, nil
+	}
+	plain, err := openSecret(mnemonic, []byte("mnemonic"))
+	return string(plain), err
+}
+
+func hasSeed(name string) bool {
+	var count int
+	err := Conn.QueryRow(`SELECT count(*) FROM arach_seed WHERE wallet=?`, name).Scan(&count)
+	if err != nil {
+		panic(err)
+	}
+	return count > 0
+}
+
+// Stores a wallet's seed, along with the phrase it came from if any
+func StoreSeed(name string, seed []byte, mnemonic string) error {
+	sealedSeed, err := sealSecret(seed, []byte("seed"))
+	if err != nil {
+		return err
+	}
+	sealedMnemonic := mnemonic
+	if mnemonic != "" && IsEncrypted() {
+		sealedMnemonic, err = sealSecret([]byte(mnemonic), []byte("mnemonic"))
+		if err != nil {
+			return err
+		}
+	}
+	_, err = Conn.Exec(`INSERT INTO arach_seed (wallet, seed, mnemonic) values (?, ?, ?)`, name, sealedSeed, sealedMnemonic)
+	if err != nil {
+		panic(err)
+	}
+	return nil
+}
+
+// Returns a wallet's seed, first generating one for wallets from before
+// seeds existed. Fails for watch-only wallets, which have no keys to derive.
+func fetchOrCreateSeed(name string) ([]byte, error) {
+	if hasSeed(name) {
+		return FetchSeed(name)
+	}
+	if IsWatchOnly(name) {
+		return nil, errors.New("wallet " + name + " is watch-only")
+	}
+	log.Printf("Generating seed for wallet %s...", name)
+	mnemonic := crypto.GenerateMnemonic()
+	seed, _ := crypto.MnemonicToSeed(mnemonic, "")
+	return seed, StoreSeed(name, seed, mnemonic)
+}
+
+// Derives the wallet key for an account and address index
+func DeriveWallet(seed []byte, account uint32, index uint32) Wallet {
+	path := crypto.WalletPath(account, index)
+	key, err := crypto.DeriveKey(seed, path)
+	if err != nil {
+		panic(err)
+	}
+	priv := ed25519.NewKeyFromSeed(key.Key)
+	return Wallet{
+		PublicKey:  priv.Public().(ed25519.PublicKey),
+		PrivateKey: priv,
+		Path:       path,
+	}
+}
+
+// Returns the account and address index of a derived key
+func (w *Wallet) PathIndexes() (account uint32, index uint32, ok bool) {
+	if w.Path == "" {
+		return 0, 0, false
+	}
+	indexes, err := crypto.ParsePath(w.Path)
+	if err != nil || len(indexes) != 5 {
+		return 0, 0, false
+	}
+	return indexes[2] - crypto.HardenedOffset, indexes[4] - crypto.HardenedOffset, true
+}
+
+// Returns the key for an address from any of the wallets, or nil if it
+// isn't one of ours
+func FetchWalletByAddress(address string) *Wallet {
+	version, payload, err := crypto.DecodeAddress(address)
+	if err != nil || version != crypto.PubKeyAddress {
+		return nil
+	}
+	wallets := fetchKeys(`WHERE public_key=?`, hex.EncodeToString(payload))
+	if len(wallets) == 0 {
+		return nil
+	}
+	return &wallets[0]
+}
+
+// Derives and stores the next unused address index in an account of a
+// wallet. Fails while the wallet is locked.
+func NewAddress(name string, account uint32) (Wallet, error) {
+	wallets := FetchWallets(name)
+	if len(wallets) == 0 {
+		return Wallet{}, errors.New("no wallet named " + name)
+	}
+	next := uint32(0)
+	for _, w := range wallets {
+		a, index, ok := w.PathIndexes()
+		if ok && a == account && index >= next {
+			next = index + 1
+		}
+	}
+	seed, err := fetchOrCreateSeed(name)
+	if err != nil {
+		return Wallet{}, err
+	}
+	w := DeriveWallet(seed, account, next)
+	w.WalletName = name
+	StoreWallet(w)
+	return w, nil
+}
+
+// Derives addresses from a wallet's seed, storing any that have been used
+// on the longest chain. Each account is scanned until GapLimit unused
+// addresses in a row, and accounts are scanned until one has no used or
+// stored addresses. Returns the number of keys added. Fails while the
+// wallet is locked.
+func ScanWallet(name string) (int, error) {
+	seed, err := fetchOrCreateSeed(name)
+	if err != nil {
+		return 0, err
+	}
+	l, err := LedgerAt(FetchHighestBlock())
+	if err != nil {
+		panic(err)
+	}
+
+	stored := make(map[string]bool)
+	hasAccount := make(map[uint32]bool)
+	for _, w := range FetchWallets(name) {
+		stored[w.Address()] = true
+		if account, _, ok := w.PathIndexes(); ok {
+			hasAccount[account] = true
+		}
+	}
+
+	added := 0
+	for account := uint32(0); ; account++ {
+		used := false
+		for index, gap := uint32(0), 0; gap < GapLimit; index++ {
+			w := DeriveWallet(seed, account, index)
+			if !l.Used(w.Address()) {
+				gap++
+				continue
+			}
+			gap = 0
+			used = true
+			if !stored[w.Address()] {
+				w.WalletName = name
+				StoreWallet(w)
+				stored[w.Address()] = true
+				added++
+			}
+		}
+		if !used && !hasAccount[account] {
+			return added, nil
+		}
+	}
+}
+
+// Adds a wallet from a seed, and the phrase it came from if any. Fails if
+// a wallet with the name already exists.
+func RestoreWallet(name string, seed []byte, mnemonic string) error {
+	if name == "" {
+		return errors.New("wallet name can't be empty")
+	}
+	if len(FetchWallets(name)) > 0 || hasSeed(name) {
+		return errors.New("wallet " + name + " already exists")
+	}
+	err := StoreSeed(name, seed, mnemonic)
+	if err != nil {
+		return err
+	}
+	w := DeriveWallet(seed, 0, 0)
+	w.WalletName = name
+	StoreWallet(w)
+	return nil
+}
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"bytes"
+	"github.com/frankh/arachnacoin/crypto"
+	"github.com/frankh/arachnacoin/transaction"
+	"github.com/frankh/arachnacoin/work"
+	"testing"
+)
+
+func TestRestoreWallet(t *testing.T) {
+	work.Difficulty = 0xff000000
+	Init(":memory:")
+	seed, _ := FetchSeed(DefaultWalletName)
+	if seed == nil {
+		t.Fatalf("New wallet has no seed")
+	}
+	// New seeds come from a phrase
+	mnemonic, _ := FetchMnemonic(DefaultWalletName)
+	fromMnemonic, err := crypto.MnemonicToSeed(mnemonic, "")
+	if err != nil || !bytes.Equal(seed, fromMnemonic) {
+		t.Fatalf("Seed doesn't match its mnemonic: %v", err)
+	}
+	first := *MyWallet
+	derived := DeriveWallet(seed, 0, 0)
+	if first.Address() != derived.Address() {
+		t.Fatalf("First key not derived from the seed")
+	}
+
+	// A later address in account 0, leaving a gap just under the gap
+	// limit, and the first address of account 1
+	var later Wallet
+	for i := 0; i < GapLimit; i++ {
+		later, _ = NewAddress(DefaultWalletName, 0)
+	}
+	other, _ := NewAddress(DefaultWalletName, 1)
+	if later.Path != "m/44'/31042'/0'/0'/20'" || other.Path != "m/44'/31042'/1'/0'/0'" {
+		t.Errorf("Unexpected paths %s and %s", later.Path, other.Path)
+	}
+
+	// Restore the seed into a new database and use the addresses on its
+	// chain, along with one past the gap limit
+	Open(":memory:")
+	chain := Conn
+	err = RestoreWallet(DefaultWalletName, seed, "")
+	if err != nil {
+		t.Fatalf("Restore failed: %s", err)
+	}
+	if RestoreWallet(DefaultWalletName, seed, "") == nil {
+		t.Errorf("Restored over an existing wallet")
+	}
+	mineOn(chain, nil, first.Address())
+	beyond := DeriveWallet(seed, 0, GapLimit*2+1)
+	var payments []transaction.Transaction
+	for _, to := range []Wallet{later, other, beyond} {
+		payment, _ := first.Send(to.Address(), 10, nil)
+		payments = append(payments, payment)
+	}
+	if !mineOn(chain, payments, first.Address()) {
+		t.Fatalf("Failed to mine payments")
+	}
+
+	if added, _ := ScanWallet(DefaultWalletName); added != 2 {
+		t.Errorf("Scan added %d keys, expected 2", added)
+	}
+	if FetchWalletByAddress(later.Address()) == nil || FetchWalletByAddress(other.Address()) == nil {
+		t.Errorf("Scan didn't find used addresses")
+	}
+	if FetchWalletByAddress(beyond.Address()) != nil {
+		t.Errorf("Scan looked past the gap limit")
+	}
+	if added, _ := ScanWallet(DefaultWalletName); added != 0 {
+		t.Errorf("Rescan added %d keys", added)
+	}
+	if next, _ := NewAddress(DefaultWalletName, 0); next.Path != "m/44'/31042'/0'/0'/21'" {
+		t.Errorf("New address reused an index: %s", next.Path)
+	}
+}
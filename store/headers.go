@@ -0,0 +1,216 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/frankh/arachnacoin/block"
+	"github.com/frankh/arachnacoin/work"
+	"sync"
+)
+
+// Chains are synced headers first. Headers are checked and stored in
+// arach_header until their block's transactions are fetched and the block
+// is stored, so a sync picks up where it left off.
+
+// Returns the header of a block, whether or not the rest of the block has
+// been fetched, or nil if it's unknown
+func FetchHeader(hash string) *block.Header {
+	var h block.Header
+	err := Conn.QueryRow(`SELECT hash, previous, work, height FROM arach_header WHERE hash=?`, hash).Scan(
+		&h.Hash, &h.Previous, &h.Work, &h.Height)
+	if err == sql.ErrNoRows {
+		err = Conn.QueryRow(`SELECT hash, previous, work, height FROM arach_block WHERE hash=?`, hash).Scan(
+			&h.Hash, &h.Previous, &h.Work, &h.Height)
+	}
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		panic(err)
+	}
+	return &h
+}
+
+// Returns the header at the tip of the longest chain of headers, which is
+// the highest block's if no headers are waiting
+func FetchBestHeader() block.Header {
+	highest := FetchHighestBlock()
+	var h block.Header
+	err := Conn.QueryRow(`SELECT hash, previous, work, height FROM arach_header WHERE height > ? ORDER BY height DESC, hash LIMIT 1`,
+		highest.Height).Scan(&h.Hash, &h.Previous, &h.Work, &h.Height)
+	if err == sql.ErrNoRows {
+		return highest.Header()
+	}
+	if err != nil {
+		panic(err)
+	}
+	return h
+}
+
+var ErrUnknownPrevious = errors.New("previous block is unknown")
+var ErrBadWork = errors.New("bad work")
+
+// Checks a header's work, and that it follows a known header
+func ValidateHeader(h block.Header) error {
+	previous := FetchHeader(h.Previous)
+	if previous == nil {
+		return ErrUnknownPrevious
+	}
+	if h.Height != previous.Height+1 {
+		return errors.New("height doesn't follow the previous block's")
+	}
+	if !work.ValidateHeaderWork(h) {
+		return ErrBadWork
+	}
+	return nil
+}
+
+// Stores a validated header, unless its block or header is already known
+func StoreHeader(h block.Header) {
+	if FetchHeader(h.Hash) != nil {
+		return
+	}
+	_, err := Conn.Exec(`INSERT INTO arach_header (hash, previous, work, height) values (?, ?, ?, ?)`,
+		h.Hash, h.Previous, h.Work, h.Height)
+	if err != nil {
+		panic(err)
+	}
+	recordHeaderChain()
+}
+
+// Removes a header whose block turned out to be invalid, along with the
+// headers built on it
+func RemoveHeaders(hash string) {
+	_, err := Conn.Exec(`
+    WITH RECURSIVE bad(hash) AS (
+      SELECT ?
+      UNION ALL
+      SELECT h.hash FROM arach_header h JOIN bad ON h.previous = bad.hash
+    )
+    DELETE FROM arach_header WHERE hash IN bad`, hash)
+	if err != nil {
+		panic(err)
+	}
+	recordHeaderChain()
+}
+
+// Returns the headers of up to limit blocks on the longest chain of
+// headers that haven't been fetched, lowest first
+func MissingBlocks(limit int) []block.Header {
+	return headerChain(`
+    SELECT h.hash, h.previous, h.work, h.height FROM arach_header h
+    JOIN arach_header_chain c ON c.height = h.height AND c.hash = h.hash
+    ORDER BY h.height LIMIT ?`, limit)
+}
+
+func headerChain(query string, args ...interface{}) []block.Header {
+	rows, err := Conn.Query(query, args...)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	headers := make([]block.Header, 0)
+	for rows.Next() {
+		var h block.Header
+		err = rows.Scan(&h.Hash, &h.Previous, &h.Work, &h.Height)
+		if err != nil {
+			panic(err)
+		}
+		headers = append(headers, h)
+	}
+	return headers
+}
+
+// Held while the chains in arach_main_chain and arach_header_chain are
+// brought up to date
+var chainLock sync.Mutex
+
+// Brings arach_main_chain up to date with the highest block, and
+// arach_header_chain with the best header
+func recordChains() {
+	chainLock.Lock()
+	defer chainLock.Unlock()
+	highest := FetchHighestBlock()
+	recordChain("arach_main_chain", highest.Header())
+	recordChain("arach_header_chain", FetchBestHeader())
+}
+
+func recordHeaderChain() {
+	chainLock.Lock()
+	defer chainLock.Unlock()
+	recordChain("arach_header_chain", FetchBestHeader())
+}
+
+// Records the hash at each height of the chain ending at tip, going back
+// only as far as where it joins the chain recorded before
+func recordChain(table string, tip block.Header) {
+	_, err := Conn.Exec(`DELETE FROM `+table+` WHERE height > ?`, tip.Height)
+	if err != nil {
+		panic(err)
+	}
+	for h := &tip; h != nil; h = FetchHeader(h.Previous) {
+		var hash string
+		err = Conn.QueryRow(`SELECT hash FROM `+table+` WHERE height=?`, h.Height).Scan(&hash)
+		if err == nil && hash == h.Hash {
+			return
+		}
+		if err != nil && err != sql.ErrNoRows {
+			panic(err)
+		}
+		_, err = Conn.Exec(`INSERT OR REPLACE INTO `+table+` (height, hash) values (?, ?)`, h.Height, h.Hash)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Returns hashes from the longest chain of headers for a peer to find
+// where its chain forks from ours: the latest 10, then going back twice as
+// far each time, ending with genesis
+func BlockLocator() []string {
+	best := FetchBestHeader()
+	locator := make([]string, 0)
+	step := 1
+	for height := int(best.Height); height >= 0; height -= step {
+		var hash string
+		err := Conn.QueryRow(`SELECT hash FROM arach_header_chain WHERE height=?`, height).Scan(&hash)
+		// Heights just added may not be recorded yet
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			panic(err)
+		}
+		locator = append(locator, hash)
+		if len(locator) >= 10 {
+			step *= 2
+		}
+	}
+	genesis := block.GenesisBlock.HashString()
+	if locator[len(locator)-1] != genesis {
+		locator = append(locator, genesis)
+	}
+	return locator
+}
+
+// Returns up to limit headers from the highest block's chain following the
+// first locator hash that's on it
+func LocateHeaders(locator []string, limit int) []block.Header {
+	// Start after genesis if nothing in the locator is on the chain
+	var fork uint32
+	for _, hash := range locator {
+		err := Conn.QueryRow(`SELECT height FROM arach_main_chain WHERE hash=?`, hash).Scan(&fork)
+		if err == nil {
+			break
+		}
+		if err != sql.ErrNoRows {
+			panic(err)
+		}
+	}
+
+	return headerChain(`
+    SELECT b.hash, b.previous, b.work, b.height FROM arach_main_chain c
+    JOIN arach_block b ON b.hash = c.hash
+    WHERE c.height > ? ORDER BY c.height LIMIT ?`, fork, limit)
+}
@@ -0,0 +1,167 @@
+package store
+
+import (
+	"database/sql"
+	"github.com/frankh/arachnacoin/block"
+	"github.com/frankh/arachnacoin/work"
+	"testing"
+)
+
+// Copies the headers of conn's chain after the locator into to, as a peer
+// would send them
+func syncHeaders(t *testing.T, from *sql.DB, to *sql.DB, limit int) int {
+	Conn = to
+	locator := BlockLocator()
+	Conn = from
+	headers := LocateHeaders(locator, limit)
+	Conn = to
+	for _, h := range headers {
+		if err := ValidateHeader(h); err != nil {
+			t.Fatalf("Rejected header %d: %s", h.Height, err)
+		}
+		StoreHeader(h)
+	}
+	return len(headers)
+}
+
+func TestHeadersFirstSync(t *testing.T) {
+	work.Difficulty = 0xff000000
+	miner := GenerateWallet()
+	Init(":memory:")
+	chain := Conn
+	for i := 0; i < 30; i++ {
+		mineOn(chain, nil, miner.Address())
+	}
+	Init(":memory:")
+	fresh := Conn
+
+	// Headers come in bounded batches, each picking up after the last
+	if n := syncHeaders(t, chain, fresh, 20); n != 20 {
+		t.Fatalf("Got %d headers in the first batch", n)
+	}
+	if n := syncHeaders(t, chain, fresh, 20); n != 10 {
+		t.Fatalf("Got %d headers in the second batch", n)
+	}
+	if n := syncHeaders(t, chain, fresh, 20); n != 0 {
+		t.Fatalf("Got %d headers once synced", n)
+	}
+	Conn = chain
+	tip := FetchHighestBlock()
+	Conn = fresh
+	if FetchBestHeader() != tip.Header() || FetchHighestBlock().Height != 0 {
+		t.Fatalf("Best header isn't the chain's tip")
+	}
+
+	// Then the blocks, lowest first
+	for height := uint32(1); height <= 30; height++ {
+		missing := MissingBlocks(5)
+		want := 5
+		if height > 26 {
+			want = int(30 - height + 1)
+		}
+		if len(missing) != want {
+			t.Fatalf("Missing %d blocks, expected %d", len(missing), want)
+		}
+		Conn = chain
+		b := FetchBlock(missing[0].Hash)
+		Conn = fresh
+		if b.Height != height || !ValidateBlock(*b) {
+			t.Fatalf("Block at height %d can't be stored", height)
+		}
+		StoreBlock(*b)
+	}
+	highest := FetchHighestBlock()
+	if len(MissingBlocks(5)) != 0 || highest.Header() != tip.Header() {
+		t.Errorf("Chain not synced")
+	}
+}
+
+func TestHeaderValidation(t *testing.T) {
+	work.Difficulty = 0xff000000
+	Init(":memory:")
+	b := work.Mine(block.GenesisBlock, nil, "unspendable")
+
+	bad := b.Header()
+	bad.Height = 2
+	if ValidateHeader(bad) == nil {
+		t.Errorf("Accepted header at the wrong height")
+	}
+	bad = b.Header()
+	bad.Previous = b.HashString()
+	if ValidateHeader(bad) == nil {
+		t.Errorf("Accepted header following an unknown block")
+	}
+	bad = b.Header()
+	for work.ValidateHeaderWork(bad) {
+		bad.Work++
+	}
+	if ValidateHeader(bad) == nil {
+		t.Errorf("Accepted header with bad work")
+	}
+
+	// Headers built on a block found to be invalid go with it
+	h := b.Header()
+	StoreHeader(h)
+	next := work.Mine(b, nil, "unspendable")
+	StoreHeader(next.Header())
+	if FetchBestHeader() != next.Header() {
+		t.Fatalf("Best header isn't the highest")
+	}
+	RemoveHeaders(h.Hash)
+	if FetchHeader(next.HashString()) != nil || FetchBestHeader() != block.GenesisBlock.Header() {
+		t.Errorf("Headers not removed")
+	}
+}
+
+func TestLocateFork(t *testing.T) {
+	work.Difficulty = 0xff000000
+	miner := GenerateWallet()
+	Init(":memory:")
+	chainA := Conn
+	Init(":memory:")
+	chainB := Conn
+	for i := 0; i < 25; i++ {
+		Conn = chainA
+		b := work.Mine(FetchHighestBlock(), nil, miner.Address())
+		StoreBlock(b)
+		Conn = chainB
+		StoreBlock(b)
+	}
+	// The chains fork at height 25
+	other := GenerateWallet()
+	for i := 0; i < 5; i++ {
+		mineOn(chainA, nil, miner.Address())
+		mineOn(chainB, nil, other.Address())
+	}
+
+	Conn = chainB
+	locator := BlockLocator()
+	if len(locator) >= 30 || locator[len(locator)-1] != block.GenesisBlock.HashString() {
+		t.Errorf("Bad locator of %d hashes", len(locator))
+	}
+	Conn = chainA
+	headers := LocateHeaders(locator, 100)
+	if len(headers) != 5 || headers[0].Height != 26 {
+		t.Errorf("Fork not found, got %d headers", len(headers))
+	}
+
+	// Chain B switching to chain A's fork serves chain A's blocks from then on
+	mineOn(chainA, nil, miner.Address())
+	Conn = chainA
+	tip := FetchHighestBlock()
+	Conn = chainB
+	for _, h := range headers {
+		Conn = chainA
+		b := FetchBlock(h.Hash)
+		Conn = chainB
+		StoreBlock(*b)
+	}
+	Conn = chainA
+	b := FetchBlock(tip.HashString())
+	Conn = chainB
+	StoreBlock(*b)
+	headers = LocateHeaders([]string{block.GenesisBlock.HashString()}, 100)
+	if len(headers) != 31 || headers[30] != tip.Header() {
+		t.Errorf("Switched chain not located, got %d headers", len(headers))
+	}
+}
@@ -0,0 +1,77 @@
+package store
+
+import (
+	"errors"
+	"github.com/frankh/arachnacoin/transaction"
+)
+
+// Builds a signed transaction locking amount from the wallet into a new
+// contract. The contract's address is the transaction output.
+func (w *Wallet) LockHTLC(c transaction.HTLC, amount uint64) (transaction.Transaction, error) {
+	t := transaction.Transaction{
+		Input:  w.Address(),
+		Output: c.Address(),
+		Amount: amount,
+		Unique: transaction.NewUnique(),
+		HTLC:   &c,
+	}
+	err := w.Sign(&t)
+	return t, err
+}
+
+// Builds a transaction paying the contract at address to its recipient.
+// Claims don't need signing, only the preimage of the hash lock.
+func ClaimHTLC(address string, preimage string) (transaction.Transaction, error) {
+	contract, balance, err := FetchContract(address)
+	if err != nil {
+		return transaction.Transaction{}, err
+	}
+	if balance == 0 {
+		return transaction.Transaction{}, errors.New("contract has already been spent")
+	}
+	if !contract.Terms.CheckPreimage(preimage) {
+		return transaction.Transaction{}, errors.New("preimage does not match hash lock")
+	}
+
+	return transaction.Transaction{
+		Input:     address,
+		Output:    contract.Terms.Recipient,
+		Amount:    balance,
+		Signature: "unsigned",
+		Unique:    transaction.NewUnique(),
+		Preimage:  preimage,
+	}, nil
+}
+
+// Builds a transaction returning the funds in a timed out contract to its
+// refund address.
+func RefundHTLC(address string) (transaction.Transaction, error) {
+	contract, balance, err := FetchContract(address)
+	if err != nil {
+		return transaction.Transaction{}, err
+	}
+	if balance == 0 {
+		return transaction.Transaction{}, errors.New("contract has already been spent")
+	}
+
+	return transaction.Transaction{
+		Input:     address,
+		Output:    contract.Terms.Refund,
+		Amount:    balance,
+		Signature: "unsigned",
+		Unique:    transaction.NewUnique(),
+	}, nil
+}
+
+// Looks up a contract and its remaining balance on the longest chain
+func FetchContract(address string) (*Contract, uint64, error) {
+	l, err := LedgerAt(FetchHighestBlock())
+	if err != nil {
+		return nil, 0, err
+	}
+	contract := l.Contract(address)
+	if contract == nil {
+		return nil, 0, errors.New("unknown contract")
+	}
+	return contract, l.Balance(address), nil
+}
@@ -0,0 +1,162 @@
+package store
+
+import (
+	"database/sql"
+	"github.com/frankh/arachnacoin/block"
+	"github.com/frankh/arachnacoin/transaction"
+	"github.com/frankh/arachnacoin/work"
+	"testing"
+)
+
+// Mines a block containing ts on the chain in conn, returning whether it
+// was valid.
+func mineOn(conn *sql.DB, ts []transaction.Transaction, rewardAccount string) bool {
+	Conn = conn
+	b := work.Mine(FetchHighestBlock(), ts, rewardAccount)
+	if !ValidateBlock(b) {
+		return false
+	}
+	StoreBlock(b)
+	return true
+}
+
+func TestAtomicSwap(t *testing.T) {
+	work.Difficulty = 0xff000000
+	alice := GenerateWallet()
+	bob := GenerateWallet()
+
+	Init(":memory:")
+	chainA := Conn
+	Init(":memory:")
+	chainB := Conn
+
+	// Alice has coins on chain A, Bob has coins on chain B
+	mineOn(chainA, nil, alice.Address())
+	mineOn(chainB, nil, bob.Address())
+
+	secret := []byte("correct horse battery staple")
+	preimage := "636f727265637420686f727365206261747465727920737461706c65"
+	hashLock := transaction.HashSecret(secret)
+
+	// Alice locks funds on A for Bob, with the longer timeout
+	Conn = chainA
+	lockA, _ := alice.LockHTLC(transaction.HTLC{
+		Recipient: bob.Address(),
+		Refund:    alice.Address(),
+		HashLock:  hashLock,
+		Timeout:   FetchHighestBlock().Height + 10,
+	}, 3000)
+	if !mineOn(chainA, []transaction.Transaction{lockA}, alice.Address()) {
+		t.Fatalf("Failed to lock funds on chain A")
+	}
+
+	// Bob checks Alice's contract then locks funds on B for Alice
+	contractA, balance, err := FetchContract(lockA.Output)
+	if err != nil || balance != 3000 || contractA.Terms.Recipient != bob.Address() {
+		t.Fatalf("Contract on chain A not found: %s", err)
+	}
+	Conn = chainB
+	lockB, _ := bob.LockHTLC(transaction.HTLC{
+		Recipient: alice.Address(),
+		Refund:    bob.Address(),
+		HashLock:  contractA.Terms.HashLock,
+		Timeout:   FetchHighestBlock().Height + 5,
+	}, 2000)
+	if !mineOn(chainB, []transaction.Transaction{lockB}, bob.Address()) {
+		t.Fatalf("Failed to lock funds on chain B")
+	}
+
+	// Bob can't refund early, and a wrong preimage can't claim
+	Conn = chainB
+	refund, err := RefundHTLC(lockB.Output)
+	if err != nil {
+		t.Fatalf("Couldn't build refund: %s", err)
+	}
+	if mineOn(chainB, []transaction.Transaction{refund}, bob.Address()) {
+		t.Errorf("Refunded contract before timeout")
+	}
+	bad := refund
+	bad.Output = alice.Address()
+	bad.Preimage = "00"
+	if mineOn(chainB, []transaction.Transaction{bad}, bob.Address()) {
+		t.Errorf("Claimed contract with wrong preimage")
+	}
+
+	// Alice claims on B, revealing the secret
+	Conn = chainB
+	claimB, err := ClaimHTLC(lockB.Output, preimage)
+	if err != nil {
+		t.Fatalf("Couldn't build claim: %s", err)
+	}
+	if !mineOn(chainB, []transaction.Transaction{claimB}, bob.Address()) {
+		t.Fatalf("Failed to claim on chain B")
+	}
+	if GetBalance(alice.Address()) != 2000 {
+		t.Errorf("Alice didn't receive funds on chain B")
+	}
+
+	// Bob learns the secret from chain B and claims on A
+	contractB, _, err := FetchContract(lockB.Output)
+	if err != nil || contractB.Preimage != preimage {
+		t.Fatalf("Preimage not revealed on chain B")
+	}
+	Conn = chainA
+	claimA, err := ClaimHTLC(lockA.Output, contractB.Preimage)
+	if err != nil {
+		t.Fatalf("Couldn't build claim: %s", err)
+	}
+	if !mineOn(chainA, []transaction.Transaction{claimA}, alice.Address()) {
+		t.Fatalf("Failed to claim on chain A")
+	}
+	if GetBalance(bob.Address()) != 3000 {
+		t.Errorf("Bob didn't receive funds on chain A")
+	}
+
+	// Contracts can only be spent once
+	if mineOn(chainA, []transaction.Transaction{claimA}, alice.Address()) {
+		t.Errorf("Contract was claimed twice")
+	}
+}
+
+func TestHTLCRefund(t *testing.T) {
+	work.Difficulty = 0xff000000
+	alice := GenerateWallet()
+	bob := GenerateWallet()
+	miner := bob.Address()
+	Init(":memory:")
+	chain := Conn
+	mineOn(chain, nil, alice.Address())
+
+	lock, _ := alice.LockHTLC(transaction.HTLC{
+		Recipient: bob.Address(),
+		Refund:    alice.Address(),
+		HashLock:  transaction.HashSecret([]byte("secret")),
+		Timeout:   FetchHighestBlock().Height + 2,
+	}, 1000)
+	if !mineOn(chain, []transaction.Transaction{lock}, miner) {
+		t.Fatalf("Failed to lock funds")
+	}
+
+	// A block can't claim a later height to refund early
+	early, _ := RefundHTLC(lock.Output)
+	b := work.Mine(FetchHighestBlock(), []transaction.Transaction{early}, miner)
+	b.Height += 10
+	if ValidateBlock(b) {
+		t.Errorf("Refunded contract in a block with a made up height")
+	}
+	mineOn(chain, nil, miner)
+
+	// Claims are no longer possible once the timeout is reached
+	claim, _ := ClaimHTLC(lock.Output, "736563726574")
+	if mineOn(chain, []transaction.Transaction{claim}, miner) {
+		t.Errorf("Claimed contract after timeout")
+	}
+
+	refund, _ := RefundHTLC(lock.Output)
+	if !mineOn(chain, []transaction.Transaction{refund}, miner) {
+		t.Fatalf("Failed to refund contract")
+	}
+	if GetBalance(alice.Address()) != block.BlockReward {
+		t.Errorf("Refund not returned to sender")
+	}
+}
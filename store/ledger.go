@@ -0,0 +1,343 @@
+package store
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/frankh/arachnacoin/block"
+	"github.com/frankh/arachnacoin/crypto"
+	"github.com/frankh/arachnacoin/script"
+	"github.com/frankh/arachnacoin/transaction"
+	"golang.org/x/crypto/ed25519"
+)
+
+// A contract known to the ledger, along with the preimage once it has
+// been claimed.
+type Contract struct {
+	Terms    transaction.HTLC
+	Preimage string
+}
+
+// Ledger replays transactions in chain order, tracking balances, contracts
+// and scripts so that each new transaction can be checked against the state
+// left by all the ones before it.
+type Ledger struct {
+	Height    uint32
+	balances  map[string]uint64
+	contracts map[string]*Contract
+	scripts   map[string][]byte
+	seen      map[string]bool
+}
+
+func NewLedger() *Ledger {
+	return &Ledger{
+		0,
+		make(map[string]uint64),
+		make(map[string]*Contract),
+		make(map[string][]byte),
+		make(map[string]bool),
+	}
+}
+
+// Builds the ledger for the chain ending at b, which must be stored
+func LedgerAt(b block.Block) (*Ledger, error) {
+	hashChain := GetBlockHashChain(&b)
+	if hashChain == nil {
+		return nil, errors.New("missing block in chain")
+	}
+	return ledgerForHashChain(hashChain)
+}
+
+// Builds the ledger from a list of block hashes, latest first, as returned
+// by GetBlockHashChain.
+func ledgerForHashChain(hashChain []string) (*Ledger, error) {
+	l := NewLedger()
+	for i := range hashChain {
+		b := FetchBlock(hashChain[len(hashChain)-i-1])
+		if b == nil {
+			return nil, errors.New("missing block in chain")
+		}
+		err := l.ApplyBlock(*b)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// Returns a copy of the ledger that can be applied to without changing it
+func (l *Ledger) Copy() *Ledger {
+	c := NewLedger()
+	c.Height = l.Height
+	for address, balance := range l.balances {
+		c.balances[address] = balance
+	}
+	for address, contract := range l.contracts {
+		copied := *contract
+		c.contracts[address] = &copied
+	}
+	for address, lock := range l.scripts {
+		c.scripts[address] = lock
+	}
+	for hash := range l.seen {
+		c.seen[hash] = true
+	}
+	return c
+}
+
+func (l *Ledger) Balance(address string) uint64 {
+	return l.balances[address]
+}
+
+// Whether an address has ever received funds
+func (l *Ledger) Used(address string) bool {
+	_, ok := l.balances[address]
+	return ok
+}
+
+// Returns the contract at an HTLC address, or nil if it was never funded
+func (l *Ledger) Contract(address string) *Contract {
+	return l.contracts[address]
+}
+
+// Returns the locking script at a script address, or nil if it was never
+// paid to
+func (l *Ledger) Script(address string) []byte {
+	return l.scripts[address]
+}
+
+func (l *Ledger) ApplyBlock(b block.Block) error {
+	l.Height = b.Height
+	for _, t := range b.Transactions {
+		err := l.Apply(t)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Checks what can be checked of a transaction without the chain: that it's
+// well formed, signed if it's a plain transfer, and sets up any contract or
+// script properly. A transaction failing this is invalid on every chain.
+func CheckTransaction(t transaction.Transaction) error {
+	if t.Input != "blockReward" {
+		if _, _, err := crypto.DecodeAddress(t.Input); err != nil {
+			return fmt.Errorf("bad input: %s", err)
+		}
+	}
+	if _, _, err := crypto.DecodeAddress(t.Output); err != nil {
+		return fmt.Errorf("bad output: %s", err)
+	}
+	if t.Preimage != "" && !transaction.IsHTLCAddress(t.Input) {
+		return errors.New("preimage is only valid when claiming a contract")
+	}
+	if t.Unlock != "" && !transaction.IsScriptAddress(t.Input) {
+		return errors.New("unlocking script is only valid when spending from a script")
+	}
+	if t.Data != "" {
+		data, err := hex.DecodeString(t.Data)
+		if err != nil {
+			return errors.New("data is not hex")
+		}
+		if len(data) > transaction.MaxDataSize {
+			return fmt.Errorf("data is over %d bytes", transaction.MaxDataSize)
+		}
+	}
+	if t.Unlock != "" {
+		if _, err := hex.DecodeString(t.Unlock); err != nil {
+			return errors.New("unlocking script is not hex")
+		}
+	}
+
+	switch {
+	// Assume Blockrewards are valid. These should be checked
+	// in the block itself.
+	case t.Input == "blockReward":
+		if t.HTLC != nil || t.Lock != "" {
+			return errors.New("block rewards cannot use contracts")
+		}
+	case transaction.IsHTLCAddress(t.Input), transaction.IsScriptAddress(t.Input):
+	default:
+		if !VerifySignature(t) {
+			return errors.New("bad signature")
+		}
+	}
+	return checkTerms(t)
+}
+
+// Checks a transaction against the current state and applies it if valid.
+// Transactions are checked as if they were in a block at l.Height.
+func (l *Ledger) Apply(t transaction.Transaction) error {
+	hash := t.HashString()
+	if l.seen[hash] {
+		return fmt.Errorf("transaction %s already in chain", hash)
+	}
+	err := CheckTransaction(t)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case t.Input == "blockReward":
+	case transaction.IsHTLCAddress(t.Input):
+		err = l.checkContractSpend(t)
+	case transaction.IsScriptAddress(t.Input):
+		err = l.checkScriptSpend(t)
+	default:
+		err = l.checkTransfer(t)
+	}
+	if err == nil {
+		err = l.checkOutput(t)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Work out the new balances before changing anything, so that a
+	// transaction that would overflow leaves the ledger untouched
+	inputBalance := l.balances[t.Input]
+	if t.Input != "blockReward" {
+		inputBalance, err = transaction.SubAmounts(inputBalance, t.Amount)
+		if err != nil {
+			return err
+		}
+	}
+	outputBalance := l.balances[t.Output]
+	if t.Output == t.Input {
+		outputBalance = inputBalance
+	}
+	outputBalance, err = transaction.AddAmounts(outputBalance, t.Amount)
+	if err != nil {
+		return err
+	}
+
+	if t.HTLC != nil {
+		l.contracts[t.Output] = &Contract{*t.HTLC, ""}
+	}
+	if t.Lock != "" {
+		l.scripts[t.Output], _ = hex.DecodeString(t.Lock)
+	}
+	if t.Preimage != "" {
+		l.contracts[t.Input].Preimage = t.Preimage
+	}
+	if t.Input != "blockReward" {
+		l.balances[t.Input] = inputBalance
+	}
+	l.balances[t.Output] = outputBalance
+	l.seen[hash] = true
+	return nil
+}
+
+// Checks a contract being funded can still be
+func (l *Ledger) checkOutput(t transaction.Transaction) error {
+	if t.HTLC != nil {
+		if t.HTLC.Timeout <= l.Height {
+			return errors.New("contract has already timed out")
+		}
+		if l.contracts[t.Output] != nil {
+			return errors.New("contract already funded")
+		}
+	}
+	return nil
+}
+
+// Checks funds only go to contract or script addresses along with the
+// terms that lock them.
+func checkTerms(t transaction.Transaction) error {
+	switch {
+	case t.HTLC != nil && t.Lock != "":
+		return errors.New("cannot fund a contract and a script at once")
+	case t.HTLC != nil:
+		if t.Output != t.HTLC.Address() {
+			return errors.New("output does not match contract address")
+		}
+		if !crypto.IsAddress(t.HTLC.Recipient, crypto.PubKeyAddress) || !crypto.IsAddress(t.HTLC.Refund, crypto.PubKeyAddress) {
+			return errors.New("contract recipient and refund must be wallet addresses")
+		}
+	case t.Lock != "":
+		lock, err := hex.DecodeString(t.Lock)
+		if err != nil {
+			return errors.New("locking script is not hex")
+		}
+		if t.Output != transaction.ScriptAddress(lock) {
+			return errors.New("output does not match script address")
+		}
+		if _, err = script.Disassemble(lock); err != nil {
+			return fmt.Errorf("bad locking script: %s", err)
+		}
+	case transaction.IsHTLCAddress(t.Output), transaction.IsScriptAddress(t.Output):
+		return errors.New("contract addresses can only be paid by funding transactions")
+	}
+	return nil
+}
+
+func (l *Ledger) checkTransfer(t transaction.Transaction) error {
+	if t.Amount > l.balances[t.Input] {
+		return errors.New("insufficient balance")
+	}
+	return nil
+}
+
+func (l *Ledger) checkContractSpend(t transaction.Transaction) error {
+	contract := l.contracts[t.Input]
+	if contract == nil {
+		return errors.New("unknown contract")
+	}
+	// Contracts are always spent in full
+	if t.Amount == 0 || t.Amount != l.balances[t.Input] {
+		return errors.New("contract must be spent in full")
+	}
+
+	if t.Preimage != "" {
+		if l.Height >= contract.Terms.Timeout {
+			return errors.New("contract has timed out")
+		}
+		if t.Output != contract.Terms.Recipient {
+			return errors.New("claim must pay the contract recipient")
+		}
+		if !contract.Terms.CheckPreimage(t.Preimage) {
+			return errors.New("preimage does not match hash lock")
+		}
+	} else {
+		if l.Height < contract.Terms.Timeout {
+			return errors.New("contract has not timed out yet")
+		}
+		if t.Output != contract.Terms.Refund {
+			return errors.New("refund must pay the contract refund address")
+		}
+	}
+	return nil
+}
+
+// Runs the spend's unlocking script against the locking script the funds
+// were sent with. Signatures in the unlocking script sign the transaction
+// hash, which covers the output and amount.
+func (l *Ledger) checkScriptSpend(t transaction.Transaction) error {
+	lock := l.scripts[t.Input]
+	if lock == nil {
+		return errors.New("unknown script")
+	}
+	if t.Amount > l.balances[t.Input] {
+		return errors.New("insufficient balance")
+	}
+	unlock, _ := hex.DecodeString(t.Unlock)
+	err := script.Execute(unlock, lock, script.Context{Hash: t.Hash(), Height: l.Height})
+	if err != nil {
+		return fmt.Errorf("script failed: %s", err)
+	}
+	return nil
+}
+
+// Checks the transaction was signed by the owner of its input address
+func VerifySignature(t transaction.Transaction) bool {
+	version, pubKey, err := crypto.DecodeAddress(t.Input)
+	if err != nil || version != crypto.PubKeyAddress {
+		return false
+	}
+	signature, err := hex.DecodeString(t.Signature)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(pubKey, t.Hash(), signature)
+}
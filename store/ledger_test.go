@@ -0,0 +1,81 @@
+package store
+
+import (
+	"github.com/frankh/arachnacoin/block"
+	"github.com/frankh/arachnacoin/crypto"
+	"github.com/frankh/arachnacoin/transaction"
+	"strings"
+	"testing"
+)
+
+func TestLedgerOverflow(t *testing.T) {
+	l := NewLedger()
+	w := GenerateWallet()
+	rewardAccount := w.Address()
+	reward := transaction.Transaction{
+		Input:     "blockReward",
+		Output:    rewardAccount,
+		Amount:    transaction.MaxAmount,
+		Signature: "unsigned",
+		Unique:    "01",
+	}
+	if err := l.Apply(reward); err != nil {
+		t.Fatalf("Couldn't apply reward: %s", err)
+	}
+
+	reward.Amount = 1
+	reward.Unique = "02"
+	if err := l.Apply(reward); err != transaction.ErrAmountOverflow {
+		t.Errorf("Overflow not detected: %v", err)
+	}
+	if l.Balance(rewardAccount) != transaction.MaxAmount {
+		t.Errorf("Failed transaction changed the balance")
+	}
+}
+
+func TestLedgerAddresses(t *testing.T) {
+	l := NewLedger()
+	w := GenerateWallet()
+	reward := transaction.Transaction{
+		Input:     "blockReward",
+		Output:    w.Address(),
+		Amount:    block.BlockReward,
+		Signature: "unsigned",
+		Unique:    "01",
+	}
+
+	// Change the last character of the checksum
+	address := w.Address()
+	last := "q"
+	if strings.HasSuffix(address, last) {
+		last = "p"
+	}
+	for _, output := range []string{"unspendable", "blockReward", address[:len(address)-1] + last} {
+		reward.Output = output
+		if l.Apply(reward) == nil {
+			t.Errorf("Paid to invalid address %q", output)
+		}
+	}
+
+	// Addresses from another network are rejected too
+	crypto.ActiveNetwork = crypto.RegTest
+	reward.Output = w.Address()
+	crypto.ActiveNetwork = crypto.MainNet
+	if l.Apply(reward) == nil {
+		t.Errorf("Paid to address on another network")
+	}
+
+	// Nor can a transaction signed on one network be replayed on another
+	hash := reward.HashString()
+	crypto.ActiveNetwork = crypto.RegTest
+	other := reward.HashString()
+	crypto.ActiveNetwork = crypto.MainNet
+	if hash == other {
+		t.Errorf("Transaction hashed the same on another network")
+	}
+
+	reward.Output = w.Address()
+	if err := l.Apply(reward); err != nil {
+		t.Errorf("Couldn't pay to valid address: %s", err)
+	}
+}
@@ -0,0 +1,181 @@
+package store
+
+import (
+	"fmt"
+	"log"
+)
+
+// Schema changes made after the initial tables, applied in order. The
+// number applied so far is kept in sqlite's user_version, so entries must
+// only ever be appended.
+var migrations = []string{
+	`ALTER TABLE 'arach_transaction' ADD COLUMN 'htlc' TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE 'arach_transaction' ADD COLUMN 'preimage' TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE 'arach_transaction' ADD COLUMN 'lock_script' TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE 'arach_transaction' ADD COLUMN 'unlock_script' TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE 'arach_transaction' ADD COLUMN 'data' TEXT NOT NULL DEFAULT ''`,
+	`CREATE INDEX 'arach_transaction_data' ON 'arach_transaction' ('data')`,
+	`CREATE TABLE 'arach_seed' (
+    'seed' TEXT NOT NULL,
+    'created' DATE DEFAULT CURRENT_TIMESTAMP NOT NULL
+  )`,
+	// Keys from before the seed have an empty path
+	`ALTER TABLE 'arach_wallet' ADD COLUMN 'path' TEXT NOT NULL DEFAULT ''`,
+	// Seeds from before mnemonics have an empty phrase
+	`ALTER TABLE 'arach_seed' ADD COLUMN 'mnemonic' TEXT NOT NULL DEFAULT ''`,
+	`CREATE TABLE 'arach_encryption' (
+    'salt' TEXT NOT NULL,
+    'master_key' TEXT NOT NULL,
+    'scrypt_n' INT NOT NULL,
+    'scrypt_r' INT NOT NULL,
+    'scrypt_p' INT NOT NULL,
+    'created' DATE DEFAULT CURRENT_TIMESTAMP NOT NULL
+  )`,
+	// Keys and seeds from before named wallets belong to the default wallet
+	`ALTER TABLE 'arach_wallet' ADD COLUMN 'wallet' TEXT NOT NULL DEFAULT 'default'`,
+	`ALTER TABLE 'arach_wallet' ADD COLUMN 'label' TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE 'arach_seed' ADD COLUMN 'wallet' TEXT NOT NULL DEFAULT 'default'`,
+	`CREATE TABLE 'arach_setting' (
+    'name' TEXT PRIMARY KEY,
+    'value' TEXT NOT NULL
+  )`,
+	`ALTER TABLE 'arach_wallet' ADD COLUMN 'watch_only' BOOLEAN NOT NULL DEFAULT 0`,
+	`CREATE TABLE 'arach_policy' (
+    'wallet' TEXT PRIMARY KEY,
+    'daily_limit' INT NOT NULL,
+    'max_payment' INT NOT NULL,
+    'confirm_above' INT NOT NULL,
+    'allowed' TEXT NOT NULL
+  )`,
+	// Payments signed by wallets with policies, towards their daily limits
+	`CREATE TABLE 'arach_spend' (
+    'hash' TEXT PRIMARY KEY,
+    'wallet' TEXT NOT NULL,
+    'amount' INT NOT NULL,
+    'created' DATE DEFAULT CURRENT_TIMESTAMP NOT NULL
+  )`,
+	// Headers of blocks whose transactions haven't been fetched yet
+	`CREATE TABLE 'arach_header' (
+    'hash' TEXT PRIMARY KEY,
+    'previous' TEXT NOT NULL,
+    'work' INT NOT NULL,
+    'height' INT NOT NULL,
+    'created' DATE DEFAULT CURRENT_TIMESTAMP NOT NULL
+  )`,
+	`CREATE INDEX 'arach_header_previous' ON 'arach_header' ('previous')`,
+	// Addresses of peers, from connecting to them and from other peers
+	`CREATE TABLE 'arach_peer_address' (
+    'address' TEXT PRIMARY KEY,
+    'last_seen' INT NOT NULL,
+    'last_try' INT NOT NULL DEFAULT 0,
+    'last_success' INT NOT NULL DEFAULT 0,
+    'successes' INT NOT NULL DEFAULT 0,
+    'failures' INT NOT NULL DEFAULT 0,
+    'created' DATE DEFAULT CURRENT_TIMESTAMP NOT NULL
+  )`,
+	// Peer ips that can't connect until a time
+	`CREATE TABLE 'arach_ban' (
+    'address' TEXT PRIMARY KEY,
+    'until' INT NOT NULL,
+    'reason' TEXT NOT NULL,
+    'created' DATE DEFAULT CURRENT_TIMESTAMP NOT NULL
+  )`,
+	// A transaction can be in blocks on more than one chain, so they're keyed
+	// by block too. Tables from before that are rebuilt.
+	`CREATE TABLE 'arach_transaction_keyed' (
+    'hash' TEXT NOT NULL,
+    'input' TEXT NOT NULL,
+    'output' TEXT NOT NULL,
+    'amount' INT NOT NULL,
+    'signature' TEXT NOT NULL,
+    'unique_string' TEXT NOT NULL,
+    'order' INT NOT NULL,
+    'block' TEXT NOT NULL,
+    'block_height' INT NOT NULL,
+    'created' DATE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    'htlc' TEXT NOT NULL DEFAULT '',
+    'preimage' TEXT NOT NULL DEFAULT '',
+    'lock_script' TEXT NOT NULL DEFAULT '',
+    'unlock_script' TEXT NOT NULL DEFAULT '',
+    'data' TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY(hash, block)
+  );
+  INSERT INTO 'arach_transaction_keyed' SELECT hash, input, output, amount, signature, unique_string, "order",
+    block, block_height, created, htlc, preimage, lock_script, unlock_script, data FROM 'arach_transaction';
+  DROP TABLE 'arach_transaction';
+  ALTER TABLE 'arach_transaction_keyed' RENAME TO 'arach_transaction';
+  CREATE INDEX 'arach_transaction_data' ON 'arach_transaction' ('data')`,
+	// The hash at each height of the highest block's chain and of the longest
+	// chain of headers, so syncing doesn't have to walk back through them
+	`CREATE TABLE 'arach_main_chain' (
+    'height' INT PRIMARY KEY,
+    'hash' TEXT NOT NULL
+  );
+  CREATE INDEX 'arach_main_chain_hash' ON 'arach_main_chain' ('hash');
+  CREATE TABLE 'arach_header_chain' (
+    'height' INT PRIMARY KEY,
+    'hash' TEXT NOT NULL
+  );
+  CREATE INDEX 'arach_header_height' ON 'arach_header' ('height');
+  CREATE INDEX 'arach_block_height' ON 'arach_block' ('height')`,
+}
+
+// How transactions are hashed in the stored chain. It goes up whenever
+// hashes change, as blocks stored before then can't be checked, see
+// checkChainFormat.
+const chainFormat = "2"
+
+func migrate() {
+	var version int
+	err := Conn.QueryRow(`PRAGMA user_version`).Scan(&version)
+	if err != nil {
+		panic(err)
+	}
+
+	for version < len(migrations) {
+		log.Printf("Migrating database to version %d...", version+1)
+		// Each migration is applied with its version, so one that fails
+		// partway leaves the database as it was
+		tx, err := Conn.Begin()
+		if err != nil {
+			panic(err)
+		}
+		_, err = tx.Exec(migrations[version])
+		if err == nil {
+			// PRAGMA doesn't accept bound parameters
+			_, err = tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version+1))
+		}
+		if err != nil {
+			tx.Rollback()
+			panic(err)
+		}
+		version++
+		err = tx.Commit()
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Drops a chain stored before transaction hashes last changed, keeping the
+// wallets, so it's synced again from the genesis block
+func checkChainFormat() {
+	if fetchSetting("chain_format") == chainFormat {
+		return
+	}
+	var blocks int
+	err := Conn.QueryRow(`SELECT count(*) FROM arach_block`).Scan(&blocks)
+	if err != nil {
+		panic(err)
+	}
+	if blocks > 1 {
+		log.Printf("The stored chain uses old transaction hashes, dropping it to sync again")
+		for _, table := range []string{"arach_block", "arach_transaction", "arach_header", "arach_main_chain", "arach_header_chain"} {
+			_, err = Conn.Exec(`DELETE FROM ` + table)
+			if err != nil {
+				panic(err)
+			}
+		}
+	}
+	storeSetting("chain_format", chainFormat)
+}
@@ -0,0 +1,263 @@
+package store
+
+import (
+	"encoding/hex"
+	"errors"
+	"github.com/frankh/arachnacoin/crypto"
+	"github.com/frankh/arachnacoin/script"
+	"github.com/frankh/arachnacoin/transaction"
+	"golang.org/x/crypto/ed25519"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Partially signed transactions carry a transaction between the machines
+// that build, sign and broadcast it, so keys can stay on a machine that's
+// never online. They're passed around as JSON files. A partial holds
+// everything a signer needs to check what it's signing without the chain:
+// the transaction itself, the network, and for script inputs the locking
+// script. Signers sign the hash they compute themselves, never one given
+// to them.
+
+// Version of the partial transaction format
+const PartialVersion = 1
+
+type Partial struct {
+	Version     int                     `json:"version"`
+	Network     string                  `json:"network"`
+	Transaction transaction.Transaction `json:"transaction"`
+	Balance     uint64                  `json:"balance"`          // The input's balance when the partial was created
+	Lock        string                  `json:"lock,omitempty"`   // Hex locking script of a script input
+	Unlock      string                  `json:"unlock,omitempty"` // Unlocking script of a script input in its text form, see Signers
+	Signatures  map[string]string       `json:"signatures"`       // Hex signatures of the transaction hash by signing address
+}
+
+var sigPlaceholder = regexp.MustCompile(`<sig:([0-9a-z]+)>`)
+
+// Builds an unsigned partial paying amount from an address on the longest
+// chain. The address needn't be in a wallet. Script inputs need an
+// unlocking script with a <sig:address> in place of each signature.
+func NewPartial(from string, output string, amount uint64, data []byte, unlock string) (Partial, error) {
+	version, _, err := crypto.DecodeAddress(from)
+	if err != nil {
+		return Partial{}, err
+	}
+	p := Partial{
+		Version:    PartialVersion,
+		Network:    crypto.ActiveNetwork.Name,
+		Signatures: make(map[string]string),
+	}
+
+	switch version {
+	case crypto.PubKeyAddress:
+		if unlock != "" {
+			return Partial{}, errors.New("only script inputs have an unlocking script")
+		}
+		p.Balance = GetBalance(from)
+		if amount > p.Balance {
+			return Partial{}, errors.New("insufficient balance")
+		}
+		p.Transaction = transaction.Transaction{
+			Input:  from,
+			Output: output,
+			Amount: amount,
+			Unique: transaction.NewUnique(),
+			Data:   hex.EncodeToString(data),
+		}
+	case crypto.ScriptAddress:
+		if unlock == "" {
+			return Partial{}, errors.New("script inputs need an unlocking script")
+		}
+		if len(data) > 0 {
+			return Partial{}, errors.New("script spends can't carry data")
+		}
+		lock, balance, err := FetchScript(from)
+		if err != nil {
+			return Partial{}, err
+		}
+		p.Transaction, err = SpendScript(from, output, amount)
+		if err != nil {
+			return Partial{}, err
+		}
+		p.Balance = balance
+		p.Lock = hex.EncodeToString(lock)
+		p.Unlock = unlock
+	default:
+		return Partial{}, errors.New("contracts are spent with htlc claim and refund")
+	}
+
+	return p, p.Check()
+}
+
+// Returns the addresses whose signatures the transaction needs: the input
+// for a plain payment, or those in the <sig:address> placeholders of a
+// script input's unlocking script
+func (p *Partial) Signers() []string {
+	if p.Lock == "" {
+		return []string{p.Transaction.Input}
+	}
+	var signers []string
+	seen := make(map[string]bool)
+	for _, match := range sigPlaceholder.FindAllStringSubmatch(p.Unlock, -1) {
+		if !seen[match[1]] {
+			signers = append(signers, match[1])
+			seen[match[1]] = true
+		}
+	}
+	return signers
+}
+
+// Returns the signers that haven't signed yet
+func (p *Partial) Missing() []string {
+	var missing []string
+	for _, signer := range p.Signers() {
+		if _, ok := p.Signatures[signer]; !ok {
+			missing = append(missing, signer)
+		}
+	}
+	return missing
+}
+
+// Checks a partial is for this network, is well formed, and that its
+// signatures are valid
+func (p *Partial) Check() error {
+	if p.Version != PartialVersion {
+		return errors.New("unsupported partial transaction version")
+	}
+	if p.Network != crypto.ActiveNetwork.Name {
+		return errors.New("partial transaction is for the " + p.Network + " network")
+	}
+	t := p.Transaction
+	if _, _, err := crypto.DecodeAddress(t.Input); err != nil {
+		return errors.New("invalid input: " + err.Error())
+	}
+	if _, _, err := crypto.DecodeAddress(t.Output); err != nil {
+		return errors.New("invalid output: " + err.Error())
+	}
+	if t.HTLC != nil || t.Preimage != "" || t.Lock != "" || t.Unlock != "" {
+		return errors.New("partial transactions can only make payments")
+	}
+	if p.Lock != "" {
+		lock, err := hex.DecodeString(p.Lock)
+		if err != nil || transaction.ScriptAddress(lock) != t.Input {
+			return errors.New("locking script doesn't match the input")
+		}
+		// The unlocking script must assemble once signed
+		signed := sigPlaceholder.ReplaceAllString(p.Unlock, "0x"+strings.Repeat("00", ed25519.SignatureSize))
+		if _, err := script.Assemble(signed); err != nil {
+			return errors.New("invalid unlocking script: " + err.Error())
+		}
+	} else if p.Unlock != "" {
+		return errors.New("only script inputs have an unlocking script")
+	}
+
+	signers := make(map[string]bool)
+	for _, signer := range p.Signers() {
+		signers[signer] = true
+	}
+	for signer, signature := range p.Signatures {
+		if !signers[signer] {
+			return errors.New("signature from " + signer + " isn't needed")
+		}
+		if !verifyPartialSignature(signer, t.Hash(), signature) {
+			return errors.New("invalid signature from " + signer)
+		}
+	}
+	return nil
+}
+
+func verifyPartialSignature(address string, hash []byte, signature string) bool {
+	version, pubKey, err := crypto.DecodeAddress(address)
+	if err != nil || version != crypto.PubKeyAddress {
+		return false
+	}
+	sig, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(pubKey, hash, sig)
+}
+
+// Adds the signatures of any missing signers whose keys are in the
+// wallets, returning the addresses signed for. confirmed says whether the
+// payment was confirmed, for wallets whose policies require it. Fails
+// while the wallets are locked.
+func SignPartial(p *Partial, confirmed bool) ([]string, error) {
+	err := p.Check()
+	if err != nil {
+		return nil, err
+	}
+	if p.Signatures == nil {
+		p.Signatures = make(map[string]string)
+	}
+	var signed []string
+	for _, signer := range p.Missing() {
+		w := FetchWalletByAddress(signer)
+		if w == nil || (w.WatchOnly && ExternalSigner == nil) {
+			continue
+		}
+		if confirmed {
+			w = w.Confirmed()
+		}
+		signature, err := w.SignTransactionHash(&p.Transaction)
+		if err != nil {
+			return nil, err
+		}
+		p.Signatures[signer] = hex.EncodeToString(signature)
+		signed = append(signed, signer)
+	}
+	return signed, nil
+}
+
+// Merges the signatures of copies of the same partial transaction signed
+// separately
+func CombinePartials(partials []Partial) (Partial, error) {
+	if len(partials) == 0 {
+		return Partial{}, errors.New("nothing to combine")
+	}
+	combined := partials[0]
+	combined.Signatures = make(map[string]string)
+	for _, p := range partials {
+		err := p.Check()
+		if err != nil {
+			return Partial{}, err
+		}
+		if p.Transaction.HashString() != combined.Transaction.HashString() || p.Lock != combined.Lock || p.Unlock != combined.Unlock {
+			return Partial{}, errors.New("partials are for different transactions")
+		}
+		for signer, signature := range p.Signatures {
+			combined.Signatures[signer] = signature
+		}
+	}
+	return combined, nil
+}
+
+// Returns the signed transaction once every signer has signed
+func (p *Partial) Finalize() (transaction.Transaction, error) {
+	err := p.Check()
+	if err != nil {
+		return transaction.Transaction{}, err
+	}
+	missing := p.Missing()
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return transaction.Transaction{}, errors.New("missing signatures from " + strings.Join(missing, ", "))
+	}
+
+	t := p.Transaction
+	if p.Lock == "" {
+		t.Signature = p.Signatures[t.Input]
+		return t, nil
+	}
+	text := sigPlaceholder.ReplaceAllStringFunc(p.Unlock, func(placeholder string) string {
+		signer := sigPlaceholder.FindStringSubmatch(placeholder)[1]
+		return "0x" + p.Signatures[signer]
+	})
+	unlock, err := script.Assemble(text)
+	if err != nil {
+		return transaction.Transaction{}, err
+	}
+	t.Unlock = hex.EncodeToString(unlock)
+	return t, nil
+}
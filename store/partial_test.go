@@ -0,0 +1,129 @@
+package store
+
+import (
+	"encoding/json"
+	"github.com/frankh/arachnacoin/crypto"
+	"github.com/frankh/arachnacoin/script"
+	"github.com/frankh/arachnacoin/transaction"
+	"github.com/frankh/arachnacoin/work"
+	"testing"
+)
+
+// Opens a database holding only the given keys, like an offline signer
+func offlineSigner(keys ...Wallet) {
+	Init(":memory:")
+	for _, w := range keys {
+		w.WalletName = "cold"
+		StoreWallet(w)
+	}
+}
+
+// Passes a partial through JSON, as when it's copied between machines
+func copyPartial(p Partial) Partial {
+	contents, _ := json.Marshal(p)
+	var copied Partial
+	json.Unmarshal(contents, &copied)
+	return copied
+}
+
+func TestPartialPayment(t *testing.T) {
+	work.Difficulty = 0xff000000
+	cold := GenerateWallet()
+	Init(":memory:")
+	chain := Conn
+	mineOn(chain, nil, cold.Address())
+
+	if _, err := NewPartial(cold.Address(), MyWallet.Address(), GetBalance(cold.Address())+1, nil, ""); err == nil {
+		t.Errorf("Created a partial spending more than the balance")
+	}
+	p, err := NewPartial(cold.Address(), MyWallet.Address(), 10, []byte("rent"), "")
+	if err != nil {
+		t.Fatalf("Couldn't create partial: %s", err)
+	}
+	if _, err := p.Finalize(); err == nil {
+		t.Errorf("Finalized without signatures")
+	}
+
+	offlineSigner(cold)
+	signed := copyPartial(p)
+	if s, err := SignPartial(&signed, false); err != nil || len(s) != 1 || s[0] != cold.Address() {
+		t.Fatalf("Signing failed: %v %v", s, err)
+	}
+
+	// Changing the payment invalidates the signature
+	tampered := copyPartial(signed)
+	tampered.Transaction.Amount = 20
+	if tampered.Check() == nil {
+		t.Errorf("Tampered partial passed its check")
+	}
+
+	crypto.ActiveNetwork = crypto.RegTest
+	if signed.Check() == nil {
+		t.Errorf("Partial accepted on another network")
+	}
+	crypto.ActiveNetwork = crypto.MainNet
+
+	copied := copyPartial(signed)
+	final, err := copied.Finalize()
+	if err != nil {
+		t.Fatalf("Couldn't finalize: %s", err)
+	}
+	if !mineOn(chain, []transaction.Transaction{final}, cold.Address()) {
+		t.Fatalf("Signed partial not accepted")
+	}
+}
+
+func TestPartialScriptSpend(t *testing.T) {
+	work.Difficulty = 0xff000000
+	alice := GenerateWallet()
+	bob := GenerateWallet()
+	Init(":memory:")
+	chain := Conn
+	mineOn(chain, nil, alice.Address())
+
+	// Needs both Alice and Bob to sign
+	lock := script.AddData(nil, alice.PublicKey)
+	lock = append(lock, script.OP_CHECKSIGVERIFY)
+	lock = append(lock, script.PayToPubKey(bob.PublicKey)...)
+	pay, _ := alice.PayToScript(lock, 1000)
+	mineOn(chain, []transaction.Transaction{pay}, alice.Address())
+
+	unlock := "<sig:" + bob.Address() + "> <sig:" + alice.Address() + ">"
+	p, err := NewPartial(pay.Output, bob.Address(), 1000, nil, unlock)
+	if err != nil {
+		t.Fatalf("Couldn't create partial: %s", err)
+	}
+	other, _ := NewPartial(alice.Address(), bob.Address(), 1, nil, "")
+	if len(p.Signers()) != 2 {
+		t.Errorf("Unexpected signers %v", p.Signers())
+	}
+
+	// Each signs on their own machine
+	offlineSigner(alice)
+	fromAlice := copyPartial(p)
+	SignPartial(&fromAlice, false)
+	offlineSigner(bob)
+	fromBob := copyPartial(p)
+	SignPartial(&fromBob, false)
+
+	if _, err := fromAlice.Finalize(); err == nil {
+		t.Errorf("Finalized with a signature missing")
+	}
+	if _, err := CombinePartials([]Partial{fromAlice, other}); err == nil {
+		t.Errorf("Combined different transactions")
+	}
+	combined, err := CombinePartials([]Partial{fromAlice, fromBob})
+	if err != nil {
+		t.Fatalf("Combine failed: %s", err)
+	}
+	final, err := combined.Finalize()
+	if err != nil {
+		t.Fatalf("Couldn't finalize: %s", err)
+	}
+	if !mineOn(chain, []transaction.Transaction{final}, alice.Address()) {
+		t.Fatalf("Combined partial not accepted")
+	}
+	if GetBalance(bob.Address()) != 1000 {
+		t.Errorf("Script spend not paid")
+	}
+}
@@ -0,0 +1,154 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/frankh/arachnacoin/crypto"
+	"github.com/frankh/arachnacoin/transaction"
+	"strings"
+	"sync"
+)
+
+// Spending policies put limits on what a wallet's keys will sign. They're
+// checked whenever a key signs a payment from its own address, however
+// the payment was built, and payments between a wallet's own addresses
+// are exempt. Amounts of zero mean no limit.
+type Policy struct {
+	DailyLimit   uint64   // Most the wallet can spend in 24 hours
+	MaxPayment   uint64   // Most a single payment can be
+	ConfirmAbove uint64   // Payments over this need confirming, see Wallet.Confirmed
+	Allowed      []string // Addresses payments can go to, any if empty
+}
+
+func FetchPolicy(name string) Policy {
+	var policy Policy
+	var allowed string
+	err := Conn.QueryRow(`SELECT daily_limit, max_payment, confirm_above, allowed FROM arach_policy WHERE wallet=?`, name).Scan(
+		&policy.DailyLimit, &policy.MaxPayment, &policy.ConfirmAbove, &allowed)
+	if err == sql.ErrNoRows {
+		return policy
+	}
+	if err != nil {
+		panic(err)
+	}
+	policy.Allowed = strings.Fields(allowed)
+	return policy
+}
+
+// Replaces a wallet's policy. Policies guard an encrypted wallet while
+// it's unlocked, so changing one takes the passphrase if it's locked.
+func SetPolicy(name string, policy Policy, passphrase string) error {
+	if len(FetchWallets(name)) == 0 {
+		return errors.New("no wallet named " + name)
+	}
+	if IsLocked() {
+		if passphrase == "" {
+			return ErrWalletLocked
+		}
+		if _, err := openMasterKey(passphrase); err != nil {
+			return err
+		}
+	}
+	for _, address := range policy.Allowed {
+		if _, _, err := crypto.DecodeAddress(address); err != nil {
+			return errors.New("invalid address " + address + ": " + err.Error())
+		}
+	}
+	_, err := Conn.Exec(`INSERT OR REPLACE INTO arach_policy (wallet, daily_limit, max_payment, confirm_above, allowed) values (?, ?, ?, ?, ?)`,
+		name, policy.DailyLimit, policy.MaxPayment, policy.ConfirmAbove, strings.Join(policy.Allowed, " "))
+	if err != nil {
+		panic(err)
+	}
+	return nil
+}
+
+// Held from checking a payment against its wallet's policy until it's
+// counted
+var policyLock sync.Mutex
+
+// Returns how much a wallet has signed payments for in the last 24 hours
+func SpentToday(name string) uint64 {
+	var spent uint64
+	err := Conn.QueryRow(`SELECT coalesce(sum(amount), 0) FROM arach_spend WHERE wallet=? AND created > datetime('now', '-1 day')`, name).Scan(&spent)
+	if err != nil {
+		panic(err)
+	}
+	return spent
+}
+
+// Returns whether a transaction is a payment from the key to outside its
+// wallet
+func (w *Wallet) policyApplies(t *transaction.Transaction) bool {
+	if w.WalletName == "" || t.Input != w.Address() {
+		return false
+	}
+	own := FetchWalletByAddress(t.Output)
+	return own == nil || own.WalletName != w.WalletName
+}
+
+// Checks a payment from one of the wallet's keys against its policy
+func (w *Wallet) checkPolicy(t *transaction.Transaction) error {
+	if !w.policyApplies(t) {
+		return nil
+	}
+	policy := FetchPolicy(w.WalletName)
+	amount := transaction.FormatAmount(t.Amount)
+
+	if len(policy.Allowed) > 0 && !contains(policy.Allowed, t.Output) {
+		return errors.New(t.Output + " isn't on wallet " + w.WalletName + "'s allowed addresses")
+	}
+	if policy.MaxPayment > 0 && t.Amount > policy.MaxPayment {
+		return errors.New("payment of " + amount + " is over wallet " + w.WalletName +
+			"'s limit of " + transaction.FormatAmount(policy.MaxPayment) + " per payment")
+	}
+	if policy.DailyLimit > 0 {
+		spent, err := transaction.AddAmounts(SpentToday(w.WalletName), t.Amount)
+		if err != nil || spent > policy.DailyLimit {
+			return errors.New("payment of " + amount + " would take wallet " + w.WalletName +
+				"'s spending in the last 24 hours over its limit of " + transaction.FormatAmount(policy.DailyLimit))
+		}
+	}
+	if policy.ConfirmAbove > 0 && t.Amount > policy.ConfirmAbove && !w.confirmed {
+		return errors.New("payment of " + amount + " is over wallet " + w.WalletName + "'s confirmation threshold of " +
+			transaction.FormatAmount(policy.ConfirmAbove) + " and needs confirming")
+	}
+	return nil
+}
+
+// Counts a signed payment towards the wallet's daily limit
+func (w *Wallet) recordSpend(t *transaction.Transaction) {
+	if !w.policyApplies(t) {
+		return
+	}
+	_, err := Conn.Exec(`INSERT OR IGNORE INTO arach_spend (hash, wallet, amount) values (?, ?, ?)`,
+		t.HashString(), w.WalletName, t.Amount)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Stops counting a signed payment towards its wallet's daily limit, for
+// payments the node refused
+func ForgetSpend(hash string) {
+	_, err := Conn.Exec(`DELETE FROM arach_spend WHERE hash=?`, hash)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Returns a copy of the key that's allowed to sign payments over its
+// wallet's confirmation threshold
+func (w *Wallet) Confirmed() *Wallet {
+	confirmed := *w
+	confirmed.confirmed = true
+	return &confirmed
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
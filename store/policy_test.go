@@ -0,0 +1,129 @@
+package store
+
+import (
+	"github.com/frankh/arachnacoin/work"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSpendingPolicy(t *testing.T) {
+	work.Difficulty = 0xff000000
+	Init(":memory:")
+	student := *MyWallet
+	teacher := GenerateWallet()
+	canteen := GenerateWallet()
+	own, _ := NewAddress(DefaultWalletName, 0)
+
+	if SetPolicy("missing", Policy{}, "") == nil {
+		t.Errorf("Set a policy for a missing wallet")
+	}
+	if SetPolicy(DefaultWalletName, Policy{Allowed: []string{"nowhere"}}, "") == nil {
+		t.Errorf("Allowed an invalid address")
+	}
+	err := SetPolicy(DefaultWalletName, Policy{
+		DailyLimit:   100,
+		MaxPayment:   60,
+		ConfirmAbove: 40,
+		Allowed:      []string{canteen.Address()},
+	}, "")
+	if err != nil {
+		t.Fatalf("Setting policy failed: %s", err)
+	}
+	if p := FetchPolicy(DefaultWalletName); p.DailyLimit != 100 || len(p.Allowed) != 1 {
+		t.Errorf("Policy not stored: %+v", p)
+	}
+
+	refused := func(output string, amount uint64, reason string) {
+		_, err := student.Send(output, amount, nil)
+		if err == nil || !strings.Contains(err.Error(), reason) {
+			t.Errorf("Payment of %d not refused for %q: %v", amount, reason, err)
+		}
+	}
+	refused(teacher.Address(), 1, "allowed addresses")
+	refused(canteen.Address(), 61, "per payment")
+	refused(canteen.Address(), 41, "needs confirming")
+
+	if _, err := student.Confirmed().Send(canteen.Address(), 50, nil); err != nil {
+		t.Errorf("Confirmed payment refused: %s", err)
+	}
+	if _, err := student.Send(canteen.Address(), 40, nil); err != nil {
+		t.Errorf("Payment within policy refused: %s", err)
+	}
+	if SpentToday(DefaultWalletName) != 90 {
+		t.Errorf("Spent %d today", SpentToday(DefaultWalletName))
+	}
+	refused(canteen.Address(), 11, "last 24 hours")
+
+	// Moving funds within the wallet isn't spending
+	if _, err := student.Send(own.Address(), 1000, nil); err != nil {
+		t.Errorf("Payment to own address refused: %s", err)
+	}
+	if SpentToday(DefaultWalletName) != 90 {
+		t.Errorf("Payment to own address counted")
+	}
+
+	// Other wallets aren't affected
+	CreateWallet("teacher")
+	other, _ := FetchWallet("teacher")
+	if _, err := other.Send(teacher.Address(), 1000, nil); err != nil {
+		t.Errorf("Other wallet's payment refused: %s", err)
+	}
+}
+
+// Payments signed at once can't together go over the daily limit
+func TestSpendingPolicyConcurrent(t *testing.T) {
+	work.Difficulty = 0xff000000
+	Init(":memory:")
+	wallet := *MyWallet
+	shop := GenerateWallet()
+	SetPolicy(DefaultWalletName, Policy{DailyLimit: 100}, "")
+
+	results := make(chan error)
+	for i := 0; i < 4; i++ {
+		go func() {
+			_, err := wallet.Send(shop.Address(), 60, nil)
+			results <- err
+		}()
+	}
+	signed := 0
+	for i := 0; i < 4; i++ {
+		if <-results == nil {
+			signed++
+		}
+	}
+	if signed != 1 || SpentToday(DefaultWalletName) != 60 {
+		t.Errorf("Signed %d payments, spending %d", signed, SpentToday(DefaultWalletName))
+	}
+
+	// Payments the node refuses don't count
+	payment, _ := wallet.Send(shop.Address(), 40, nil)
+	ForgetSpend(payment.HashString())
+	if SpentToday(DefaultWalletName) != 60 {
+		t.Errorf("Forgotten payment still counted")
+	}
+}
+
+// A locked wallet's policy can only be changed with its passphrase
+func TestLockedPolicy(t *testing.T) {
+	Init(":memory:")
+	defer Lock()
+	if err := EncryptWallet("correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	loose := Policy{}
+	if SetPolicy(DefaultWalletName, loose, "") != ErrWalletLocked {
+		t.Errorf("Changed a locked wallet's policy")
+	}
+	if SetPolicy(DefaultWalletName, loose, "wrong horse") != ErrWrongPassphrase {
+		t.Errorf("Changed a policy with the wrong passphrase")
+	}
+	if err := SetPolicy(DefaultWalletName, Policy{MaxPayment: 1}, "correct horse"); err != nil {
+		t.Errorf("Couldn't change a policy with the passphrase: %s", err)
+	}
+
+	Unlock("correct horse", time.Minute)
+	if err := SetPolicy(DefaultWalletName, loose, ""); err != nil {
+		t.Errorf("Couldn't change an unlocked wallet's policy: %s", err)
+	}
+}
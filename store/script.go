@@ -0,0 +1,55 @@
+package store
+
+import (
+	"encoding/hex"
+	"errors"
+	"github.com/frankh/arachnacoin/transaction"
+)
+
+// Builds a signed transaction paying amount from the wallet to a locking
+// script. The script's address is the transaction output.
+func (w *Wallet) PayToScript(lock []byte, amount uint64) (transaction.Transaction, error) {
+	t := transaction.Transaction{
+		Input:  w.Address(),
+		Output: transaction.ScriptAddress(lock),
+		Amount: amount,
+		Unique: transaction.NewUnique(),
+		Lock:   hex.EncodeToString(lock),
+	}
+	err := w.Sign(&t)
+	return t, err
+}
+
+// Builds a transaction spending from a script address. The caller must
+// set Unlock, usually including signatures of the transaction's hash.
+func SpendScript(address string, output string, amount uint64) (transaction.Transaction, error) {
+	_, balance, err := FetchScript(address)
+	if err != nil {
+		return transaction.Transaction{}, err
+	}
+	if amount > balance {
+		return transaction.Transaction{}, errors.New("insufficient balance")
+	}
+
+	return transaction.Transaction{
+		Input:     address,
+		Output:    output,
+		Amount:    amount,
+		Signature: "unsigned",
+		Unique:    transaction.NewUnique(),
+	}, nil
+}
+
+// Looks up the locking script at address and its balance on the longest
+// chain
+func FetchScript(address string) ([]byte, uint64, error) {
+	l, err := LedgerAt(FetchHighestBlock())
+	if err != nil {
+		return nil, 0, err
+	}
+	lock := l.Script(address)
+	if lock == nil {
+		return nil, 0, errors.New("unknown script")
+	}
+	return lock, l.Balance(address), nil
+}
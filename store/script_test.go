@@ -0,0 +1,57 @@
+package store
+
+import (
+	"encoding/hex"
+	"github.com/frankh/arachnacoin/script"
+	"github.com/frankh/arachnacoin/transaction"
+	"github.com/frankh/arachnacoin/work"
+	"testing"
+)
+
+func TestScriptSpend(t *testing.T) {
+	work.Difficulty = 0xff000000
+	alice := GenerateWallet()
+	bob := GenerateWallet()
+	Init(":memory:")
+	chain := Conn
+	mineOn(chain, nil, alice.Address())
+
+	// Pays to Bob, but not until height 4
+	lock := script.AddNumber(nil, 4)
+	lock = append(lock, script.OP_CHECKHEIGHTVERIFY)
+	lock = append(lock, script.PayToPubKey(bob.PublicKey)...)
+
+	pay, _ := alice.PayToScript(lock, 1000)
+	if !mineOn(chain, []transaction.Transaction{pay}, alice.Address()) {
+		t.Fatalf("Failed to pay to script")
+	}
+
+	spend, err := SpendScript(pay.Output, bob.Address(), 1000)
+	if err != nil {
+		t.Fatalf("Couldn't build spend: %s", err)
+	}
+	signature, _ := bob.SignTransactionHash(&spend)
+	spend.Unlock = hex.EncodeToString(script.AddData(nil, signature))
+	if mineOn(chain, []transaction.Transaction{spend}, alice.Address()) {
+		t.Errorf("Spent script before its height")
+	}
+	b := work.Mine(FetchHighestBlock(), []transaction.Transaction{spend}, alice.Address())
+	b.Height = 4
+	if ValidateBlock(b) {
+		t.Errorf("Spent script in a block with a made up height")
+	}
+
+	mineOn(chain, nil, alice.Address())
+	stolen := spend
+	stolen.Output = alice.Address()
+	if mineOn(chain, []transaction.Transaction{stolen}, alice.Address()) {
+		t.Errorf("Spent script with a signature for a different output")
+	}
+
+	if !mineOn(chain, []transaction.Transaction{spend}, alice.Address()) {
+		t.Fatalf("Failed to spend script")
+	}
+	if GetBalance(bob.Address()) != 1000 {
+		t.Errorf("Script spend not paid")
+	}
+}
@@ -0,0 +1,123 @@
+package store
+
+import (
+	"encoding/hex"
+	"errors"
+	"github.com/frankh/arachnacoin/crypto"
+	"github.com/frankh/arachnacoin/transaction"
+	"golang.org/x/crypto/ed25519"
+)
+
+// Wallets sign through a Signer. Keys in the database sign in process
+// with a LocalSigner. Watch-only keys sign with ExternalSigner when one is
+// set, a separate process holding the private keys, see the signer
+// package.
+
+// A request to sign a hash with the key for an address
+type SignRequest struct {
+	Address     string                   `json:"address"`
+	Hash        string                   `json:"hash"`                  // Hex hash to sign
+	Transaction *transaction.Transaction `json:"transaction,omitempty"` // The transaction Hash is of, if any, to show before signing
+	Message     string                   `json:"message,omitempty"`     // Or the message it's of, see crypto.MessageHash
+}
+
+type Signer interface {
+	Sign(request SignRequest) ([]byte, error)
+	// Returns the addresses of the keys the signer holds
+	Addresses() ([]string, error)
+}
+
+// Asked before each signature, returning whether to sign
+type ConfirmFunc func(request SignRequest) bool
+
+var ErrSignRefused = errors.New("signing refused")
+
+// Signs for watch-only keys. Nil if their keys can't be signed with here.
+var ExternalSigner Signer
+
+// Signs with a key whose private key is in this process
+type LocalSigner struct {
+	Key     *Wallet
+	Confirm ConfirmFunc // Asked before each signature if set
+}
+
+func (s *LocalSigner) Sign(request SignRequest) ([]byte, error) {
+	hash, err := request.check()
+	if err != nil {
+		return nil, err
+	}
+	if s.Key.WatchOnly || request.Address != s.Key.Address() {
+		return nil, errors.New("no key for " + request.Address)
+	}
+	if s.Confirm != nil && !s.Confirm(request) {
+		return nil, ErrSignRefused
+	}
+	return s.Key.signLocally(hash)
+}
+
+func (s *LocalSigner) Addresses() ([]string, error) {
+	if s.Key.WatchOnly {
+		return nil, nil
+	}
+	return []string{s.Key.Address()}, nil
+}
+
+// Decodes the hash to sign, checking it's the transaction's or message's
+// if there is one
+func (r *SignRequest) check() ([]byte, error) {
+	hash, err := hex.DecodeString(r.Hash)
+	if err != nil {
+		return nil, errors.New("hash is not hex")
+	}
+	if r.Transaction != nil && r.Message != "" {
+		return nil, errors.New("can't sign a transaction and a message at once")
+	}
+	if r.Transaction != nil && r.Transaction.HashString() != r.Hash {
+		return nil, errors.New("hash doesn't match the transaction")
+	}
+	if r.Message != "" && hex.EncodeToString(crypto.MessageHash([]byte(r.Message))) != r.Hash {
+		return nil, errors.New("hash doesn't match the message")
+	}
+	return hash, nil
+}
+
+func (w *Wallet) signer() (Signer, error) {
+	if !w.WatchOnly {
+		return &LocalSigner{Key: w}, nil
+	}
+	if ExternalSigner == nil {
+		return nil, ErrWatchOnly
+	}
+	return ExternalSigner, nil
+}
+
+// Signs through the wallet's signer, checking the signature it gives back.
+// Payments are checked against the wallet's spending policy.
+func (w *Wallet) sign(request SignRequest) ([]byte, error) {
+	signer, err := w.signer()
+	if err != nil {
+		return nil, err
+	}
+	// Payments are checked and counted one at a time, so two at once can't
+	// both fit under the daily limit
+	policyLock.Lock()
+	defer policyLock.Unlock()
+	if request.Transaction != nil {
+		err = w.checkPolicy(request.Transaction)
+		if err != nil {
+			return nil, err
+		}
+	}
+	signature, err := signer.Sign(request)
+	if err != nil {
+		return nil, err
+	}
+	hash, _ := hex.DecodeString(request.Hash)
+	if !ed25519.Verify(w.PublicKey, hash, signature) {
+		return nil, errors.New("signer returned an invalid signature")
+	}
+	if request.Transaction != nil {
+		w.recordSpend(request.Transaction)
+	}
+	return signature, nil
+}
@@ -2,8 +2,12 @@ package store
 
 import (
 	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"github.com/frankh/arachnacoin/block"
 	"github.com/frankh/arachnacoin/transaction"
+	"github.com/frankh/arachnacoin/wire"
 	"github.com/frankh/arachnacoin/work"
 	_ "github.com/mattn/go-sqlite3"
 	"log"
@@ -12,7 +16,22 @@ import (
 
 var Conn *sql.DB
 
+// Opens the database and loads the default wallet, creating both if
+// needed
 func Init(path string) {
+	Open(path)
+	if len(WalletNames()) == 0 {
+		err := CreateWallet(DefaultWalletName)
+		if err != nil {
+			panic(err)
+		}
+	}
+	loadMyWallet()
+}
+
+// Opens the database, creating the schema and genesis block if needed,
+// without touching the wallet
+func Open(path string) {
 	var err error
 	Conn, err = sql.Open("sqlite3", path)
 	if err != nil {
@@ -46,7 +65,7 @@ func Init(path string) {
 		}
 		prep, err = Conn.Prepare(`
       CREATE TABLE 'arach_transaction' (
-        'hash' TEXT PRIMARY KEY,
+        'hash' TEXT NOT NULL,
         'input' TEXT NOT NULL,
         'output' TEXT NOT NULL,
         'amount' INT NOT NULL,
@@ -55,7 +74,8 @@ func Init(path string) {
         'order' INT NOT NULL,
         'block' TEXT NOT NULL,
         'block_height' INT NOT NULL,
-        'created' DATE DEFAULT CURRENT_TIMESTAMP NOT NULL
+        'created' DATE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+        PRIMARY KEY(hash, block)
       );
       FOREIGN KEY(block) REFERENCES block(hash)
     `)
@@ -82,6 +102,9 @@ func Init(path string) {
 		}
 	}
 	table_check.Close()
+	migrate()
+	checkChainFormat()
+
 	rows, err := Conn.Query(`SELECT hash FROM arach_block WHERE hash=?`, block.GenesisBlock.HashString())
 	if err != nil {
 		panic(err)
@@ -91,18 +114,21 @@ func Init(path string) {
 		StoreBlock(block.GenesisBlock)
 	}
 	rows.Close()
-
-	w := FetchWallet()
-	MyWallet = &w
+	// Chains from before they were recorded are recorded in full
+	recordChains()
 }
 
 func StoreWallet(w Wallet) {
 	prep, err := Conn.Prepare(`
     INSERT INTO arach_wallet (
       public_key,
-      private_key
+      private_key,
+      path,
+      wallet,
+      label,
+      watch_only
     ) values (
-      ?,?
+      ?,?,?,?,?,?
     )
   `)
 
@@ -110,10 +136,22 @@ func StoreWallet(w Wallet) {
 		panic(err)
 	}
 
-	pub, priv := w.KeyStrings()
+	// Callers needing the seed to derive w have already checked the wallet
+	// is unlocked
+	priv := ""
+	if !w.WatchOnly {
+		priv, err = sealSecret(w.PrivateKey, keyLabel(w))
+		if err != nil {
+			panic(err)
+		}
+	}
 	_, err = prep.Exec(
-		pub,
+		hex.EncodeToString(w.PublicKey),
 		priv,
+		w.Path,
+		w.WalletName,
+		w.Label,
+		w.WatchOnly,
 	)
 
 	if err != nil {
@@ -122,39 +160,85 @@ func StoreWallet(w Wallet) {
 
 }
 
-func FetchWallet() Wallet {
+// Returns the first key of a named wallet, which is where its change and
+// refunds go
+func FetchWallet(name string) (Wallet, error) {
+	wallets := FetchWallets(name)
+	if len(wallets) == 0 {
+		return Wallet{}, errors.New("no wallet named " + name)
+	}
+	return wallets[0], nil
+}
+
+// Returns every key in a named wallet, in the order they were added
+func FetchWallets(name string) []Wallet {
+	return fetchKeys(`WHERE wallet=?`, name)
+}
+
+// Returns keys from every wallet matching the where clause, in the order
+// they were added
+func fetchKeys(where string, args ...interface{}) []Wallet {
 	if Conn == nil {
 		panic("Database connection not initialised")
 	}
 
+	encrypted := IsEncrypted()
 	rows, err := Conn.Query(`SELECT
     public_key,
-    private_key
-  FROM arach_wallet`)
-	defer rows.Close()
+    private_key,
+    path,
+    wallet,
+    label,
+    watch_only
+  FROM arach_wallet `+where+`
+  ORDER BY rowid asc`, args...)
 	if err != nil {
 		panic(err)
 	}
+	defer rows.Close()
 
-	if !rows.Next() {
-		w := GenerateWallet()
-		rows.Close()
-		StoreWallet(w)
-		return w
-	}
+	var wallets []Wallet
+	for rows.Next() {
+		var private_key string
+		var public_key string
+		var path string
+		var name string
+		var label string
+		var watchOnly bool
 
-	var private_key string
-	var public_key string
+		err = rows.Scan(
+			&public_key,
+			&private_key,
+			&path,
+			&name,
+			&label,
+			&watchOnly,
+		)
+		if err != nil {
+			panic("Couldn't get wallet")
+		}
 
-	err = rows.Scan(
-		&public_key,
-		&private_key,
-	)
-	if err != nil {
-		panic("Couldn't get wallet")
+		var w Wallet
+		if watchOnly {
+			w = FromKeyStrings(public_key, "")
+			w.PrivateKey = nil
+			w.WatchOnly = true
+		} else if encrypted {
+			w = FromKeyStrings(public_key, "")
+			w.PrivateKey = nil
+			w.sealedKey, err = hex.DecodeString(private_key)
+			if err != nil {
+				panic("Invalid private key")
+			}
+		} else {
+			w = FromKeyStrings(public_key, private_key)
+		}
+		w.Path = path
+		w.WalletName = name
+		w.Label = label
+		wallets = append(wallets, w)
 	}
-
-	return FromKeyStrings(public_key, private_key)
+	return wallets
 }
 
 func FetchHighestBlock() block.Block {
@@ -227,10 +311,10 @@ func blockFromRows(rows *sql.Rows) block.Block {
 	rows.Close()
 
 	block := block.Block{
-		previous,
-		work,
-		height,
-		FetchBlockTransactions(hash),
+		Previous:     previous,
+		Work:         work,
+		Height:       height,
+		Transactions: FetchBlockTransactions(hash),
 	}
 
 	if block.HashString() != hash {
@@ -246,7 +330,14 @@ func StoreBlock(b block.Block) {
 		return
 	}
 
-	prep, err := Conn.Prepare(`
+	// The block and its transactions are stored together, so a block is never
+	// read back before its transactions are in
+	tx, err := Conn.Begin()
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = tx.Exec(`
     INSERT INTO arach_block (
       hash,
       height,
@@ -255,13 +346,7 @@ func StoreBlock(b block.Block) {
     ) values (
       ?,?,?,?
     )
-  `)
-
-	if err != nil {
-		panic(err)
-	}
-
-	_, err = prep.Exec(
+  `,
 		b.HashString(),
 		b.Height,
 		b.Previous,
@@ -272,17 +357,34 @@ func StoreBlock(b block.Block) {
 		panic(err)
 	}
 
-	StoreTransactions(b, b.Transactions)
-}
+	for n, t := range b.Transactions {
+		storeTransaction(tx, b, n, t)
+	}
+
+	// The block's header no longer needs to wait for it
+	_, err = tx.Exec(`DELETE FROM arach_header WHERE hash=?`, b.HashString())
+	if err != nil {
+		panic(err)
+	}
 
-func StoreTransactions(b block.Block, ts []transaction.Transaction) {
-	for n, t := range ts {
-		StoreTransaction(b, n, t)
+	err = tx.Commit()
+	if err != nil {
+		panic(err)
 	}
+	recordChains()
 }
 
-func StoreTransaction(b block.Block, order int, t transaction.Transaction) {
-	prep, err := Conn.Prepare(`
+func storeTransaction(tx *sql.Tx, b block.Block, order int, t transaction.Transaction) {
+	htlc := ""
+	if t.HTLC != nil {
+		htlcJson, err := json.Marshal(t.HTLC)
+		if err != nil {
+			panic(err)
+		}
+		htlc = string(htlcJson)
+	}
+
+	_, err := tx.Exec(`
     INSERT INTO arach_transaction (
       hash,
       input,
@@ -290,25 +392,29 @@ func StoreTransaction(b block.Block, order int, t transaction.Transaction) {
       amount,
       signature,
       'unique_string',
+      htlc,
+      preimage,
+      lock_script,
+      unlock_script,
+      data,
       'order',
       block,
       block_height
     ) values (
-      ?,?,?,?,?,?,?,?,?
+      ?,?,?,?,?,?,?,?,?,?,?,?,?,?
     )
-  `)
-
-	if err != nil {
-		panic(err)
-	}
-
-	_, err = prep.Exec(
+  `,
 		t.HashString(),
 		t.Input,
 		t.Output,
 		t.Amount,
 		t.Signature,
 		t.Unique,
+		htlc,
+		t.Preimage,
+		t.Lock,
+		t.Unlock,
+		t.Data,
 		order,
 		b.HashString(),
 		b.Height,
@@ -319,8 +425,21 @@ func StoreTransaction(b block.Block, order int, t transaction.Transaction) {
 	}
 }
 
+// Columns selected by every transaction query, in the order
+// transactionsFromRows scans them.
+const transactionColumns = `
+      input,
+      output,
+      amount,
+      signature,
+      unique_string,
+      htlc,
+      preimage,
+      lock_script,
+      unlock_script,
+      data`
+
 func FetchTransactionsForAccount(account string) []transaction.Transaction {
-	results := make([]transaction.Transaction, 0)
 	if Conn == nil {
 		panic("Database connection not initialised")
 	}
@@ -332,43 +451,14 @@ func FetchTransactionsForAccount(account string) []transaction.Transaction {
 		queryArgs = append(queryArgs, hash)
 	}
 
-	rows, err := Conn.Query(`SELECT
-      input,
-      output,
-      amount,
-      signature,
-      unique_string
-    FROM 'arach_transaction' WHERE (input=? OR output=?) AND block in (`+strings.Join(strings.Split(strings.Repeat("?", len(queryArgs)-2), ""), ",")+`) ORDER BY 'block_height' asc, 'order' asc`, queryArgs...)
-	defer rows.Close()
-
+	rows, err := Conn.Query(`SELECT`+transactionColumns+`
+    FROM 'arach_transaction' WHERE (input=? OR output=?) AND block in (`+strings.Join(strings.Split(strings.Repeat("?", len(queryArgs)-2), ""), ",")+`) ORDER BY block_height asc, "order" asc`, queryArgs...)
 	if err != nil {
 		panic(err)
 	}
+	defer rows.Close()
 
-	for rows.Next() {
-		var input string
-		var output string
-		var amount uint32
-		var signature string
-		var unique string
-
-		err = rows.Scan(
-			&input,
-			&output,
-			&amount,
-			&signature,
-			&unique,
-		)
-		results = append(results, transaction.Transaction{
-			input,
-			output,
-			amount,
-			signature,
-			unique,
-		})
-	}
-
-	return results
+	return transactionsFromRows(rows)
 }
 
 func ValidateBlock(b block.Block) bool {
@@ -389,12 +479,28 @@ func ValidateBlock(b block.Block) bool {
 		// for the correct blockreward amount.
 		hasReward := false
 		for _, t := range b.Transactions {
-			if t.Input == "blockReward" && (hasReward || t.Amount != block.BlockReward) {
-				log.Printf("Bad reward")
-				return false
+			if t.Input == "blockReward" {
+				if hasReward || t.Amount != block.BlockReward {
+					log.Printf("Bad reward")
+					return false
+				}
+				hasReward = true
 			}
 		}
 
+		// Contracts and scripts go by the height, which isn't covered by the
+		// hash or the work, so it's checked as the header's is, whether or
+		// not the header came first
+		if err := ValidateHeader(b.Header()); err != nil {
+			log.Printf("Bad header: %s", err)
+			return false
+		}
+
+		if wire.BlockSize(&b) > block.MaxBlockSize {
+			log.Printf("Block too big")
+			return false
+		}
+
 		// Get list of block hashes back to genesis
 		hashChain := GetBlockHashChain(&b)
 		// Missing link in the chain - this is an invalid block
@@ -405,8 +511,19 @@ func ValidateBlock(b block.Block) bool {
 		}
 
 		// Finally, check the transactions from genesis to
-		// now all make sense.
-		return VerifyTransactionsInChain(hashChain)
+		// now all make sense. The block itself isn't stored yet
+		// so is applied on top.
+		l, err := ledgerForHashChain(hashChain[1:])
+		if err != nil {
+			log.Printf("Bad chain: %s", err)
+			return false
+		}
+		err = l.ApplyBlock(b)
+		if err != nil {
+			log.Printf("Bad transaction: %s", err)
+			return false
+		}
+		return true
 	}
 }
 
@@ -424,30 +541,17 @@ func GetBlockHashChain(b *block.Block) []string {
 }
 
 func VerifyTransactionsInChain(blockHashes []string) bool {
-	ts := GetTransactionsForHashes(blockHashes)
-	balances := make(map[string]uint32)
-
-	for _, t := range ts {
-		// Assume Blockrewards are valid. These should be checked
-		// in the block itself.
-		if t.Input == "blockReward" {
-			continue
-		}
-
-		if t.Amount > balances[t.Input] {
-			log.Printf("Bad amount")
-			return false
-		}
-		balances[t.Input] -= t.Amount
-		balances[t.Output] += t.Amount
+	_, err := ledgerForHashChain(blockHashes)
+	if err != nil {
+		log.Printf("Bad chain: %s", err)
+		return false
 	}
 	return true
 }
 
 func GetTransactionsForHashes(blockHashes []string) []transaction.Transaction {
-	results := make([]transaction.Transaction, 0)
 	if len(blockHashes) == 0 {
-		return results
+		return make([]transaction.Transaction, 0)
 	}
 	if Conn == nil {
 		panic("Database connection not initialised")
@@ -458,85 +562,81 @@ func GetTransactionsForHashes(blockHashes []string) []transaction.Transaction {
 		queryArgs = append(queryArgs, hash)
 	}
 
-	rows, err := Conn.Query(`SELECT
-      input,
-      output,
-      amount,
-      signature,
-      unique_string
-    FROM 'arach_transaction' WHERE block in (`+strings.Join(strings.Split(strings.Repeat("?", len(queryArgs)), ""), ",")+`) ORDER BY 'block_height' asc, 'order' asc`, queryArgs...)
-	defer rows.Close()
-
+	rows, err := Conn.Query(`SELECT`+transactionColumns+`
+    FROM 'arach_transaction' WHERE block in (`+strings.Join(strings.Split(strings.Repeat("?", len(queryArgs)), ""), ",")+`) ORDER BY block_height asc, "order" asc`, queryArgs...)
 	if err != nil {
 		panic(err)
 	}
+	defer rows.Close()
 
-	for rows.Next() {
-		var input string
-		var output string
-		var amount uint32
-		var signature string
-		var unique string
-
-		err = rows.Scan(
-			&input,
-			&output,
-			&amount,
-			&signature,
-			&unique,
-		)
-		results = append(results, transaction.Transaction{
-			input,
-			output,
-			amount,
-			signature,
-			unique,
-		})
-	}
-
-	return results
+	return transactionsFromRows(rows)
 }
 
 func FetchBlockTransactions(blockHash string) []transaction.Transaction {
-	results := make([]transaction.Transaction, 0)
 	if Conn == nil {
 		panic("Database connection not initialised")
 	}
 
-	rows, err := Conn.Query(`SELECT
-      input,
-      output,
-      amount,
-      signature,
-      unique_string
-    FROM 'arach_transaction' WHERE block=?`, blockHash)
-	defer rows.Close()
-
+	rows, err := Conn.Query(`SELECT`+transactionColumns+`
+    FROM 'arach_transaction' WHERE block=? ORDER BY "order" asc`, blockHash)
 	if err != nil {
 		panic(err)
 	}
+	defer rows.Close()
+
+	return transactionsFromRows(rows)
+}
+
+func transactionsFromRows(rows *sql.Rows) []transaction.Transaction {
+	results := make([]transaction.Transaction, 0)
 
 	for rows.Next() {
 		var input string
 		var output string
-		var amount uint32
+		var amount uint64
 		var signature string
 		var unique string
+		var htlc string
+		var preimage string
+		var lock string
+		var unlock string
+		var data string
 
-		err = rows.Scan(
+		err := rows.Scan(
 			&input,
 			&output,
 			&amount,
 			&signature,
 			&unique,
+			&htlc,
+			&preimage,
+			&lock,
+			&unlock,
+			&data,
 		)
-		results = append(results, transaction.Transaction{
-			input,
-			output,
-			amount,
-			signature,
-			unique,
-		})
+		if err != nil {
+			panic(err)
+		}
+
+		t := transaction.Transaction{
+			Input:     input,
+			Output:    output,
+			Amount:    amount,
+			Signature: signature,
+			Unique:    unique,
+			Preimage:  preimage,
+			Lock:      lock,
+			Unlock:    unlock,
+			Data:      data,
+		}
+		if htlc != "" {
+			t.HTLC = new(transaction.HTLC)
+			err = json.Unmarshal([]byte(htlc), t.HTLC)
+			if err != nil {
+				panic(err)
+			}
+		}
+		results = append(results, t)
 	}
 
 	return results
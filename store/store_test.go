@@ -1,7 +1,10 @@
 package store
 
 import (
+	"database/sql"
 	"github.com/frankh/arachnacoin/block"
+	"github.com/frankh/arachnacoin/work"
+	"path/filepath"
 	"testing"
 )
 
@@ -13,3 +16,111 @@ func TestStoreFetchGenesis(t *testing.T) {
 		t.Errorf("Failed to store and fetch genesis block")
 	}
 }
+
+// Databases made before transactions were keyed by block are rebuilt
+func TestMigrateTransactionKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "old.sqlite")
+	old, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = old.Exec(`
+      CREATE TABLE 'arach_block' (
+        'hash' TEXT PRIMARY KEY,
+        'height' INT NOT NULL,
+        'previous' TEXT NOT NULL,
+        'work' INT NOT NULL,
+        'created' DATE DEFAULT CURRENT_TIMESTAMP NOT NULL
+      );
+      CREATE TABLE 'arach_transaction' (
+        'hash' TEXT PRIMARY KEY,
+        'input' TEXT NOT NULL,
+        'output' TEXT NOT NULL,
+        'amount' INT NOT NULL,
+        'signature' TEXT NOT NULL,
+        'unique_string' TEXT NOT NULL,
+        'order' INT NOT NULL,
+        'block' TEXT NOT NULL,
+        'block_height' INT NOT NULL,
+        'created' DATE DEFAULT CURRENT_TIMESTAMP NOT NULL
+      );
+      CREATE TABLE 'arach_wallet' (
+        'public_key' TEXT PRIMARY KEY,
+        'private_key' TEXT NOT NULL,
+        'created' DATE DEFAULT CURRENT_TIMESTAMP NOT NULL
+      );
+      INSERT INTO 'arach_transaction' VALUES ('t1', 'in', 'out', 5, 'sig', 'u', 0, 'b1', 1, CURRENT_TIMESTAMP);
+    `)
+	old.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Init(path)
+	defer Conn.Close()
+	var amount int
+	err = Conn.QueryRow(`SELECT amount FROM arach_transaction WHERE hash='t1' AND block='b1'`).Scan(&amount)
+	if err != nil || amount != 5 {
+		t.Fatalf("Transaction lost migrating: %v", err)
+	}
+	_, err = Conn.Exec(`INSERT INTO arach_transaction (hash, input, output, amount, signature, unique_string, "order", block, block_height)
+		VALUES ('t1', 'in', 'out', 5, 'sig', 'u', 0, 'b2', 1)`)
+	if err != nil {
+		t.Errorf("Transaction can't be in a second block: %s", err)
+	}
+}
+
+// A migration that fails partway leaves the database as it was
+func TestMigrationAtomic(t *testing.T) {
+	Init(":memory:")
+	defer func(m []string) { migrations = m }(migrations)
+	migrations = append(migrations, `CREATE TABLE 'arach_half' ('a' INT); NOT SQL`)
+	func() {
+		defer func() { recover() }()
+		migrate()
+		t.Errorf("Bad migration didn't fail")
+	}()
+
+	var version, tables int
+	err := Conn.QueryRow(`PRAGMA user_version`).Scan(&version)
+	if err == nil {
+		err = Conn.QueryRow(`SELECT count(*) FROM sqlite_master WHERE name='arach_half'`).Scan(&tables)
+	}
+	if err != nil || version != len(migrations)-1 || tables != 0 {
+		t.Errorf("Half a migration applied, version %d: %v", version, err)
+	}
+}
+
+// Chains stored with older transaction hashes are dropped to sync again
+func TestOldChainFormat(t *testing.T) {
+	work.Difficulty = 0xff000000
+	path := filepath.Join(t.TempDir(), "old.sqlite")
+	Init(path)
+	address := MyWallet.Address()
+	b := work.Mine(block.GenesisBlock, nil, address)
+	StoreBlock(b)
+	storeSetting("chain_format", "1")
+	Conn.Close()
+
+	Init(path)
+	defer Conn.Close()
+	highest := FetchHighestBlock()
+	if FetchBlock(b.HashString()) != nil || highest.HashString() != block.GenesisBlock.HashString() {
+		t.Errorf("Kept a chain in the old format")
+	}
+	if MyWallet.Address() != address || fetchSetting("chain_format") != chainFormat {
+		t.Errorf("Lost the wallet or didn't note the format")
+	}
+}
+
+func TestBlockHeight(t *testing.T) {
+	work.Difficulty = 0xff000000
+	miner := GenerateWallet()
+	Init(":memory:")
+	b := work.Mine(block.GenesisBlock, nil, miner.Address())
+	// The hash doesn't cover the height, so the work stays valid
+	b.Height = 1000000
+	if !work.ValidateBlockWork(b) || ValidateBlock(b) {
+		t.Errorf("Accepted block at the wrong height")
+	}
+}
@@ -2,24 +2,91 @@ package store
 
 import (
 	"encoding/hex"
+	"errors"
+	"github.com/frankh/arachnacoin/crypto"
+	"github.com/frankh/arachnacoin/transaction"
 	"golang.org/x/crypto/ed25519"
 )
 
+// A key in one of the node's named wallets
 type Wallet struct {
 	PublicKey  ed25519.PublicKey
-	PrivateKey ed25519.PrivateKey
+	PrivateKey ed25519.PrivateKey // nil when the wallet is encrypted or the key is watch-only
+	Path       string             // Derivation path from the wallet seed, empty for random keys
+	WalletName string
+	Label      string
+	WatchOnly  bool   // Only the public key is known, see watch.go
+	sealedKey  []byte // The private key sealed under the master key, see encrypt.go
+	confirmed  bool   // Payments over the wallet's confirmation threshold were confirmed, see policy.go
 }
 
+// The first key of the default wallet, used when a wallet isn't given
 var MyWallet *Wallet
 
 func (w *Wallet) Address() string {
-	return hex.EncodeToString(w.PublicKey)
+	return crypto.EncodeAddress(crypto.PubKeyAddress, w.PublicKey)
 }
 
 func (w *Wallet) KeyStrings() (pub string, priv string) {
 	return hex.EncodeToString(w.PublicKey), hex.EncodeToString(w.PrivateKey)
 }
 
+// Builds a signed transaction paying amount from the wallet to output,
+// with optional data attached
+func (w *Wallet) Send(output string, amount uint64, data []byte) (transaction.Transaction, error) {
+	t := transaction.Transaction{
+		Input:  w.Address(),
+		Output: output,
+		Amount: amount,
+		Unique: transaction.NewUnique(),
+		Data:   hex.EncodeToString(data),
+	}
+	err := w.Sign(&t)
+	return t, err
+}
+
+// Signs the transaction as its input, which should be this wallet's address
+func (w *Wallet) Sign(t *transaction.Transaction) error {
+	signature, err := w.sign(SignRequest{Address: w.Address(), Hash: t.HashString(), Transaction: t})
+	if err != nil {
+		return err
+	}
+	t.Signature = hex.EncodeToString(signature)
+	return nil
+}
+
+// Signs a transaction's hash without setting its Signature, for spends
+// from scripts, which carry signatures in their unlocking script
+func (w *Wallet) SignTransactionHash(t *transaction.Transaction) ([]byte, error) {
+	return w.sign(SignRequest{Address: w.Address(), Hash: t.HashString(), Transaction: t})
+}
+
+// Signs a message to prove control of the address, see
+// crypto.MessageHash
+func (w *Wallet) SignMessage(message string) ([]byte, error) {
+	if message == "" {
+		return nil, errors.New("message can't be empty")
+	}
+	hash := crypto.MessageHash([]byte(message))
+	return w.sign(SignRequest{Address: w.Address(), Hash: hex.EncodeToString(hash), Message: message})
+}
+
+// Signs with the private key, opening it first if it's sealed
+func (w *Wallet) signLocally(hash []byte) ([]byte, error) {
+	if w.sealedKey == nil {
+		return ed25519.Sign(w.PrivateKey, hash), nil
+	}
+	priv, err := openSealed(w.sealedKey, keyLabel(*w))
+	if err != nil {
+		return nil, err
+	}
+	signature := ed25519.Sign(priv, hash)
+	for i := range priv {
+		priv[i] = 0
+	}
+	return signature, nil
+}
+
 func FromKeyStrings(pub string, priv string) Wallet {
 	pubKey, err := hex.DecodeString(pub)
 	if err != nil {
@@ -30,8 +97,8 @@ func FromKeyStrings(pub string, priv string) Wallet {
 		panic("Invalid private key")
 	}
 	return Wallet{
-		pubKey,
-		privKey,
+		PublicKey:  pubKey,
+		PrivateKey: privKey,
 	}
 }
 
@@ -41,23 +108,24 @@ func GenerateWallet() Wallet {
 		panic("Couldn't generate private key")
 	}
 	return Wallet{
-		pub,
-		priv,
+		PublicKey:  pub,
+		PrivateKey: priv,
 	}
 }
 
-func GetBalance(address string) uint32 {
+func GetBalance(address string) uint64 {
 	transactions := FetchTransactionsForAccount(address)
-	balance := uint32(0)
+	balance := uint64(0)
+	var err error
 	for _, t := range transactions {
 		if t.Output == address {
-			balance += t.Amount
+			balance, err = transaction.AddAmounts(balance, t.Amount)
 		}
-		if t.Input == address {
-			balance -= t.Amount
+		if err == nil && t.Input == address {
+			balance, err = transaction.SubAmounts(balance, t.Amount)
 		}
-		if balance < 0 {
-			panic("invalid transaction, caused negative balance")
+		if err != nil {
+			panic("invalid transaction, caused " + err.Error())
 		}
 	}
 
@@ -2,6 +2,7 @@ package store
 
 import (
 	"github.com/frankh/arachnacoin/block"
+	"github.com/frankh/arachnacoin/crypto"
 	"github.com/frankh/arachnacoin/transaction"
 	"github.com/frankh/arachnacoin/work"
 	"testing"
@@ -21,3 +22,22 @@ func TestGetBalance(t *testing.T) {
 		t.Errorf("Blockreward not added")
 	}
 }
+
+func TestSignMessage(t *testing.T) {
+	w := GenerateWallet()
+	signature, err := w.SignMessage("withdraw to arc1q...")
+	if err != nil {
+		t.Fatalf("Signing failed: %s", err)
+	}
+	if !crypto.VerifyMessage(w.Address(), []byte("withdraw to arc1q..."), signature) {
+		t.Errorf("Signed message didn't verify")
+	}
+	if _, err := w.SignMessage(""); err == nil {
+		t.Errorf("Signed an empty message")
+	}
+
+	watched := Wallet{PublicKey: w.PublicKey, WatchOnly: true}
+	if _, err := watched.SignMessage("hello"); err != ErrWatchOnly {
+		t.Errorf("Signed a message with a watch-only key: %v", err)
+	}
+}
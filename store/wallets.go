@@ -0,0 +1,191 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"github.com/frankh/arachnacoin/crypto"
+	"github.com/frankh/arachnacoin/transaction"
+)
+
+// A node can hold several named wallets, each with its own seed and keys.
+// One of them is the default, used when a command doesn't name one.
+
+const DefaultWalletName = "default"
+
+// Returns the names of every wallet, oldest first
+func WalletNames() []string {
+	rows, err := Conn.Query(`SELECT wallet FROM arach_wallet GROUP BY wallet ORDER BY min(rowid) asc`)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	names := make([]string, 0)
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			panic(err)
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// Adds a wallet with a new seed. Fails while the wallets are locked.
+func CreateWallet(name string) error {
+	mnemonic := crypto.GenerateMnemonic()
+	seed, _ := crypto.MnemonicToSeed(mnemonic, "")
+	return RestoreWallet(name, seed, mnemonic)
+}
+
+func DefaultWallet() string {
+	name := fetchSetting("default_wallet")
+	if name == "" {
+		names := WalletNames()
+		if len(names) == 0 {
+			return DefaultWalletName
+		}
+		return names[0]
+	}
+	return name
+}
+
+func SetDefaultWallet(name string) error {
+	if len(FetchWallets(name)) == 0 {
+		return errors.New("no wallet named " + name)
+	}
+	storeSetting("default_wallet", name)
+	loadMyWallet()
+	return nil
+}
+
+func loadMyWallet() {
+	w, err := FetchWallet(DefaultWallet())
+	if err != nil {
+		panic(err)
+	}
+	MyWallet = &w
+}
+
+// Returns the address mining rewards are paid to, the default wallet's
+// first address unless set
+func RewardAddress() string {
+	address := fetchSetting("reward_address")
+	if address == "" {
+		return MyWallet.Address()
+	}
+	return address
+}
+
+// Sets the address mining rewards are paid to, which must be in one of
+// the wallets
+func SetRewardAddress(address string) error {
+	if FetchWalletByAddress(address) == nil {
+		return errors.New("address " + address + " is not in any wallet")
+	}
+	storeSetting("reward_address", address)
+	return nil
+}
+
+// Adds a key that wasn't derived from a seed to a wallet, creating the
+// wallet if it doesn't exist. Backups of the wallet's seed don't cover it.
+func ImportKey(name string, w Wallet, label string) error {
+	if name == "" {
+		return errors.New("wallet name can't be empty")
+	}
+	if FetchWalletByAddress(w.Address()) != nil {
+		return errors.New("address " + w.Address() + " is already in a wallet")
+	}
+	if IsLocked() {
+		return ErrWalletLocked
+	}
+	w.Path = ""
+	w.WalletName = name
+	w.Label = label
+	StoreWallet(w)
+	return nil
+}
+
+func SetLabel(address string, label string) error {
+	w := FetchWalletByAddress(address)
+	if w == nil {
+		return errors.New("address " + address + " is not in any wallet")
+	}
+	_, err := Conn.Exec(`UPDATE arach_wallet SET label=? WHERE public_key=?`, label, hex.EncodeToString(w.PublicKey))
+	if err != nil {
+		panic(err)
+	}
+	return nil
+}
+
+// Returns the total balance of a wallet's addresses
+func GetWalletBalance(name string) (uint64, error) {
+	total := uint64(0)
+	for _, w := range FetchWallets(name) {
+		var err error
+		total, err = transaction.AddAmounts(total, GetBalance(w.Address()))
+		if err != nil {
+			return 0, err
+		}
+	}
+	return total, nil
+}
+
+// A transaction on the longest chain affecting a wallet, with how much it
+// paid to and from the wallet's addresses
+type HistoryEntry struct {
+	Transaction transaction.Transaction
+	Height      uint32
+	Received    uint64
+	Sent        uint64
+}
+
+// Returns the transactions on the longest chain affecting a wallet,
+// oldest first
+func FetchHistory(name string) []HistoryEntry {
+	ours := make(map[string]bool)
+	for _, w := range FetchWallets(name) {
+		ours[w.Address()] = true
+	}
+
+	head := FetchHighestBlock()
+	hashChain := GetBlockHashChain(&head)
+	history := make([]HistoryEntry, 0)
+	for i := len(hashChain) - 1; i >= 0; i-- {
+		for _, t := range FetchBlockTransactions(hashChain[i]) {
+			if !ours[t.Input] && !ours[t.Output] {
+				continue
+			}
+			entry := HistoryEntry{Transaction: t, Height: uint32(len(hashChain) - 1 - i)}
+			if ours[t.Output] {
+				entry.Received = t.Amount
+			}
+			if ours[t.Input] {
+				entry.Sent = t.Amount
+			}
+			history = append(history, entry)
+		}
+	}
+	return history
+}
+
+func fetchSetting(name string) string {
+	var value string
+	err := Conn.QueryRow(`SELECT value FROM arach_setting WHERE name=?`, name).Scan(&value)
+	if err == sql.ErrNoRows {
+		return ""
+	}
+	if err != nil {
+		panic(err)
+	}
+	return value
+}
+
+func storeSetting(name string, value string) {
+	_, err := Conn.Exec(`INSERT OR REPLACE INTO arach_setting (name, value) values (?, ?)`, name, value)
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,97 @@
+package store
+
+import (
+	"github.com/frankh/arachnacoin/block"
+	"github.com/frankh/arachnacoin/transaction"
+	"github.com/frankh/arachnacoin/work"
+	"testing"
+)
+
+func TestNamedWallets(t *testing.T) {
+	work.Difficulty = 0xff000000
+	Init(":memory:")
+	chain := Conn
+	main := *MyWallet
+
+	if err := CreateWallet("savings"); err != nil {
+		t.Fatalf("Create failed: %s", err)
+	}
+	if CreateWallet("savings") == nil {
+		t.Errorf("Created a wallet twice")
+	}
+	names := WalletNames()
+	if len(names) != 2 || names[0] != DefaultWalletName || names[1] != "savings" {
+		t.Fatalf("Unexpected wallets %v", names)
+	}
+	savings, _ := FetchWallet("savings")
+	mainSeed, _ := FetchSeed(DefaultWalletName)
+	savingsSeed, _ := FetchSeed("savings")
+	if string(mainSeed) == string(savingsSeed) {
+		t.Errorf("Wallets share a seed")
+	}
+
+	// Rewards go to the default wallet until set otherwise
+	if RewardAddress() != main.Address() {
+		t.Errorf("Reward address isn't the default wallet's")
+	}
+	stranger := GenerateWallet()
+	if SetRewardAddress(stranger.Address()) == nil {
+		t.Errorf("Set reward address outside the wallets")
+	}
+	if err := SetRewardAddress(savings.Address()); err != nil || RewardAddress() != savings.Address() {
+		t.Errorf("Setting reward address failed: %v", err)
+	}
+
+	mineOn(chain, nil, main.Address())
+	payment, _ := main.Send(savings.Address(), 10, nil)
+	if !mineOn(chain, []transaction.Transaction{payment}, RewardAddress()) {
+		t.Fatalf("Failed to mine payment")
+	}
+
+	if balance, _ := GetWalletBalance("savings"); balance != block.BlockReward+10 {
+		t.Errorf("Savings balance %d", balance)
+	}
+	if balance, _ := GetWalletBalance(DefaultWalletName); balance != block.BlockReward-10 {
+		t.Errorf("Main balance %d", balance)
+	}
+	history := FetchHistory("savings")
+	if len(history) != 2 || history[0].Height != 2 || history[0].Received != 10 || history[1].Received != block.BlockReward {
+		t.Errorf("Unexpected history %+v", history)
+	}
+	history = FetchHistory(DefaultWalletName)
+	if len(history) != 2 || history[0].Height != 1 || history[1].Sent != 10 {
+		t.Errorf("Unexpected history %+v", history)
+	}
+
+	if err := SetLabel(savings.Address(), "rainy day"); err != nil {
+		t.Errorf("Labelling failed: %s", err)
+	}
+	if w := FetchWalletByAddress(savings.Address()); w == nil || w.Label != "rainy day" || w.WalletName != "savings" {
+		t.Errorf("Label not stored")
+	}
+
+	if SetDefaultWallet("missing") == nil {
+		t.Errorf("Set a missing wallet as default")
+	}
+	if err := SetDefaultWallet("savings"); err != nil || MyWallet.Address() != savings.Address() {
+		t.Errorf("Setting default wallet failed: %v", err)
+	}
+}
+
+func TestImportKey(t *testing.T) {
+	Init(":memory:")
+	key := GenerateWallet()
+	if err := ImportKey("vanity", key, "shiny"); err != nil {
+		t.Fatalf("Import failed: %s", err)
+	}
+	if ImportKey(DefaultWalletName, key, "") == nil {
+		t.Errorf("Imported a key twice")
+	}
+	w := FetchWalletByAddress(key.Address())
+	if w == nil || w.WalletName != "vanity" || w.Label != "shiny" || w.Path != "" {
+		t.Fatalf("Imported key not stored")
+	}
+	if _, err := w.Send(key.Address(), 0, nil); err != nil {
+		t.Errorf("Imported key can't sign: %s", err)
+	}
+}
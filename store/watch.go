@@ -0,0 +1,54 @@
+package store
+
+import (
+	"errors"
+	"github.com/frankh/arachnacoin/crypto"
+)
+
+// Watch-only keys are addresses whose private keys are kept elsewhere.
+// Their balances and history are reported like any other key's, but they
+// can only sign through ExternalSigner. A wallet holding only watch-only
+// keys has no seed. Keys are derived with hardened steps only, see
+// crypto.WalletPath, so there's no extended public key to derive a
+// wallet's addresses from and each one is watched on its own.
+
+var ErrWatchOnly = errors.New("address is watch-only, its key is kept elsewhere")
+
+// Adds an address to a wallet as watch-only, creating the wallet if it
+// doesn't exist
+func WatchAddress(name string, address string, label string) error {
+	if name == "" {
+		return errors.New("wallet name can't be empty")
+	}
+	version, payload, err := crypto.DecodeAddress(address)
+	if err != nil {
+		return err
+	}
+	if version != crypto.PubKeyAddress {
+		return errors.New("only key addresses can be watched")
+	}
+	if FetchWalletByAddress(address) != nil {
+		return errors.New("address " + address + " is already in a wallet")
+	}
+	StoreWallet(Wallet{
+		PublicKey:  payload,
+		WalletName: name,
+		Label:      label,
+		WatchOnly:  true,
+	})
+	return nil
+}
+
+// Returns whether a wallet has only watch-only keys
+func IsWatchOnly(name string) bool {
+	if hasSeed(name) {
+		return false
+	}
+	wallets := FetchWallets(name)
+	for _, w := range wallets {
+		if !w.WatchOnly {
+			return false
+		}
+	}
+	return len(wallets) > 0
+}
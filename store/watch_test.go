@@ -0,0 +1,77 @@
+package store
+
+import (
+	"github.com/frankh/arachnacoin/block"
+	"github.com/frankh/arachnacoin/crypto"
+	"github.com/frankh/arachnacoin/transaction"
+	"github.com/frankh/arachnacoin/work"
+	"testing"
+	"time"
+)
+
+func TestWatchOnly(t *testing.T) {
+	work.Difficulty = 0xff000000
+	Init(":memory:")
+	defer Lock()
+	chain := Conn
+	main := *MyWallet
+	cold := GenerateWallet()
+
+	if err := WatchAddress("cold", cold.Address(), "vault"); err != nil {
+		t.Fatalf("Watch failed: %s", err)
+	}
+	if WatchAddress("cold", cold.Address(), "") == nil {
+		t.Errorf("Watched an address twice")
+	}
+	if WatchAddress("cold", main.Address(), "") == nil {
+		t.Errorf("Watched an address we have the key for")
+	}
+	if WatchAddress("cold", crypto.EncodeAddress(crypto.ScriptAddress, make([]byte, 32)), "") == nil {
+		t.Errorf("Watched a script address")
+	}
+	if !IsWatchOnly("cold") || IsWatchOnly(DefaultWalletName) {
+		t.Errorf("Wrong wallets are watch-only")
+	}
+
+	// Payments to watched addresses show up in the wallet
+	mineOn(chain, nil, main.Address())
+	payment, _ := main.Send(cold.Address(), 10, nil)
+	if !mineOn(chain, []transaction.Transaction{payment}, cold.Address()) {
+		t.Fatalf("Failed to mine payment")
+	}
+	if balance, _ := GetWalletBalance("cold"); balance != block.BlockReward+10 {
+		t.Errorf("Watched balance %d", balance)
+	}
+	history := FetchHistory("cold")
+	if len(history) != 2 || history[0].Received != 10 {
+		t.Errorf("Unexpected history %+v", history)
+	}
+
+	// But the wallet can't spend or derive
+	watched, _ := FetchWallet("cold")
+	if watched.Label != "vault" || !watched.WatchOnly {
+		t.Errorf("Watched key not stored")
+	}
+	if _, err := watched.Send(main.Address(), 1, nil); err != ErrWatchOnly {
+		t.Errorf("Signed for a watched address: %v", err)
+	}
+	if _, err := NewAddress("cold", 0); err == nil {
+		t.Errorf("Derived an address in a watch-only wallet")
+	}
+	if _, err := ScanWallet("cold"); err == nil || hasSeed("cold") {
+		t.Errorf("Scanned a watch-only wallet")
+	}
+
+	// Encryption leaves watched keys alone
+	if err := EncryptWallet("correct horse"); err != nil {
+		t.Fatalf("Encrypt failed: %s", err)
+	}
+	Unlock("correct horse", time.Minute)
+	watched, _ = FetchWallet("cold")
+	if !watched.WatchOnly || watched.Address() != cold.Address() {
+		t.Errorf("Watched key changed by encryption")
+	}
+	if _, err := watched.Send(main.Address(), 1, nil); err != ErrWatchOnly {
+		t.Errorf("Signed for a watched address: %v", err)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/frankh/arachnacoin/rpc"
+	"github.com/frankh/arachnacoin/transaction"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Set to a node's arguments, one per line, to run the test binary as that
+// node instead of running the tests
+const testNodeEnv = "ARACHNACOIN_TEST_NODE"
+
+func TestMain(m *testing.M) {
+	if args := os.Getenv(testNodeEnv); args != "" {
+		os.Args = append([]string{os.Args[0]}, strings.Split(args, "\n")...)
+		main()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+func freePort(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	return port
+}
+
+// Starts a regtest node with its own chain, returning its rpc address
+func startNode(t *testing.T, name string) string {
+	dir := t.TempDir()
+	address := "127.0.0.1:" + freePort(t)
+	args := []string{
+		"-network", "regtest",
+		"-db", filepath.Join(dir, name+".sqlite"),
+		"-rpc", address,
+		"-port", freePort(t),
+		"-multicast=false",
+	}
+	log, err := os.Create(filepath.Join(dir, name+".log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), testNodeEnv+"="+strings.Join(args, "\n"))
+	cmd.Stdout = log
+	cmd.Stderr = log
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+		log.Close()
+		if t.Failed() {
+			out, _ := ioutil.ReadFile(log.Name())
+			t.Logf("%s log:\n%s", name, out)
+		}
+	})
+
+	waitFor(t, name+" to start", func() bool {
+		client, err := rpc.Dial(address)
+		if err != nil {
+			return false
+		}
+		client.Close()
+		return true
+	})
+	return address
+}
+
+func nodeCall(address string, method string, args interface{}, reply interface{}) error {
+	client, err := rpc.Dial(address)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return client.Call(method, args, reply)
+}
+
+func mustCall(t *testing.T, address string, method string, args interface{}, reply interface{}) {
+	if err := nodeCall(address, method, args, reply); err != nil {
+		t.Fatalf("%s: %s", method, err)
+	}
+}
+
+func waitFor(t *testing.T, what string, done func() bool) {
+	deadline := time.Now().Add(time.Minute)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func balance(t *testing.T, node string, address string) uint64 {
+	var reply string
+	mustCall(t, node, "Wallet.Balance", &rpc.BalanceArgs{Address: address}, &reply)
+	amount, err := transaction.ParseAmount(reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return amount
+}
+
+// Waits for a contract to be mined holding amount
+func waitForContract(t *testing.T, node string, contract string, amount uint64) rpc.ContractReply {
+	var reply rpc.ContractReply
+	waitFor(t, "contract "+contract, func() bool {
+		if nodeCall(node, "Chain.Contract", &rpc.ContractArgs{Address: contract}, &reply) != nil {
+			return false
+		}
+		held, err := transaction.ParseAmount(reply.Balance)
+		return err == nil && held == amount
+	})
+	return reply
+}
+
+// Alice and Bob swap coins between two separate chains, each run by its
+// own node
+func TestAtomicSwapBetweenNodes(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs two nodes")
+	}
+	chainA := startNode(t, "a")
+	chainB := startNode(t, "b")
+
+	// Alice mines on chain A and Bob on chain B
+	var alice, bob string
+	mustCall(t, chainA, "Wallet.Address", &rpc.AddressArgs{}, &alice)
+	mustCall(t, chainB, "Wallet.Address", &rpc.AddressArgs{}, &bob)
+	coin := uint64(transaction.Coin)
+	waitFor(t, "coins to be mined", func() bool {
+		return balance(t, chainA, alice) >= 10*coin && balance(t, chainB, bob) >= 10*coin
+	})
+
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	preimage := hex.EncodeToString(secret)
+
+	// Alice locks 10 coins on A for Bob, with the longer timeout
+	var lockA transaction.Transaction
+	mustCall(t, chainA, "Wallet.CreateHTLC", &rpc.CreateHTLCArgs{
+		Recipient: bob,
+		Amount:    "10",
+		HashLock:  transaction.HashSecret(secret),
+		Blocks:    100000,
+	}, &lockA)
+	contractA := waitForContract(t, chainA, lockA.Output, 10*coin)
+
+	// Bob checks Alice's contract then locks 5 coins on B for Alice
+	if contractA.Terms.Recipient != bob {
+		t.Fatalf("Contract on chain A pays %s, not Bob", contractA.Terms.Recipient)
+	}
+	var lockB transaction.Transaction
+	mustCall(t, chainB, "Wallet.CreateHTLC", &rpc.CreateHTLCArgs{
+		Recipient: alice,
+		Amount:    "5",
+		HashLock:  contractA.Terms.HashLock,
+		Blocks:    50000,
+	}, &lockB)
+	waitForContract(t, chainB, lockB.Output, 5*coin)
+
+	// A wrong secret can't claim
+	var claim transaction.Transaction
+	if nodeCall(chainB, "Wallet.ClaimHTLC", &rpc.ClaimHTLCArgs{Address: lockB.Output, Preimage: "00"}, &claim) == nil {
+		t.Errorf("Claimed with the wrong secret")
+	}
+
+	// Alice claims on B, revealing the secret
+	mustCall(t, chainB, "Wallet.ClaimHTLC", &rpc.ClaimHTLCArgs{Address: lockB.Output, Preimage: preimage}, &claim)
+	contractB := waitForContract(t, chainB, lockB.Output, 0)
+	if balance(t, chainB, alice) != 5*coin {
+		t.Errorf("Alice didn't receive funds on chain B")
+	}
+
+	// Bob reads the secret from chain B and claims on A
+	if contractB.Preimage != preimage {
+		t.Fatalf("Secret not revealed on chain B: %q", contractB.Preimage)
+	}
+	mustCall(t, chainA, "Wallet.ClaimHTLC", &rpc.ClaimHTLCArgs{Address: lockA.Output, Preimage: contractB.Preimage}, &claim)
+	waitForContract(t, chainA, lockA.Output, 0)
+	if balance(t, chainA, bob) != 10*coin {
+		t.Errorf("Bob didn't receive funds on chain A")
+	}
+}
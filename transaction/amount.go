@@ -0,0 +1,81 @@
+package transaction
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Amounts are whole numbers of base units. One coin is 10^Decimals of
+// them, and amounts are written in coins for people, e.g. "12.5".
+const Decimals = 8
+const Coin uint64 = 100000000
+
+// The largest amount anything can hold. Stored amounts are sqlite
+// integers, which are signed.
+const MaxAmount uint64 = 1<<63 - 1
+
+var ErrAmountOverflow = errors.New("amount overflow")
+var ErrInsufficientAmount = errors.New("insufficient balance")
+
+// Adds two amounts, failing rather than going over MaxAmount
+func AddAmounts(a uint64, b uint64) (uint64, error) {
+	if a > MaxAmount || b > MaxAmount-a {
+		return 0, ErrAmountOverflow
+	}
+	return a + b, nil
+}
+
+// Subtracts b from a, failing rather than going below zero
+func SubAmounts(a uint64, b uint64) (uint64, error) {
+	if b > a {
+		return 0, ErrInsufficientAmount
+	}
+	return a - b, nil
+}
+
+// Writes an amount in coins, without trailing zeroes
+func FormatAmount(amount uint64) string {
+	whole := strconv.FormatUint(amount/Coin, 10)
+	fraction := amount % Coin
+	if fraction == 0 {
+		return whole
+	}
+
+	digits := strconv.FormatUint(fraction, 10)
+	digits = strings.Repeat("0", Decimals-len(digits)) + digits
+	return whole + "." + strings.TrimRight(digits, "0")
+}
+
+// Reads an amount written in coins, e.g. "12" or "0.00000001"
+func ParseAmount(s string) (uint64, error) {
+	invalid := errors.New("invalid amount " + strconv.Quote(s))
+
+	parts := strings.SplitN(s, ".", 2)
+	whole := parts[0]
+	fraction := ""
+	if len(parts) == 2 {
+		fraction = parts[1]
+		if fraction == "" || len(fraction) > Decimals {
+			return 0, invalid
+		}
+	}
+	if whole == "" || strings.Trim(whole+fraction, "0123456789") != "" {
+		return 0, invalid
+	}
+
+	coins, err := strconv.ParseUint(whole, 10, 64)
+	if err != nil || coins > MaxAmount/Coin {
+		return 0, invalid
+	}
+	units := uint64(0)
+	if fraction != "" {
+		units, _ = strconv.ParseUint(fraction+strings.Repeat("0", Decimals-len(fraction)), 10, 64)
+	}
+
+	amount, err := AddAmounts(coins*Coin, units)
+	if err != nil {
+		return 0, invalid
+	}
+	return amount, nil
+}
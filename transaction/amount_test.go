@@ -0,0 +1,55 @@
+package transaction
+
+import (
+	"testing"
+)
+
+func TestFormatAmount(t *testing.T) {
+	cases := map[uint64]string{
+		0:             "0",
+		1:             "0.00000001",
+		Coin:          "1",
+		12*Coin + 5e7: "12.5",
+		MaxAmount:     "92233720368.54775807",
+	}
+	for amount, expected := range cases {
+		if FormatAmount(amount) != expected {
+			t.Errorf("Formatted %d as %s, expected %s", amount, FormatAmount(amount), expected)
+		}
+	}
+}
+
+func TestParseAmount(t *testing.T) {
+	cases := map[string]uint64{
+		"0":                    0,
+		"0.00000001":           1,
+		"1":                    Coin,
+		"12.5":                 12*Coin + 5e7,
+		"007.10":               7*Coin + 1e7,
+		"92233720368.54775807": MaxAmount,
+	}
+	for s, expected := range cases {
+		amount, err := ParseAmount(s)
+		if err != nil || amount != expected {
+			t.Errorf("Parsed %s as %d (%v), expected %d", s, amount, err, expected)
+		}
+	}
+
+	for _, s := range []string{"", ".", "1.", ".5", "-1", "+1", "1e5", "0.000000001", "1,5", "92233720368.54775808", "99999999999999999999"} {
+		if _, err := ParseAmount(s); err == nil {
+			t.Errorf("Parsed invalid amount %q", s)
+		}
+	}
+}
+
+func TestCheckedAmounts(t *testing.T) {
+	if _, err := AddAmounts(MaxAmount, 1); err != ErrAmountOverflow {
+		t.Errorf("Overflow not detected")
+	}
+	if sum, err := AddAmounts(MaxAmount-1, 1); err != nil || sum != MaxAmount {
+		t.Errorf("Adding up to MaxAmount failed")
+	}
+	if _, err := SubAmounts(1, 2); err != ErrInsufficientAmount {
+		t.Errorf("Underflow not detected")
+	}
+}
@@ -0,0 +1,59 @@
+package transaction
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"github.com/frankh/arachnacoin/crypto"
+)
+
+// A hash time-locked contract. Funds sent to the contract's address can be
+// claimed by Recipient by revealing the sha256 preimage of HashLock in a
+// block below Timeout, or returned to Refund from Timeout onwards.
+type HTLC struct {
+	Recipient string `json:"recipient"`
+	Refund    string `json:"refund"`
+	HashLock  string `json:"hash_lock"`
+	Timeout   uint32 `json:"timeout"`
+}
+
+func (c *HTLC) Hash() []byte {
+	h := sha512.New()
+	recipientBytes := addressBytes(c.Recipient)
+	refundBytes := addressBytes(c.Refund)
+	hashLockBytes, _ := hex.DecodeString(c.HashLock)
+	timeoutBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(timeoutBytes, c.Timeout)
+
+	h.Write(recipientBytes)
+	h.Write(refundBytes)
+	h.Write(hashLockBytes)
+	h.Write(timeoutBytes)
+
+	return h.Sum(nil)
+}
+
+// The address funds are locked to, derived from the contract terms
+func (c *HTLC) Address() string {
+	return crypto.EncodeAddress(crypto.HTLCAddress, c.Hash()[:crypto.AddressPayloadSize])
+}
+
+// Checks that the hex encoded preimage hashes to the contract's hash lock
+func (c *HTLC) CheckPreimage(preimage string) bool {
+	preimageBytes, err := hex.DecodeString(preimage)
+	if err != nil {
+		return false
+	}
+	return HashSecret(preimageBytes) == c.HashLock
+}
+
+// Returns the hex encoded hash lock for a secret
+func HashSecret(secret []byte) string {
+	hash := sha256.Sum256(secret)
+	return hex.EncodeToString(hash[:])
+}
+
+func IsHTLCAddress(address string) bool {
+	return crypto.IsAddress(address, crypto.HTLCAddress)
+}
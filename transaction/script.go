@@ -0,0 +1,17 @@
+package transaction
+
+import (
+	"crypto/sha512"
+	"github.com/frankh/arachnacoin/crypto"
+)
+
+// The address of funds locked by a script, derived from the hash of the
+// locking script.
+func ScriptAddress(lock []byte) string {
+	hash := sha512.Sum512(lock)
+	return crypto.EncodeAddress(crypto.ScriptAddress, hash[:crypto.AddressPayloadSize])
+}
+
+func IsScriptAddress(address string) bool {
+	return crypto.IsAddress(address, crypto.ScriptAddress)
+}
@@ -1,35 +1,88 @@
 package transaction
 
 import (
+	"crypto/rand"
 	"crypto/sha512"
 	"encoding/binary"
 	"encoding/hex"
+	"github.com/frankh/arachnacoin/crypto"
 )
 
 type Transaction struct {
 	Input     string `json:"input"`
 	Output    string `json:"output"`
-	Amount    uint32 `json:"amount"`
+	Amount    uint64 `json:"amount"` // In base units, see Coin
 	Signature string `json:"signature"`
-	Unique    string `json:"unique"` // Due to design flaws, we need a unique string here to prevent transaction hash collisions
+	Unique    string `json:"unique"`             // Due to design flaws, we need a unique string here to prevent transaction hash collisions
+	HTLC      *HTLC  `json:"htlc,omitempty"`     // Contract terms when funding an HTLC
+	Preimage  string `json:"preimage,omitempty"` // Revealed secret when claiming an HTLC
+	Lock      string `json:"lock,omitempty"`     // Hex locking script when paying to a script
+	Unlock    string `json:"unlock,omitempty"`   // Hex unlocking script when spending from a script
+	Data      string `json:"data,omitempty"`     // Hex of arbitrary data attached by the sender, at most MaxDataSize bytes
 }
 
+// Limit on the data attached to a transaction, enough for a note, an
+// invoice ID or a couple of hashes
+const MaxDataSize = 256
+
 func (t *Transaction) Hash() []byte {
 	h := sha512.New()
-	inputBytes, _ := hex.DecodeString(string(t.Input))
-	outputBytes, _ := hex.DecodeString(string(t.Output))
+	// Signatures are of the hash, so covering the network stops them being
+	// replayed on another
+	h.Write(crypto.ActiveNetwork.Magic[:])
+	inputBytes := addressBytes(t.Input)
+	outputBytes := addressBytes(t.Output)
 	uniqueBytes, _ := hex.DecodeString(string(t.Unique))
 	amountBytes := make([]byte, 8)
-	binary.BigEndian.PutUint32(amountBytes, t.Amount)
+	binary.BigEndian.PutUint64(amountBytes, t.Amount)
 
 	h.Write(inputBytes)
 	h.Write(outputBytes)
 	h.Write(amountBytes)
 	h.Write(uniqueBytes)
 
+	// The optional fields are only hashed when present. Hashes have changed
+	// since older versions, whose stored chains are synced again.
+	if t.HTLC != nil {
+		h.Write(t.HTLC.Hash())
+	}
+	if t.Preimage != "" {
+		preimageBytes, _ := hex.DecodeString(t.Preimage)
+		h.Write(preimageBytes)
+	}
+	if t.Lock != "" {
+		lockBytes, _ := hex.DecodeString(t.Lock)
+		h.Write(lockBytes)
+	}
+	if t.Data != "" {
+		dataBytes, _ := hex.DecodeString(t.Data)
+		h.Write(dataBytes)
+	}
+	// The unlocking script isn't hashed, as it holds signatures of the hash
+
 	return h.Sum(nil)
 }
 
 func (t *Transaction) HashString() string {
 	return hex.EncodeToString(t.Hash())
 }
+
+// Generates a random string for the Unique field
+func NewUnique() string {
+	unique := make([]byte, 16)
+	_, err := rand.Read(unique)
+	if err != nil {
+		panic("Couldn't generate unique string")
+	}
+	return hex.EncodeToString(unique)
+}
+
+// The bytes hashed for an address, its version and payload. Inputs that
+// aren't addresses, such as "blockReward", aren't hashed.
+func addressBytes(address string) []byte {
+	version, payload, err := crypto.DecodeAddress(address)
+	if err != nil {
+		return nil
+	}
+	return append([]byte{byte(version)}, payload...)
+}
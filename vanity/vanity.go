@@ -0,0 +1,129 @@
+package vanity
+
+import (
+	"crypto/rand"
+	"errors"
+	"github.com/frankh/arachnacoin/crypto"
+	"github.com/frankh/arachnacoin/store"
+	"math"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Vanity addresses are found by generating keys until one's address
+// matches a pattern. Each extra character of a prefix makes that about 32
+// times slower.
+
+// Every public key address starts with the network prefix, "1" and "q",
+// then one of the characters a version 0 byte leaves room for
+const versionChars = "qpzr"
+
+// How many random addresses are tried to estimate how often a regex
+// matches
+const regexSamples = 200000
+
+const bech32Chars = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+type Pattern struct {
+	prefix string
+	regex  *regexp.Regexp
+}
+
+// Returns a pattern matching addresses that start with prefix after their
+// fixed start, e.g. "arc1q"
+func Prefix(prefix string) (*Pattern, error) {
+	prefix = strings.ToLower(prefix)
+	for i, c := range prefix {
+		if !strings.ContainsRune(bech32Chars, c) {
+			return nil, errors.New("addresses never contain " + string(c))
+		}
+		if i == 0 && !strings.ContainsRune(versionChars, c) {
+			return nil, errors.New("prefix must start with one of " + versionChars)
+		}
+	}
+	return &Pattern{prefix: prefix}, nil
+}
+
+// Returns a pattern matching addresses, including their fixed start, with
+// a regular expression
+func Regex(expr string) (*Pattern, error) {
+	regex, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &Pattern{regex: regex}, nil
+}
+
+func (p *Pattern) Matches(address string) bool {
+	if p.regex != nil {
+		return p.regex.MatchString(address)
+	}
+	return strings.HasPrefix(address, fixedStart()+p.prefix)
+}
+
+func fixedStart() string {
+	return crypto.ActiveNetwork.AddressPrefix + "1q"
+}
+
+// Returns how many keys it takes on average to find a match, or +Inf if
+// one is too unlikely to estimate. Regexes are estimated by trying random
+// addresses.
+func (p *Pattern) Difficulty() float64 {
+	if p.regex == nil {
+		if p.prefix == "" {
+			return 1
+		}
+		return float64(len(versionChars)) * math.Pow(32, float64(len(p.prefix)-1))
+	}
+
+	payload := make([]byte, crypto.AddressPayloadSize)
+	matches := 0
+	for i := 0; i < regexSamples; i++ {
+		rand.Read(payload)
+		if p.Matches(crypto.EncodeAddress(crypto.PubKeyAddress, payload)) {
+			matches++
+		}
+	}
+	if matches == 0 {
+		return math.Inf(1)
+	}
+	return float64(regexSamples) / float64(matches)
+}
+
+// Generates keys on workers goroutines until one matches, or stop is
+// closed. tried counts the keys generated so far.
+func Search(p *Pattern, workers int, tried *uint64, stop <-chan struct{}) (store.Wallet, bool) {
+	found := make(chan store.Wallet, workers)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				w := store.GenerateWallet()
+				atomic.AddUint64(tried, 1)
+				if p.Matches(w.Address()) {
+					found <- w
+					return
+				}
+			}
+		}()
+	}
+
+	defer wg.Wait()
+	defer close(done)
+	select {
+	case w := <-found:
+		return w, true
+	case <-stop:
+		return store.Wallet{}, false
+	}
+}
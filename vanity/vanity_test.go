@@ -0,0 +1,56 @@
+package vanity
+
+import (
+	"github.com/frankh/arachnacoin/crypto"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrefix(t *testing.T) {
+	for _, bad := range []string{"b", "qqb", "xq", "q1"} {
+		if _, err := Prefix(bad); err == nil {
+			t.Errorf("Accepted impossible prefix %q", bad)
+		}
+	}
+
+	p, err := Prefix("qq")
+	if err != nil {
+		t.Fatalf("Prefix failed: %s", err)
+	}
+	if p.Difficulty() != 4*32 {
+		t.Errorf("Unexpected difficulty %f", p.Difficulty())
+	}
+	var tried uint64
+	w, ok := Search(p, 4, &tried, nil)
+	if !ok || !strings.HasPrefix(w.Address(), "arc1qqq") || tried == 0 {
+		t.Errorf("Search found %s after %d keys", w.Address(), tried)
+	}
+}
+
+func TestRegex(t *testing.T) {
+	p, err := Regex("l$")
+	if err != nil {
+		t.Fatalf("Regex failed: %s", err)
+	}
+	// One in 32 addresses end in any given character
+	if d := p.Difficulty(); d < 28 || d > 36 {
+		t.Errorf("Unexpected difficulty %f", d)
+	}
+	var tried uint64
+	w, ok := Search(p, 2, &tried, nil)
+	if !ok || !strings.HasSuffix(w.Address(), "l") {
+		t.Errorf("Search found %s", w.Address())
+	}
+
+	impossible, _ := Regex("^" + crypto.RegTest.AddressPrefix + "1")
+	if !math.IsInf(impossible.Difficulty(), 1) {
+		t.Errorf("Impossible regex has a difficulty")
+	}
+	stop := make(chan struct{})
+	time.AfterFunc(10*time.Millisecond, func() { close(stop) })
+	if _, ok := Search(impossible, 2, &tried, stop); ok {
+		t.Errorf("Search matched an impossible regex")
+	}
+}
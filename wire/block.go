@@ -0,0 +1,100 @@
+package wire
+
+import (
+	"github.com/frankh/arachnacoin/block"
+	"github.com/frankh/arachnacoin/transaction"
+)
+
+func (e *Encoder) Transaction(t *transaction.Transaction) {
+	e.Address(t.Input)
+	e.Address(t.Output)
+	e.Uint64(t.Amount)
+	e.Hex(t.Signature)
+	e.Hex(t.Unique)
+	e.Bool(t.HTLC != nil)
+	if t.HTLC != nil {
+		e.Address(t.HTLC.Recipient)
+		e.Address(t.HTLC.Refund)
+		e.Hex(t.HTLC.HashLock)
+		e.Uint32(t.HTLC.Timeout)
+	}
+	e.Hex(t.Preimage)
+	e.Hex(t.Lock)
+	e.Hex(t.Unlock)
+	e.Hex(t.Data)
+}
+
+func (d *Decoder) Transaction() transaction.Transaction {
+	var t transaction.Transaction
+	t.Input = d.Address()
+	t.Output = d.Address()
+	t.Amount = d.Uint64()
+	t.Signature = d.Hex()
+	t.Unique = d.Hex()
+	if d.Bool() {
+		t.HTLC = &transaction.HTLC{
+			Recipient: d.Address(),
+			Refund:    d.Address(),
+			HashLock:  d.Hex(),
+			Timeout:   d.Uint32(),
+		}
+	}
+	t.Preimage = d.Hex()
+	t.Lock = d.Hex()
+	t.Unlock = d.Hex()
+	t.Data = d.Hex()
+	return t
+}
+
+// Returns how many bytes a block's payload takes
+func BlockSize(b *block.Block) int {
+	e := NewEncoder()
+	e.Block(b)
+	return len(e.Bytes())
+}
+
+// Returns how many bytes a transaction adds to a block's payload
+func TransactionSize(t *transaction.Transaction) int {
+	e := &Encoder{}
+	e.Transaction(t)
+	return len(e.Bytes())
+}
+
+func (e *Encoder) Block(b *block.Block) {
+	e.Hex(b.Previous)
+	e.Uint32(b.Work)
+	e.Uint32(b.Height)
+	e.VarUint(uint64(len(b.Transactions)))
+	for i := range b.Transactions {
+		e.Transaction(&b.Transactions[i])
+	}
+}
+
+func (d *Decoder) Block() block.Block {
+	var b block.Block
+	b.Previous = d.Hex()
+	b.Work = d.Uint32()
+	b.Height = d.Uint32()
+	count := d.Count(MinTransactionSize)
+	b.Transactions = make([]transaction.Transaction, 0)
+	for i := 0; i < count; i++ {
+		b.Transactions = append(b.Transactions, d.Transaction())
+	}
+	return b
+}
+
+func (e *Encoder) Header(h *block.Header) {
+	e.Hex(h.Hash)
+	e.Hex(h.Previous)
+	e.Uint32(h.Work)
+	e.Uint32(h.Height)
+}
+
+func (d *Decoder) Header() block.Header {
+	var h block.Header
+	h.Hash = d.Hex()
+	h.Previous = d.Hex()
+	h.Work = d.Uint32()
+	h.Height = d.Uint32()
+	return h
+}
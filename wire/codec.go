@@ -0,0 +1,259 @@
+package wire
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/frankh/arachnacoin/crypto"
+)
+
+// Payloads start with the version of the codec that encoded them, so the
+// encoding can change without changing the framing. Numbers are big
+// endian, lengths and counts are uvarints, and strings that are hex or
+// addresses are sent as the bytes they stand for.
+
+const CodecVersion = 1
+
+// Tags saying how a hex or address string was encoded. Strings that
+// wouldn't come back the same from their bytes, e.g. upper case hex or
+// "blockReward", are sent as they are.
+const (
+	tagString  = 0
+	tagBytes   = 1
+	tagAddress = 1
+)
+
+// Fewest bytes an item can encode to, for bounding counts of them. Hex
+// and address strings take at least a tag and a length.
+const (
+	MinHexSize         = 2
+	MinAddressSize     = 2
+	MinHeaderSize      = 2*MinHexSize + 8
+	MinTransactionSize = 2*MinAddressSize + 8 + 6*MinHexSize + 1
+)
+
+type Encoder struct {
+	buf []byte
+}
+
+func NewEncoder() *Encoder {
+	return &Encoder{[]byte{CodecVersion}}
+}
+
+// Returns the encoded payload
+func (e *Encoder) Bytes() []byte {
+	return e.buf
+}
+
+func (e *Encoder) Uint8(v uint8) {
+	e.buf = append(e.buf, v)
+}
+
+func (e *Encoder) Uint32(v uint32) {
+	e.buf = append(e.buf, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(e.buf[len(e.buf)-4:], v)
+}
+
+func (e *Encoder) Uint64(v uint64) {
+	e.buf = append(e.buf, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint64(e.buf[len(e.buf)-8:], v)
+}
+
+func (e *Encoder) VarUint(v uint64) {
+	b := make([]byte, binary.MaxVarintLen64)
+	e.buf = append(e.buf, b[:binary.PutUvarint(b, v)]...)
+}
+
+func (e *Encoder) Bool(v bool) {
+	if v {
+		e.Uint8(1)
+	} else {
+		e.Uint8(0)
+	}
+}
+
+// Encodes bytes after their length
+func (e *Encoder) VarBytes(b []byte) {
+	e.VarUint(uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *Encoder) String(s string) {
+	e.VarBytes([]byte(s))
+}
+
+func (e *Encoder) Hex(s string) {
+	b, err := hex.DecodeString(s)
+	if err != nil || hex.EncodeToString(b) != s {
+		e.Uint8(tagString)
+		e.String(s)
+		return
+	}
+	e.Uint8(tagBytes)
+	e.VarBytes(b)
+}
+
+func (e *Encoder) Address(s string) {
+	version, payload, err := crypto.DecodeAddress(s)
+	if err != nil || crypto.EncodeAddress(version, payload) != s {
+		e.Uint8(tagString)
+		e.String(s)
+		return
+	}
+	e.Uint8(tagAddress)
+	e.Uint8(uint8(version))
+	e.VarBytes(payload)
+}
+
+// Decodes a payload. The first error is kept and returned by Finish, after
+// which everything decodes as zero.
+type Decoder struct {
+	buf []byte
+	err error
+}
+
+func NewDecoder(payload []byte) *Decoder {
+	d := &Decoder{buf: payload}
+	version := d.Uint8()
+	if d.err == nil && version != CodecVersion {
+		d.fail(fmt.Errorf("unknown codec version %d", version))
+	}
+	return d
+}
+
+func (d *Decoder) fail(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+	d.buf = nil
+}
+
+// Returns the first error decoding, or an error if there are bytes left
+// over
+func (d *Decoder) Finish() error {
+	if d.err == nil && len(d.buf) > 0 {
+		return fmt.Errorf("%d bytes left over", len(d.buf))
+	}
+	return d.err
+}
+
+func (d *Decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n > len(d.buf) {
+		d.fail(errors.New("payload is too short"))
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *Decoder) Uint8() uint8 {
+	b := d.next(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (d *Decoder) Uint32() uint32 {
+	b := d.next(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (d *Decoder) Uint64() uint64 {
+	b := d.next(8)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+func (d *Decoder) VarUint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.fail(errors.New("bad varint"))
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *Decoder) Bool() bool {
+	switch d.Uint8() {
+	case 0:
+		return false
+	case 1:
+		return true
+	}
+	d.fail(errors.New("bad bool"))
+	return false
+}
+
+// Decodes a count of items to follow. Each item takes at least minSize
+// bytes, so there can't be more than fit in what's left of the payload.
+func (d *Decoder) Count(minSize int) int {
+	count := d.VarUint()
+	if count > uint64(len(d.buf)/minSize) {
+		d.fail(fmt.Errorf("count of %d is too many", count))
+		return 0
+	}
+	return int(count)
+}
+
+func (d *Decoder) VarBytes() []byte {
+	length := d.VarUint()
+	if length > uint64(len(d.buf)) {
+		d.fail(errors.New("payload is too short"))
+		return nil
+	}
+	return d.next(int(length))
+}
+
+func (d *Decoder) String() string {
+	return string(d.VarBytes())
+}
+
+func (d *Decoder) Hex() string {
+	switch d.Uint8() {
+	case tagString:
+		return d.String()
+	case tagBytes:
+		return hex.EncodeToString(d.VarBytes())
+	}
+	d.fail(errors.New("bad hex tag"))
+	return ""
+}
+
+func (d *Decoder) Address() string {
+	switch d.Uint8() {
+	case tagString:
+		return d.String()
+	case tagAddress:
+		version := crypto.AddressVersion(d.Uint8())
+		payload := d.VarBytes()
+		if d.err != nil {
+			return ""
+		}
+		if version > crypto.ScriptAddress {
+			d.fail(fmt.Errorf("unknown address version %d", version))
+			return ""
+		}
+		if len(payload) != crypto.AddressPayloadSize {
+			d.fail(fmt.Errorf("address payload is %d bytes", len(payload)))
+			return ""
+		}
+		return crypto.EncodeAddress(version, payload)
+	}
+	d.fail(errors.New("bad address tag"))
+	return ""
+}
@@ -0,0 +1,138 @@
+package wire
+
+import (
+	"bytes"
+	"github.com/frankh/arachnacoin/block"
+	"github.com/frankh/arachnacoin/crypto"
+	"github.com/frankh/arachnacoin/transaction"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testAddress(b byte) string {
+	return crypto.EncodeAddress(crypto.PubKeyAddress, bytes.Repeat([]byte{b}, crypto.AddressPayloadSize))
+}
+
+func testBlock() block.Block {
+	return block.Block{
+		Previous: block.GenesisBlock.HashString(),
+		Work:     12345,
+		Height:   1,
+		Transactions: []transaction.Transaction{
+			{Input: "blockReward", Output: testAddress(1), Amount: block.BlockReward, Unique: transaction.NewUnique()},
+			{
+				Input:     testAddress(1),
+				Output:    testAddress(2),
+				Amount:    7,
+				Signature: strings.Repeat("ab", 64),
+				Unique:    transaction.NewUnique(),
+				HTLC:      &transaction.HTLC{Recipient: testAddress(2), Refund: testAddress(1), HashLock: strings.Repeat("cd", 32), Timeout: 100},
+				Data:      "00ff",
+			},
+			// Not valid, but should still come back as it was
+			{Input: strings.ToUpper(testAddress(3)), Output: "nonsense", Signature: "ABCD", Unique: "123", Lock: "51"},
+		},
+	}
+}
+
+func TestBlockRoundTrip(t *testing.T) {
+	b := testBlock()
+	e := NewEncoder()
+	e.Block(&b)
+
+	d := NewDecoder(e.Bytes())
+	decoded := d.Block()
+	if err := d.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(b, decoded) {
+		t.Errorf("Block changed:\n%+v\n%+v", b, decoded)
+	}
+	if decoded.HashString() != b.HashString() {
+		t.Errorf("Block hash changed")
+	}
+}
+
+func TestHeaderRoundTrip(t *testing.T) {
+	b := testBlock()
+	h := b.Header()
+	e := NewEncoder()
+	e.Header(&h)
+	d := NewDecoder(e.Bytes())
+	if decoded := d.Header(); d.Finish() != nil || decoded != h {
+		t.Errorf("Header changed:\n%+v\n%+v", h, decoded)
+	}
+}
+
+func TestCompactEncoding(t *testing.T) {
+	b := testBlock()
+	b.Transactions = b.Transactions[1:2]
+	e := NewEncoder()
+	e.Transaction(&b.Transactions[0])
+	// Roughly the raw bytes: two addresses, amount, signature, unique,
+	// contract and data
+	if len(e.Bytes()) > 2*34+8+64+16+34*2+32+4+2+20 {
+		t.Errorf("Transaction encoded in %d bytes", len(e.Bytes()))
+	}
+}
+
+func TestBadPayloads(t *testing.T) {
+	b := testBlock()
+	e := NewEncoder()
+	e.Block(&b)
+	payload := e.Bytes()
+
+	decode := func(payload []byte) error {
+		d := NewDecoder(payload)
+		d.Block()
+		return d.Finish()
+	}
+	for i := 0; i < len(payload); i++ {
+		if decode(payload[:i]) == nil {
+			t.Errorf("Decoded block truncated to %d bytes", i)
+		}
+	}
+	if decode(append(payload, 0)) == nil {
+		t.Errorf("Decoded block with trailing bytes")
+	}
+
+	version := append([]byte{}, payload...)
+	version[0] = CodecVersion + 1
+	if decode(version) == nil {
+		t.Errorf("Decoded unknown codec version")
+	}
+
+	// Claims a huge number of transactions without the bytes for them
+	e = NewEncoder()
+	e.Hex(b.Previous)
+	e.Uint32(0)
+	e.Uint32(0)
+	e.VarUint(1 << 40)
+	if decode(e.Bytes()) == nil {
+		t.Errorf("Decoded block with too many transactions")
+	}
+}
+
+func TestBadAddresses(t *testing.T) {
+	payload := bytes.Repeat([]byte{1}, crypto.AddressPayloadSize)
+	for _, c := range []struct {
+		name    string
+		version uint8
+		payload []byte
+	}{
+		{"short payload", uint8(crypto.PubKeyAddress), payload[1:]},
+		{"long payload", uint8(crypto.PubKeyAddress), append(payload, 1)},
+		{"unknown version", uint8(crypto.ScriptAddress) + 1, payload},
+	} {
+		e := NewEncoder()
+		e.Uint8(tagAddress)
+		e.Uint8(c.version)
+		e.VarBytes(c.payload)
+		d := NewDecoder(e.Bytes())
+		d.Address()
+		if d.Finish() == nil {
+			t.Errorf("Decoded address with %s", c.name)
+		}
+	}
+}
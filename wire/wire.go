@@ -0,0 +1,110 @@
+package wire
+
+import (
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Peers send each other messages in frames. A frame starts with a header
+// of the network's magic bytes, the message's command padded with zeros to
+// 12 bytes, the payload's length and the first 4 bytes of its sha512 hash,
+// then the payload itself, encoded with the codec, see Encoder.
+
+const HeaderSize = 24
+
+const commandSize = 12
+
+// Limit on any payload, whatever its command
+const MaxPayloadSize = 32 << 20
+
+var ErrBadMagic = errors.New("bad magic bytes, peer is on another network or out of step")
+var ErrChecksum = errors.New("payload doesn't match its checksum")
+
+func checksum(payload []byte) []byte {
+	hash := sha512.Sum512(payload)
+	return hash[:4]
+}
+
+// Writes a framed message with a single write, so messages from different
+// goroutines aren't interleaved
+func WriteMessage(w io.Writer, magic [4]byte, command string, payload []byte) error {
+	frame, err := Frame(magic, command, payload)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(frame)
+	return err
+}
+
+// Returns a message framed ready to send
+func Frame(magic [4]byte, command string, payload []byte) ([]byte, error) {
+	if len(command) == 0 || len(command) > commandSize {
+		return nil, errors.New("bad command " + command)
+	}
+	if len(payload) > MaxPayloadSize {
+		return nil, fmt.Errorf("%s payload of %d bytes is over the limit of %d", command, len(payload), MaxPayloadSize)
+	}
+
+	frame := make([]byte, HeaderSize, HeaderSize+len(payload))
+	copy(frame, magic[:])
+	copy(frame[4:4+commandSize], command)
+	binary.BigEndian.PutUint32(frame[16:], uint32(len(payload)))
+	copy(frame[20:], checksum(payload))
+	return append(frame, payload...), nil
+}
+
+// Reads a framed message, failing if its payload is over maxSize(command)
+// bytes before reading any of it
+func ReadMessage(r io.Reader, magic [4]byte, maxSize func(command string) int) (string, []byte, error) {
+	header := make([]byte, HeaderSize)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return "", nil, err
+	}
+	if string(header[:4]) != string(magic[:]) {
+		return "", nil, ErrBadMagic
+	}
+	command, err := parseCommand(header[4 : 4+commandSize])
+	if err != nil {
+		return "", nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header[16:])
+	limit := maxSize(command)
+	if limit > MaxPayloadSize {
+		limit = MaxPayloadSize
+	}
+	if uint64(length) > uint64(limit) {
+		return command, nil, fmt.Errorf("%s payload of %d bytes is over the limit of %d", command, length, limit)
+	}
+
+	payload := make([]byte, length)
+	_, err = io.ReadFull(r, payload)
+	if err != nil {
+		return command, nil, err
+	}
+	if string(checksum(payload)) != string(header[20:]) {
+		return command, nil, ErrChecksum
+	}
+	return command, payload, nil
+}
+
+// Reads a command, printable ascii padded with zeros
+func parseCommand(b []byte) (string, error) {
+	end := len(b)
+	for end > 0 && b[end-1] == 0 {
+		end--
+	}
+	if end == 0 {
+		return "", errors.New("empty command")
+	}
+	for _, c := range b[:end] {
+		if c <= ' ' || c > '~' {
+			return "", errors.New("command isn't printable")
+		}
+	}
+	return string(b[:end]), nil
+}
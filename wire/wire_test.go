@@ -0,0 +1,69 @@
+package wire
+
+import (
+	"bytes"
+	"testing"
+)
+
+var testMagic = [4]byte{1, 2, 3, 4}
+
+func anySize(command string) int {
+	return MaxPayloadSize
+}
+
+func TestMessageRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	WriteMessage(&buf, testMagic, "block", []byte("payload"))
+	WriteMessage(&buf, testMagic, "verack", []byte{})
+	if buf.Len() != 2*HeaderSize+len("payload") {
+		t.Errorf("Wrong frame sizes, %d bytes", buf.Len())
+	}
+
+	command, payload, err := ReadMessage(&buf, testMagic, anySize)
+	if err != nil || command != "block" || string(payload) != "payload" {
+		t.Errorf("Read %s %q: %v", command, payload, err)
+	}
+	command, payload, err = ReadMessage(&buf, testMagic, anySize)
+	if err != nil || command != "verack" || len(payload) != 0 {
+		t.Errorf("Read %s %q: %v", command, payload, err)
+	}
+}
+
+func TestBadMessages(t *testing.T) {
+	var buf bytes.Buffer
+	WriteMessage(&buf, testMagic, "block", []byte("payload"))
+	frame := buf.Bytes()
+
+	read := func(frame []byte, maxSize func(string) int) error {
+		_, _, err := ReadMessage(bytes.NewReader(frame), testMagic, maxSize)
+		return err
+	}
+	if read(frame, anySize) != nil {
+		t.Fatalf("Good frame failed")
+	}
+	if read(frame, func(string) int { return 6 }) == nil {
+		t.Errorf("Read payload over the limit")
+	}
+
+	change := func(i int, b byte) []byte {
+		changed := append([]byte{}, frame...)
+		changed[i] = b
+		return changed
+	}
+	if read(change(0, 0), anySize) != ErrBadMagic {
+		t.Errorf("Read bad magic")
+	}
+	if read(change(len(frame)-1, 'x'), anySize) != ErrChecksum {
+		t.Errorf("Read bad checksum")
+	}
+	if read(change(4, 0), anySize) == nil || read(change(4, '\n'), anySize) == nil {
+		t.Errorf("Read bad command")
+	}
+	if read(frame[:len(frame)-1], anySize) == nil {
+		t.Errorf("Read truncated frame")
+	}
+
+	if WriteMessage(&buf, testMagic, "much_too_long", nil) == nil {
+		t.Errorf("Wrote command over 12 bytes")
+	}
+}
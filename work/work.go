@@ -3,8 +3,10 @@ package work
 import (
 	"crypto/sha512"
 	"encoding/binary"
+	"encoding/hex"
 	"github.com/frankh/arachnacoin/block"
 	"github.com/frankh/arachnacoin/transaction"
+	"github.com/frankh/arachnacoin/wire"
 )
 
 var Difficulty uint32 = 0xffffff00
@@ -36,21 +38,44 @@ func ValidateBlockWork(b block.Block) bool {
 	return ValidateWork(b.Hash(), b.Work)
 }
 
+func ValidateHeaderWork(h block.Header) bool {
+	hash, err := hex.DecodeString(h.Hash)
+	if err != nil {
+		return false
+	}
+	return ValidateWork(hash, h.Work)
+}
+
+// Mines a block of as many of the transactions, in order, as fit under
+// block.MaxBlockSize
 func Mine(previous block.Block, transactions []transaction.Transaction, rewardAccount string) block.Block {
-	transactions = append(transactions, transaction.Transaction{
-		"blockReward",
-		rewardAccount,
-		5000,
-		"unsigned",
-		previous.HashString(),
-	})
+	reward := transaction.Transaction{
+		Input:     "blockReward",
+		Output:    rewardAccount,
+		Amount:    block.BlockReward,
+		Signature: "unsigned",
+		Unique:    previous.HashString(),
+	}
 
 	b := block.Block{
-		previous.HashString(),
-		0x0, //empty work to start with
-		previous.Height + 1,
-		transactions,
+		Previous:     previous.HashString(),
+		Work:         0x0, //empty work to start with
+		Height:       previous.Height + 1,
+		Transactions: []transaction.Transaction{reward},
+	}
+
+	// The rest wait for a later block. Room is left for the count of
+	// transactions growing.
+	size := wire.BlockSize(&b) + binary.MaxVarintLen64
+	included := make([]transaction.Transaction, 0, len(transactions)+1)
+	for i := range transactions {
+		size += wire.TransactionSize(&transactions[i])
+		if size > block.MaxBlockSize {
+			break
+		}
+		included = append(included, transactions[i])
 	}
+	b.Transactions = append(included, reward)
 
 	b.Work = GenerateWork(b)
 	return b
@@ -3,6 +3,9 @@ package work
 import (
 	"github.com/frankh/arachnacoin/block"
 	"github.com/frankh/arachnacoin/transaction"
+	"github.com/frankh/arachnacoin/wire"
+	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -24,6 +27,23 @@ func TestValidateWork(t *testing.T) {
 	}
 }
 
+func TestValidateHeaderWork(t *testing.T) {
+	Difficulty = 0xff000000
+	b := Mine(block.GenesisBlock, make([]transaction.Transaction, 0), "unspendable")
+	header := b.Header()
+	if !ValidateHeaderWork(header) {
+		t.Errorf("Work failed on mined block's header")
+	}
+	header.Work++
+	if ValidateHeaderWork(header) != ValidateWork(b.Hash(), header.Work) {
+		t.Errorf("Header work validated differently to block work")
+	}
+	header.Hash = "not hex"
+	if ValidateHeaderWork(header) {
+		t.Errorf("Header with a bad hash passed validation")
+	}
+}
+
 func TestMine(t *testing.T) {
 	Difficulty = 0xff000000
 	b := Mine(block.GenesisBlock, make([]transaction.Transaction, 0), "unspendable")
@@ -32,3 +52,22 @@ func TestMine(t *testing.T) {
 		t.Errorf("Work failed on mined block")
 	}
 }
+
+func TestMineSizeLimit(t *testing.T) {
+	Difficulty = 0xff000000
+	data := strings.Repeat("ab", transaction.MaxDataSize)
+	ts := make([]transaction.Transaction, 5000)
+	for i := range ts {
+		ts[i] = transaction.Transaction{Unique: strconv.Itoa(i), Data: data}
+	}
+	b := Mine(block.GenesisBlock, ts, "unspendable")
+
+	// As many as fit, in order, then the reward
+	n := len(b.Transactions) - 1
+	if wire.BlockSize(&b) > block.MaxBlockSize || n == len(ts) {
+		t.Fatalf("Mined %d transactions in %d bytes", n, wire.BlockSize(&b))
+	}
+	if b.Transactions[n-1].Unique != strconv.Itoa(n-1) || b.Transactions[n].Input != "blockReward" {
+		t.Errorf("Transactions not taken in order")
+	}
+}